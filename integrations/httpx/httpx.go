@@ -0,0 +1,225 @@
+// Package httpx is the HTTP transport shared by the vendor clients. It sends
+// JSON API requests with timeouts, cancellation, retries with jittered backoff
+// and redacted logging. Vendors only plug in how to sign their requests and
+// how to classify their error responses.
+package httpx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/tidwall/gjson"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultTimeout is the timeout of a single attempt of a request.
+	DefaultTimeout = 30 * time.Second
+	// DefaultMaxAttempts is the number of attempts of a retryable request.
+	DefaultMaxAttempts = 3
+	// DefaultBackoff is the backoff before the first retry, which doubles on
+	// every retry.
+	DefaultBackoff = time.Second
+
+	// maxLoggedBody is the number of response body bytes that are logged.
+	maxLoggedBody = 2048
+)
+
+// ErrRetryable is wrapped by classifier errors of responses that can be
+// retried, e.g. rate limits.
+var ErrRetryable = errors.New("retryable")
+
+// DefaultHTTPClient is the HTTP client shared by all vendors.
+var DefaultHTTPClient = &http.Client{Timeout: DefaultTimeout}
+
+// Client sends requests to a vendor API.
+type Client struct {
+	// HTTP is the underlying HTTP client. Defaults to DefaultHTTPClient.
+	HTTP *http.Client
+	// Sign is called on every attempt with a fresh copy of the request, e.g.
+	// to set the timestamp, access token and signature.
+	Sign func(req *http.Request) error
+	// Classify returns nil if the response is successful. Errors wrapping
+	// ErrRetryable are retried, and other errors are returned as is. Non-2xx
+	// responses are classified as errors before Classify is called.
+	Classify func(res *http.Response, body gjson.Result) error
+	// MaxAttempts defaults to DefaultMaxAttempts.
+	MaxAttempts int
+	// Backoff defaults to DefaultBackoff.
+	Backoff time.Duration
+	// Logger defaults to the standard logger. The context of the request is
+	// attached to its entries, e.g. for the ID of the job run.
+	Logger *log.Entry
+}
+
+// Do sends the request, and returns its parsed JSON response. Network errors,
+// 5xx and 429 responses, and retryable classifier errors are retried until
+// the context is done.
+func (c *Client) Do(ctx context.Context, req *http.Request) (*gjson.Result, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, fmt.Errorf("read body: %v", err)
+		}
+		req.Body.Close()
+	}
+
+	attempts := c.MaxAttempts
+	if attempts <= 0 {
+		attempts = DefaultMaxAttempts
+	}
+	backoff := c.Backoff
+	if backoff <= 0 {
+		backoff = DefaultBackoff
+	}
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			// Jitter the backoff, so that clients don't retry in lockstep.
+			wait := time.Duration(rand.Int63n(int64(backoff))) + backoff/2
+			c.logger(ctx).WithFields(log.Fields{
+				"url":     Redact(req.URL),
+				"attempt": attempt,
+				"error":   err.Error(),
+			}).Warnf("Retrying request in %v", wait)
+			select {
+			case <-ctx.Done():
+				return nil, fmt.Errorf("%v: %w", err, ctx.Err())
+			case <-time.After(wait):
+			}
+			backoff *= 2
+		}
+
+		var gres *gjson.Result
+		var retryable bool
+		gres, retryable, err = c.attempt(ctx, req, body)
+		if err == nil {
+			return gres, nil
+		}
+		if !retryable || ctx.Err() != nil {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+func (c *Client) attempt(ctx context.Context, original *http.Request, body []byte) (*gjson.Result, bool, error) {
+	req := original.Clone(ctx)
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	if body != nil {
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+	}
+	if c.Sign != nil {
+		if err := c.Sign(req); err != nil {
+			return nil, false, fmt.Errorf("sign request: %v", err)
+		}
+	}
+
+	start := time.Now()
+	client := c.HTTP
+	if client == nil {
+		client = DefaultHTTPClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		// Network errors are retryable, unless the context is done.
+		return nil, true, fmt.Errorf("http request: %v", redactString(err.Error()))
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, true, fmt.Errorf("read body: %v", err)
+	}
+
+	logger := c.logger(ctx).WithFields(log.Fields{
+		"method":   req.Method,
+		"url":      Redact(req.URL),
+		"status":   res.StatusCode,
+		"duration": time.Since(start).String(),
+	})
+	logger.Debugln("Vendor request")
+	if logger.Logger.IsLevelEnabled(log.TraceLevel) {
+		logged := b
+		if len(logged) > maxLoggedBody {
+			logged = logged[:maxLoggedBody]
+		}
+		logger.Traceln(redactString(string(logged)))
+	}
+
+	if res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500 {
+		return nil, true, fmt.Errorf("%s %s: status %d", req.Method, req.URL.Path, res.StatusCode)
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, false, fmt.Errorf("%s %s: status %d, %s", req.Method, req.URL.Path, res.StatusCode, redactString(string(b)))
+	}
+	gres := gjson.ParseBytes(b)
+	if c.Classify != nil {
+		if err := c.Classify(res, gres); err != nil {
+			return nil, errors.Is(err, ErrRetryable), err
+		}
+	}
+	return &gres, false, nil
+}
+
+func (c *Client) logger(ctx context.Context) *log.Entry {
+	if c.Logger != nil {
+		return c.Logger.WithContext(ctx)
+	}
+	return log.WithContext(ctx)
+}
+
+// secretParams are the query params that are never logged.
+var secretParams = []string{
+	"access_token",
+	"refresh_token",
+	"app_secret",
+	"partner_key",
+	"sign",
+	"code",
+	"auth_code",
+	"password",
+}
+
+// secretPattern matches the secret JSON fields and query params in errors and
+// response bodies. The JSON `code` field is not a secret, but an error code.
+var secretPattern = regexp.MustCompile(
+	`("(?:access_token|refresh_token|app_secret|partner_key|password)"\s*:\s*)"[^"]*"` +
+		`|((?:^|[?&])(?:access_token|refresh_token|app_secret|partner_key|sign|code|auth_code|password)=)[^&\s"]*`)
+
+// Redact returns the URL with its secret query params redacted.
+func Redact(u *url.URL) string {
+	redacted := *u
+	query := u.Query()
+	for _, param := range secretParams {
+		if query.Has(param) {
+			query.Set(param, "REDACTED")
+		}
+	}
+	redacted.RawQuery = query.Encode()
+	return redacted.String()
+}
+
+// redactString redacts the secret JSON fields and query params in s.
+func redactString(s string) string {
+	return secretPattern.ReplaceAllStringFunc(s, func(match string) string {
+		groups := secretPattern.FindStringSubmatch(match)
+		if groups[1] != "" {
+			return groups[1] + `"REDACTED"`
+		}
+		return groups[2] + "REDACTED"
+	})
+}
@@ -0,0 +1,229 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tidwall/gjson"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// server responds with the statuses in order, and then with 200.
+type server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	statuses []int
+	bodies   []string
+}
+
+func newServer(t *testing.T, statuses ...int) *server {
+	s := &server{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		s.bodies = append(s.bodies, string(body))
+		status := http.StatusOK
+		if len(s.statuses) > 0 {
+			status, s.statuses = s.statuses[0], s.statuses[1:]
+		}
+		s.mu.Unlock()
+		w.WriteHeader(status)
+		fmt.Fprint(w, `{"ok": true}`)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *server) attempts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.bodies)
+}
+
+// quiet discards the logs of the retries.
+func quiet() *log.Entry {
+	logger := log.New()
+	logger.SetOutput(io.Discard)
+	return log.NewEntry(logger)
+}
+
+func newRequest(t *testing.T, method, rawURL, body string) *http.Request {
+	req, err := http.NewRequest(method, rawURL, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	return req
+}
+
+func TestDoRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantErr      bool
+		wantAttempts int
+	}{
+		{name: "ok", wantAttempts: 1},
+		{name: "5xx", statuses: []int{500, 503}, wantAttempts: 3},
+		{name: "429", statuses: []int{429}, wantAttempts: 2},
+		{name: "too many 5xx", statuses: []int{502, 502, 502}, wantErr: true, wantAttempts: 3},
+		{name: "4xx", statuses: []int{400}, wantErr: true, wantAttempts: 1},
+		{name: "404", statuses: []int{404}, wantErr: true, wantAttempts: 1},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newServer(t, tc.statuses...)
+			client := &Client{Backoff: time.Millisecond, Logger: quiet()}
+			gres, err := client.Do(context.Background(), newRequest(t, http.MethodGet, srv.URL, ""))
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Do() error = %v, want error %t", err, tc.wantErr)
+			}
+			if err == nil && !gres.Get("ok").Bool() {
+				t.Errorf("Do() = %s, want the response", gres.Raw)
+			}
+			if got := srv.attempts(); got != tc.wantAttempts {
+				t.Errorf("attempts = %d, want %d", got, tc.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestDoReplaysBody(t *testing.T) {
+	srv := newServer(t, 500, 500)
+	var signed int
+	client := &Client{
+		Backoff: time.Millisecond,
+		Logger:  quiet(),
+		Sign: func(req *http.Request) error {
+			signed++
+			req.Header.Set("X-Sign", "sign")
+			return nil
+		},
+	}
+	if _, err := client.Do(context.Background(), newRequest(t, http.MethodPost, srv.URL, "quantity=5")); err != nil {
+		t.Fatalf("Do(): %v", err)
+	}
+	for i, body := range srv.bodies {
+		if body != "quantity=5" {
+			t.Errorf("attempt %d body = %q, want %q", i+1, body, "quantity=5")
+		}
+	}
+	if signed != 3 {
+		t.Errorf("signed %d times, want once per attempt", signed)
+	}
+}
+
+func TestDoClassify(t *testing.T) {
+	errRejected := errors.New("rejected")
+	tests := []struct {
+		name         string
+		err          error
+		wantAttempts int
+	}{
+		{name: "retryable", err: fmt.Errorf("%w: rate limit", ErrRetryable), wantAttempts: 3},
+		{name: "not retryable", err: errRejected, wantAttempts: 1},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newServer(t)
+			client := &Client{
+				Backoff: time.Millisecond,
+				Logger:  quiet(),
+				Classify: func(res *http.Response, body gjson.Result) error {
+					return tc.err
+				},
+			}
+			_, err := client.Do(context.Background(), newRequest(t, http.MethodGet, srv.URL, ""))
+			if !errors.Is(err, tc.err) {
+				t.Errorf("Do() error = %v, want %v", err, tc.err)
+			}
+			if got := srv.attempts(); got != tc.wantAttempts {
+				t.Errorf("attempts = %d, want %d", got, tc.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestDoCancelled(t *testing.T) {
+	srv := newServer(t, 503, 503, 503)
+	ctx, cancel := context.WithCancel(context.Background())
+	client := &Client{
+		// The backoff would outlast the test, unless the cancellation stops
+		// the retries.
+		Backoff: time.Hour,
+		Logger:  quiet(),
+		Classify: func(res *http.Response, body gjson.Result) error {
+			return nil
+		},
+	}
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := client.Do(ctx, newRequest(t, http.MethodGet, srv.URL, ""))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Do() error = %v, want %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Do() returned after %v, want it stopped by the cancellation", elapsed)
+	}
+	if got := srv.attempts(); got != 1 {
+		t.Errorf("attempts = %d, want 1", got)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	u, err := url.Parse("https://api.example.com/orders?access_token=secret-token&sign=secret-sign&shop_id=42&code=secret-code")
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+	got := Redact(u)
+	for _, secret := range []string{"secret-token", "secret-sign", "secret-code"} {
+		if strings.Contains(got, secret) {
+			t.Errorf("Redact() = %q, contains %q", got, secret)
+		}
+	}
+	if !strings.Contains(got, "shop_id=42") {
+		t.Errorf("Redact() = %q, want the other params kept", got)
+	}
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "json",
+			in:   `{"access_token": "tok", "refresh_token":"ref", "code": "0", "message": "ok"}`,
+			want: `{"access_token": "REDACTED", "refresh_token":"REDACTED", "code": "0", "message": "ok"}`,
+		},
+		{
+			name: "query",
+			in:   `Get "https://api.example.com/a?app_key=1&sign=abc&access_token=tok": timeout`,
+			want: `Get "https://api.example.com/a?app_key=1&sign=REDACTED&access_token=REDACTED": timeout`,
+		},
+		{
+			name: "form body",
+			in:   `partner_key=key&password=pw&quantity=5`,
+			want: `partner_key=REDACTED&password=REDACTED&quantity=5`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := redactString(tc.in); got != tc.want {
+				t.Errorf("redactString() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -1,16 +1,80 @@
 package intent
 
 import (
+	"encoding/json"
+
 	"github.com/nmcapule/oclz-go/integrations/models"
+	"github.com/nmcapule/oclz-go/utils"
+	"github.com/tidwall/gjson"
 )
 
 const Vendor = "DEFAULT"
 
-// TODO(nmcapule): If there are configs for intent tenant, add here.
+// Config is an intent config.
 type Config struct {
+	// Locations are the names of the stock locations, e.g. warehouses. The
+	// first location receives stocks that are not assigned to any location.
+	// If empty, the intent only keeps the total stocks. Otherwise, the stocks
+	// of each location are edited in the `locations` tenant prop of the intent
+	// items, and the total stocks follow.
+	Locations []string `json:"locations"`
 }
 
 type Client struct {
 	*models.BaseDatabaseTenant
 	Config *Config
 }
+
+func init() {
+	models.RegisterVendor(Vendor, models.CredentialsNone, func(deps *models.VendorDeps, config *Config) (models.IntegrationClient, error) {
+		return &Client{
+			BaseDatabaseTenant: &models.BaseDatabaseTenant{
+				BaseTenant: deps.Tenant,
+				Dao:        deps.Dao,
+			},
+			Config: config,
+		}, nil
+	})
+}
+
+// HasLocations returns true if the intent keeps stocks per location.
+func (c *Client) HasLocations() bool {
+	return len(c.Config.Locations) > 0
+}
+
+// LocationStocks returns the stocks of the item per location, which are kept
+// in the `locations` tenant prop. Stocks that are not assigned to a location,
+// e.g. items recorded before locations were configured, are assigned to the
+// first location.
+func (c *Client) LocationStocks(item *models.Item) map[string]int {
+	stocks := make(map[string]int)
+	if !c.HasLocations() {
+		return stocks
+	}
+	if item.TenantProps != nil {
+		item.TenantProps.Get("locations").ForEach(func(key, value gjson.Result) bool {
+			stocks[key.String()] = int(value.Int())
+			return true
+		})
+	}
+	if len(stocks) == 0 {
+		stocks[c.Config.Locations[0]] = item.Stocks
+	}
+	return stocks
+}
+
+// SetLocationStocks sets the stocks per location of the item, and its total
+// stocks.
+func (c *Client) SetLocationStocks(item *models.Item, stocks map[string]int) {
+	props := make(map[string]any)
+	if item.TenantProps != nil && item.TenantProps.Raw != "" {
+		_ = json.Unmarshal([]byte(item.TenantProps.Raw), &props)
+	}
+	props["locations"] = stocks
+	item.TenantProps = utils.GJSONFrom(props)
+
+	item.Stocks = 0
+	for _, n := range stocks {
+		item.Stocks += n
+	}
+}
@@ -1,6 +1,7 @@
 package lazada
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -14,7 +15,7 @@ func (c *Client) CredentialsManager() oauth2.CredentialsManager {
 	return c
 }
 
-func (c *Client) GenerateAuthorizationURL() string {
+func (c *Client) GenerateAuthorizationURL(state string) string {
 	endpoint := "https://auth.lazada.com/oauth/authorize"
 
 	return c.url(endpoint, url.Values{
@@ -22,11 +23,12 @@ func (c *Client) GenerateAuthorizationURL() string {
 		"force_auth":    []string{"true"},
 		"redirect_uri":  []string{c.Config.RedirectURI},
 		"client_id":     []string{c.Config.AppKey},
+		"state":         []string{state},
 	}).String()
 }
 
-func (c *Client) GenerateCredentials(greq gjson.Result) (*oauth2.Credentials, error) {
-	gres, err := c.request(&http.Request{
+func (c *Client) GenerateCredentials(ctx context.Context, greq gjson.Result) (*oauth2.Credentials, error) {
+	gres, err := c.request(ctx, &http.Request{
 		Method: http.MethodGet,
 		URL: c.url("https://auth.lazada.com/rest/auth/token/create", url.Values{
 			"code": []string{greq.Get("code").String()},
@@ -36,37 +38,63 @@ func (c *Client) GenerateCredentials(greq gjson.Result) (*oauth2.Credentials, er
 		return nil, fmt.Errorf("error response: %v", err)
 	}
 
-	c.Credentials = &oauth2.Credentials{
-		Tenant:       c.ID,
-		AccessToken:  gres.Get("access_token").String(),
-		RefreshToken: gres.Get("refresh_token").String(),
-		Expires:      time.Now().Add(time.Duration(gres.Get("expires_in").Int()) * time.Second),
-	}
-
-	return c.Credentials, err
+	return &oauth2.Credentials{
+		Tenant:         c.ID,
+		AccessToken:    gres.Get("access_token").String(),
+		RefreshToken:   gres.Get("refresh_token").String(),
+		Expires:        time.Now().Add(time.Duration(gres.Get("expires_in").Int()) * time.Second),
+		RefreshExpires: time.Now().Add(time.Duration(gres.Get("refresh_expires_in").Int()) * time.Second),
+		Source:         oauth2.SourceAuthorize,
+	}, nil
 }
 
-func (c *Client) RefreshCredentials() (*oauth2.Credentials, error) {
-	gres, err := c.request(&http.Request{
+func (c *Client) RefreshCredentials(ctx context.Context) (*oauth2.Credentials, error) {
+	gres, err := c.request(ctx, &http.Request{
 		Method: http.MethodGet,
 		URL: c.url("https://auth.lazada.com/rest/auth/token/refresh", url.Values{
-			"refresh_token": []string{c.Credentials.RefreshToken},
+			"refresh_token": []string{c.CurrentCredentials().RefreshToken},
 		}),
 	}, tokenRetrievalMode)
 	if err != nil {
 		return nil, fmt.Errorf("error response: %v", err)
 	}
 
-	c.Credentials = &oauth2.Credentials{
-		Tenant:       c.ID,
-		AccessToken:  gres.Get("access_token").String(),
-		RefreshToken: gres.Get("refresh_token").String(),
-		Expires:      time.Now().Add(time.Duration(gres.Get("expires_in").Int()) * time.Second),
-	}
+	return &oauth2.Credentials{
+		Tenant:         c.ID,
+		AccessToken:    gres.Get("access_token").String(),
+		RefreshToken:   gres.Get("refresh_token").String(),
+		Expires:        time.Now().Add(time.Duration(gres.Get("expires_in").Int()) * time.Second),
+		RefreshExpires: time.Now().Add(time.Duration(gres.Get("refresh_expires_in").Int()) * time.Second),
+		Source:         oauth2.SourceRefresh,
+	}, nil
+}
 
-	return c.Credentials, err
+func (c *Client) ProbeCredentials(ctx context.Context, credentials *oauth2.Credentials) error {
+	probe := &Client{
+		BaseTenant:  c.BaseTenant,
+		Config:      c.Config,
+		Credentials: credentials,
+		Dao:         c.Dao,
+	}
+	_, err := probe.doRequest(ctx, &http.Request{
+		Method: http.MethodGet,
+		URL:    probe.url("/seller/get", nil),
+	})
+	return err
 }
 
 func (c *Client) CredentialsExpiry() time.Time {
-	return c.Credentials.Expires
+	return c.CurrentCredentials().Expires
+}
+
+func (c *Client) CurrentCredentials() *oauth2.Credentials {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Credentials
+}
+
+func (c *Client) SetCredentials(credentials *oauth2.Credentials) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Credentials = credentials
 }
@@ -2,18 +2,23 @@
 package lazada
 
 import (
+	"bytes"
+	"context"
+	"encoding/xml"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/nmcapule/oclz-go/integrations/models"
 	"github.com/nmcapule/oclz-go/oauth2"
 	"github.com/nmcapule/oclz-go/utils"
 	"github.com/nmcapule/oclz-go/utils/scheduler"
+	"github.com/pocketbase/pocketbase/daos"
 	"github.com/tidwall/gjson"
 
 	log "github.com/sirupsen/logrus"
@@ -23,28 +28,63 @@ const Vendor = "LAZADA"
 
 // Config is a Lazada config.
 type Config struct {
-	Domain      string `json:"domain"`
-	AppKey      string `json:"app_key"`
-	AppSecret   string `json:"app_secret"`
+	Domain      string `json:"domain" jsonschema:"required"`
+	AppKey      string `json:"app_key" jsonschema:"required"`
+	AppSecret   string `json:"app_secret" jsonschema:"required,secret"`
 	RedirectURI string `json:"redirect_uri"`
+	// WarehouseCode is the Lazada warehouse whose sellable quantity is synced.
+	// Can be left empty if products are only stocked in one warehouse.
+	WarehouseCode string `json:"warehouse_code"`
+}
+
+// ErrAmbiguousWarehouse is returned when updating an item stocked in multiple
+// warehouses without a configured warehouse code.
+var ErrAmbiguousWarehouse = fmt.Errorf("%w: item is stocked in multiple warehouses, set warehouse_code", models.ErrSkipItem)
+
+// Warehouse is the stock of a SKU in a single Lazada warehouse.
+type Warehouse struct {
+	Code string `json:"code"`
+	// Sellable is the quantity that can still be ordered.
+	Sellable int `json:"sellable"`
+	// Reserved is the quantity withheld, e.g. for campaigns.
+	Reserved int `json:"reserved"`
+	// Occupied is the quantity of unshipped orders.
+	Occupied int `json:"occupied"`
 }
 
 // Client is a Lazada client.
 type Client struct {
 	*models.BaseTenant
-	Config      *Config
+	Config *Config
+	// Credentials are swapped on refreshes and reloads while requests are in
+	// flight, so they are read and set with CurrentCredentials and
+	// SetCredentials.
 	Credentials *oauth2.Credentials
+	mu          sync.Mutex
+	// Dao saves the credentials refreshed on invalid access tokens.
+	Dao *daos.Dao
+}
+
+func init() {
+	models.RegisterVendor(Vendor, models.CredentialsRequired, func(deps *models.VendorDeps, config *Config) (models.IntegrationClient, error) {
+		return &Client{
+			BaseTenant:  deps.Tenant,
+			Config:      config,
+			Credentials: deps.Credentials,
+			Dao:         deps.Dao,
+		}, nil
+	})
 }
 
 // CollectAllItems collects and returns all items registered in this client.
-func (c *Client) CollectAllItems() ([]*models.Item, error) {
+func (c *Client) CollectAllItems(ctx context.Context) ([]*models.Item, error) {
 	var items []*models.Item
 
 	var offset int
 	const limit = 50
 
 	for {
-		base, err := c.request(&http.Request{
+		base, err := c.request(ctx, &http.Request{
 			Method: http.MethodGet,
 			URL: c.url("/products/get", url.Values{
 				"offset": []string{strconv.Itoa(offset)},
@@ -56,9 +96,9 @@ func (c *Client) CollectAllItems() ([]*models.Item, error) {
 		}
 
 		for _, product := range base.Get("data.products").Array() {
-			items = append(items, parseItemsFromProduct(product)...)
+			items = append(items, c.parseItemsFromProduct(product)...)
 		}
-		log.WithFields(log.Fields{
+		log.WithContext(ctx).WithFields(log.Fields{
 			"tenant": c.Name,
 			"items":  len(items),
 			"offset": offset,
@@ -75,8 +115,8 @@ func (c *Client) CollectAllItems() ([]*models.Item, error) {
 }
 
 // LoadItem returns item info for a single SKU.
-func (c *Client) LoadItem(sku string) (*models.Item, error) {
-	base, err := c.request(&http.Request{
+func (c *Client) LoadItem(ctx context.Context, sku string) (*models.Item, error) {
+	base, err := c.request(ctx, &http.Request{
 		Method: http.MethodGet,
 		URL: c.url("/product/item/get", url.Values{
 			"seller_sku": []string{sku},
@@ -86,7 +126,7 @@ func (c *Client) LoadItem(sku string) (*models.Item, error) {
 		return nil, fmt.Errorf("send request: %v", err)
 	}
 
-	items := parseItemsFromProduct(base.Get("data"))
+	items := c.parseItemsFromProduct(base.Get("data"))
 	if len(items) == 0 {
 		return nil, models.ErrNotFound
 	}
@@ -95,10 +135,30 @@ func (c *Client) LoadItem(sku string) (*models.Item, error) {
 }
 
 // SaveItem saves item info for a single SKU.
-// This only implements updating the product stock.
-func (c *Client) SaveItem(item *models.Item) error {
+// This only implements updating the sellable quantity of the product in the
+// warehouse of the item, so reserved and occupied quantities are kept as is.
+func (c *Client) SaveItem(ctx context.Context, item *models.Item) error {
+	quantity := fmt.Sprintf(`<SellableQuantity>%d</SellableQuantity>`, item.Stocks)
+	code := c.Config.WarehouseCode
+	if code == "" {
+		code = item.TenantProps.Get("warehouse_code").String()
+	}
+	if code != "" {
+		quantity = fmt.Sprintf(`
+			<MultiWarehouseInventories>
+				<MultiWarehouseInventory>
+					<WarehouseCode>%s</WarehouseCode>
+					<SellableQuantity>%d</SellableQuantity>
+				</MultiWarehouseInventory>
+			</MultiWarehouseInventories>`,
+			xmlEscape(code),
+			item.Stocks)
+	} else if len(item.TenantProps.Get("warehouses").Array()) > 1 {
+		return ErrAmbiguousWarehouse
+	}
+
 	// Compose the payload.
-	xml := fmt.Sprintf(`
+	payload := fmt.Sprintf(`
 		<Request>
 			<Product>
 				<Skus>
@@ -106,24 +166,22 @@ func (c *Client) SaveItem(item *models.Item) error {
 						<ItemId>%d</ItemId>
 						<SkuId>%d</SkuId>
 						<SellerSku>%s</SellerSku>
-						<Quantity>%d</Quantity>
+						%s
 					</Sku>
 				</Skus>
 			</Product>
 		</Request>`,
 		item.TenantProps.Get("item_id").Int(),
 		item.TenantProps.Get("sku_id").Int(),
-		item.SellerSKU,
-		// TODO(nmcapule): Find a better way to resolve this. This depend on
-		// the syncer always giving the latest state of the item.
-		item.Stocks+int(item.TenantProps.Get("reserved").Int()))
+		xmlEscape(item.SellerSKU),
+		quantity)
 
 	// Do the actual update.
-	_, err := c.request(&http.Request{
+	_, err := c.request(ctx, &http.Request{
 		Method: http.MethodPost,
-		URL:    c.url("/product/price_quantity/update", nil),
+		URL:    c.url("/product/stock/sellable/update", nil),
 		Body: io.NopCloser(strings.NewReader(url.Values{
-			"payload": []string{xml},
+			"payload": []string{payload},
 		}.Encode())),
 	})
 	if err != nil {
@@ -131,14 +189,14 @@ func (c *Client) SaveItem(item *models.Item) error {
 	}
 
 	// Poll until the update is confirmed propagated to Lazada.
-	return scheduler.Retry(func() bool {
-		log.WithFields(log.Fields{
+	return scheduler.Retry(ctx, func() bool {
+		log.WithContext(ctx).WithFields(log.Fields{
 			"tenant":     c.Tenant().Name,
 			"seller_sku": item.SellerSKU,
 		}).Debugln("Confirming item update...")
-		live, err := c.LoadItem(item.SellerSKU)
+		live, err := c.LoadItem(ctx, item.SellerSKU)
 		if err != nil {
-			log.WithFields(log.Fields{
+			log.WithContext(ctx).WithFields(log.Fields{
 				"tenant":     c.Tenant().Name,
 				"seller_sku": item.SellerSKU,
 			}).Errorf("Failed to confirm item update: %v", err)
@@ -152,28 +210,64 @@ func (c *Client) SaveItem(item *models.Item) error {
 	})
 }
 
-func parseItemsFromProduct(product gjson.Result) []*models.Item {
+// parseItemsFromProduct parses the SKUs of the product. The stocks of an item
+// are the sellable quantity in the configured warehouse, or in all warehouses
+// if there is none. Occupied quantities of unshipped orders are the reserved
+// stocks of the item.
+func (c *Client) parseItemsFromProduct(product gjson.Result) []*models.Item {
 	var items []*models.Item
 	for _, skuRaw := range product.Get("skus").Array() {
 		sku := gjson.Parse(skuRaw.String())
 
-		var totalQuantity int64
-		for _, n := range sku.Get("multiWarehouseInventories.#.totalQuantity").Array() {
-			totalQuantity += n.Int()
+		var warehouses []Warehouse
+		for _, inventory := range sku.Get("multiWarehouseInventories").Array() {
+			warehouses = append(warehouses, Warehouse{
+				Code:     inventory.Get("warehouseCode").String(),
+				Sellable: int(inventory.Get("sellableQuantity").Int()),
+				Reserved: int(inventory.Get("withholdQuantity").Int()),
+				Occupied: int(inventory.Get("occupyQuantity").Int()),
+			})
+		}
+
+		// Resolve the warehouse to sync, if any.
+		code := c.Config.WarehouseCode
+		if code == "" && len(warehouses) == 1 {
+			code = warehouses[0].Code
+		}
+		stocks := int(sku.Get("quantity").Int())
+		var occupied, onHand int
+		if len(warehouses) > 0 {
+			stocks = 0
+			for _, warehouse := range warehouses {
+				if code == "" || warehouse.Code == code {
+					stocks += warehouse.Sellable
+					occupied += warehouse.Occupied
+					onHand += warehouse.Sellable + warehouse.Reserved + warehouse.Occupied
+				}
+			}
 		}
-		var sellableQuantity = sku.Get("quantity").Int()
 
 		items = append(items, &models.Item{
 			SellerSKU: sku.Get("SellerSku").String(),
-			Stocks:    int(sellableQuantity),
+			Stocks:    stocks,
+			Reserved:  occupied,
+			OnHand:    onHand,
 			TenantProps: utils.GJSONFrom(map[string]interface{}{
-				"item_id":  product.Get("item_id").Int(),
-				"sku_id":   sku.Get("SkuId").Int(),
-				"shop_sku": sku.Get("ShopSku").String(),
-				"price":    sku.Get("price").Float(),
-				"reserved": totalQuantity - sellableQuantity,
+				"item_id":        product.Get("item_id").Int(),
+				"sku_id":         sku.Get("SkuId").Int(),
+				"shop_sku":       sku.Get("ShopSku").String(),
+				"price":          sku.Get("price").Float(),
+				"warehouse_code": code,
+				"warehouses":     warehouses,
 			}),
 		})
 	}
 	return items
 }
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	// Writing to a buffer never fails.
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
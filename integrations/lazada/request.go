@@ -1,6 +1,7 @@
 package lazada
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
@@ -13,6 +14,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/nmcapule/oclz-go/integrations/httpx"
+	"github.com/nmcapule/oclz-go/oauth2"
 	"github.com/tidwall/gjson"
 
 	log "github.com/sirupsen/logrus"
@@ -23,6 +26,11 @@ const (
 	codeCallLimit = "ApiCallLimit"
 )
 
+// invalidTokenCodes are the error codes of invalid access tokens.
+var invalidTokenCodes = map[string]bool{
+	"IllegalAccessToken": true,
+}
+
 type requestConfig struct {
 	stripAccessToken bool
 }
@@ -48,12 +56,46 @@ func (c *Client) url(endpoint string, query url.Values) *url.URL {
 	return u
 }
 
-func (c *Client) request(req *http.Request, opts ...requestOption) (*gjson.Result, error) {
+// request sends the request. If the access token is invalid, the credentials
+// are refreshed once and the request is retried.
+func (c *Client) request(ctx context.Context, req *http.Request, opts ...requestOption) (*gjson.Result, error) {
+	var config requestConfig
+	for _, opt := range opts {
+		opt(&config)
+	}
+	if config.stripAccessToken {
+		return c.doRequest(ctx, req, opts...)
+	}
+	stale := c.CurrentCredentials()
+	return oauth2.RetryOnInvalidToken(req, func(req *http.Request) (*gjson.Result, error) {
+		return c.doRequest(ctx, req, opts...)
+	}, func() error {
+		oauth2Service := &oauth2.Service{Dao: c.Dao}
+		credentials, err := oauth2Service.Refresh(ctx, c.ID, c, stale)
+		if err != nil {
+			return err
+		}
+		c.SetCredentials(credentials)
+		return nil
+	})
+}
+
+func (c *Client) doRequest(ctx context.Context, req *http.Request, opts ...requestOption) (*gjson.Result, error) {
 	var config requestConfig
 	for _, opt := range opts {
 		opt(&config)
 	}
+	transport := &httpx.Client{
+		Sign: func(req *http.Request) error {
+			return c.sign(req, config)
+		},
+		Classify: classify,
+	}
+	return transport.Do(ctx, req)
+}
 
+// sign sets the common params and the signature of the request.
+func (c *Client) sign(req *http.Request, config requestConfig) error {
 	// Harvest endpoint and query from request.
 	baseURL, _ := url.Parse(c.Config.Domain)
 	endpoint := strings.TrimPrefix(req.URL.Path, baseURL.Path)
@@ -62,7 +104,7 @@ func (c *Client) request(req *http.Request, opts ...requestOption) (*gjson.Resul
 	query.Set("app_key", c.Config.AppKey)
 	query.Set("timestamp", strconv.FormatInt(time.Now().UnixMilli(), 10))
 	if !config.stripAccessToken {
-		query.Set("access_token", c.Credentials.AccessToken)
+		query.Set("access_token", c.CurrentCredentials().AccessToken)
 	}
 	query.Set("sign_method", "sha256")
 	if req.Method == http.MethodGet {
@@ -70,13 +112,16 @@ func (c *Client) request(req *http.Request, opts ...requestOption) (*gjson.Resul
 		req.URL.RawQuery = query.Encode()
 	} else if req.Method == http.MethodPost {
 		// If method is POST, attach the usual query params to the body.
-		body, err := io.ReadAll(req.Body)
-		if err != nil {
-			return nil, fmt.Errorf("read body: %v", err)
+		var body []byte
+		if req.Body != nil {
+			var err error
+			if body, err = io.ReadAll(req.Body); err != nil {
+				return fmt.Errorf("read body: %v", err)
+			}
 		}
 		parsedQuery, err := url.ParseQuery(string(body))
 		if err != nil {
-			return nil, fmt.Errorf("parse body: %v", err)
+			return fmt.Errorf("parse body: %v", err)
 		}
 		for key, values := range parsedQuery {
 			for _, v := range values {
@@ -84,35 +129,26 @@ func (c *Client) request(req *http.Request, opts ...requestOption) (*gjson.Resul
 			}
 		}
 		query.Set("sign", signature(c.Config.AppSecret, endpoint, query))
-		req.Body = io.NopCloser(strings.NewReader(query.Encode()))
+		encoded := query.Encode()
+		req.Body = io.NopCloser(strings.NewReader(encoded))
+		req.ContentLength = int64(len(encoded))
 	}
+	return nil
+}
 
-	retry := 3
-	backoff := 1
-	var gj gjson.Result
-	for retry > 0 {
-		res, err := http.DefaultClient.Do(req)
-		if err != nil {
-			return nil, fmt.Errorf("http request: %v", err)
-		}
-		b, err := io.ReadAll(res.Body)
-		if err != nil {
-			return nil, fmt.Errorf("read body: %v", err)
-		}
-		gj = gjson.ParseBytes(b)
-		if gj.Get("code").String() == codeOk {
-			break
-		}
-		if gj.Get("code").String() == codeCallLimit {
-			log.Warnln("api call exceeded, retrying...")
-			time.Sleep(time.Duration(backoff) * time.Second)
-			retry -= 1
-			backoff *= 2
-			continue
-		}
-		return nil, fmt.Errorf("%s, %s", gj.Get("code").String(), gj.Get("message"))
+// classify checks the error code of the response.
+func classify(res *http.Response, gres gjson.Result) error {
+	code := gres.Get("code").String()
+	switch {
+	case code == codeOk:
+		return nil
+	case code == codeCallLimit:
+		return fmt.Errorf("%w: %s, %s", httpx.ErrRetryable, code, gres.Get("message"))
+	case invalidTokenCodes[code]:
+		return fmt.Errorf("%w: %s, %s", oauth2.ErrInvalidToken, code, gres.Get("message"))
+	default:
+		return fmt.Errorf("%s, %s", code, gres.Get("message"))
 	}
-	return &gj, nil
 }
 
 func signature(key, endpoint string, query url.Values) string {
@@ -6,4 +6,8 @@ var (
 	ErrNotFound      = errors.New("not found")
 	ErrMultipleItems = errors.New("unexpected multiple items retrieved")
 	ErrUnimplemented = errors.New("not yet implemented")
+	// ErrSkipItem is returned by SaveItem for items that can't be updated
+	// until the tenant is configured further. The item is skipped instead of
+	// failing the sync.
+	ErrSkipItem = errors.New("item skipped")
 )
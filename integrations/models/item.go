@@ -10,10 +10,17 @@ import (
 
 // Item is an interface for any items for any vendors.
 type Item struct {
-	ID          string
-	TenantID    string
-	SellerSKU   string
-	Stocks      int
+	ID        string
+	TenantID  string
+	SellerSKU string
+	// Stocks is the available quantity, which can still be ordered. This is
+	// the quantity pushed to the tenants.
+	Stocks int
+	// Reserved is the quantity of pending orders awaiting shipment.
+	Reserved int
+	// OnHand is the physical quantity, including reserved stocks. Zero if not
+	// reported by the vendor, see OnHandStocks.
+	OnHand      int
 	TenantProps *gjson.Result
 	Created     time.Time
 	Updated     time.Time
@@ -27,6 +34,8 @@ func ItemFrom(record *pbm.Record) *Item {
 		TenantID:    record.GetString("tenant"),
 		SellerSKU:   record.GetString("seller_sku"),
 		Stocks:      record.GetInt("stocks"),
+		Reserved:    record.GetInt("reserved"),
+		OnHand:      record.GetInt("on_hand"),
 		TenantProps: &tenantProps,
 		Created:     record.GetTime("created"),
 		Updated:     record.GetTime("updated"),
@@ -43,6 +52,17 @@ func (i *Item) ToRecord(collection *pbm.Collection) *pbm.Record {
 	record.Set("tenant", i.TenantID)
 	record.Set("seller_sku", i.SellerSKU)
 	record.Set("stocks", i.Stocks)
+	record.Set("reserved", i.Reserved)
+	record.Set("on_hand", i.OnHand)
 	record.Set("tenant_props", i.TenantProps.Raw)
 	return record
 }
+
+// OnHandStocks returns the on-hand stocks of the item. If the vendor does not
+// report it, this is the available plus the reserved stocks.
+func (i *Item) OnHandStocks() int {
+	if i.OnHand == 0 {
+		return i.Stocks + i.Reserved
+	}
+	return i.OnHand
+}
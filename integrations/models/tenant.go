@@ -1,7 +1,9 @@
 package models
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 
 	"github.com/nmcapule/oclz-go/oauth2"
 
@@ -10,36 +12,85 @@ import (
 	pbm "github.com/pocketbase/pocketbase/models"
 )
 
-// Daemon is a background running service.
+// Daemon is a background running service. Start blocks until the service
+// finishes or the context is cancelled.
 type Daemon interface {
-	Start() error
+	Start(ctx context.Context) error
 }
 
-// IntegrationClient is an interface for any vendor clients.
+// IntegrationClient is an interface for any vendor clients. Calls to the
+// vendor are cancelled once the context is done.
 type IntegrationClient interface {
 	Tenant() *BaseTenant
-	CollectAllItems() ([]*Item, error)
-	LoadItem(sku string) (*Item, error)
-	SaveItem(item *Item) error
+	CollectAllItems(ctx context.Context) ([]*Item, error)
+	LoadItem(ctx context.Context, sku string) (*Item, error)
+	SaveItem(ctx context.Context, item *Item) error
 	CredentialsManager() oauth2.CredentialsManager
 	Daemon() Daemon
 }
 
+// SyncMode is the sync direction of a tenant. It is set by the `sync_mode`
+// option of the tenant config, and is ignored for the intent tenant.
+type SyncMode string
+
+const (
+	// SyncBoth pulls the changes of the tenant and pushes to it. This is the
+	// default.
+	SyncBoth SyncMode = ""
+	// SyncSourceOnly pulls the changes of the tenant, but never pushes to it.
+	SyncSourceOnly SyncMode = "source_only"
+	// SyncSinkOnly pushes to the tenant, but ignores its changes.
+	SyncSinkOnly SyncMode = "sink_only"
+	// SyncObserve only collects the items of the tenant, e.g. for reports.
+	SyncObserve SyncMode = "observe"
+)
+
+// TenantOptions are the tenant config options common to all vendors.
+type TenantOptions struct {
+	SyncMode SyncMode `json:"sync_mode"`
+}
+
+// Validate checks that the options are supported.
+func (o *TenantOptions) Validate() error {
+	switch o.SyncMode {
+	case SyncBoth, SyncSourceOnly, SyncSinkOnly, SyncObserve:
+		return nil
+	default:
+		return fmt.Errorf("unsupported sync_mode %q", o.SyncMode)
+	}
+}
+
 type BaseTenant struct {
 	ID          string
 	Name        string
 	Vendor      string
 	Config      json.RawMessage
 	TenantGroup string
+	// Locations are the intent stock locations that the tenant ships from.
+	// Empty means all locations.
+	Locations []string
+	SyncMode  SyncMode
 }
 
 func TenantFrom(record *pbm.Record) *BaseTenant {
+	var locations []string
+	if raw := record.GetString("locations"); raw != "" {
+		// Ignore invalid locations, same as having no locations.
+		_ = json.Unmarshal([]byte(raw), &locations)
+	}
+	var options TenantOptions
+	if raw := record.GetString("config"); raw != "" {
+		// Invalid options are rejected when the vendor config is validated.
+		_ = json.Unmarshal([]byte(raw), &options)
+	}
 	return &BaseTenant{
 		ID:          record.GetId(),
 		Name:        record.GetString("name"),
 		Vendor:      record.GetString("vendor"),
 		Config:      json.RawMessage(record.GetString("config")),
 		TenantGroup: record.GetString("tenant_group"),
+		Locations:   locations,
+		SyncMode:    options.SyncMode,
 	}
 }
 
@@ -47,6 +98,16 @@ func (b *BaseTenant) Tenant() *BaseTenant {
 	return b
 }
 
+// CanPull returns true if the changes of the tenant are synced.
+func (b *BaseTenant) CanPull() bool {
+	return b.SyncMode == SyncBoth || b.SyncMode == SyncSourceOnly
+}
+
+// CanPush returns true if the tenant receives the synced stocks.
+func (b *BaseTenant) CanPush() bool {
+	return b.SyncMode == SyncBoth || b.SyncMode == SyncSinkOnly
+}
+
 // BaseDatabaseTenant is a base tenant, with default implementations directly
 // connected to the database.
 type BaseDatabaseTenant struct {
@@ -54,7 +115,7 @@ type BaseDatabaseTenant struct {
 	Dao *daos.Dao
 }
 
-func (c *BaseDatabaseTenant) CollectAllItems() ([]*Item, error) {
+func (c *BaseDatabaseTenant) CollectAllItems(ctx context.Context) ([]*Item, error) {
 	inventory, err := c.Dao.FindRecordsByExpr("tenant_inventory", dbx.HashExp{
 		"tenant": c.ID,
 	})
@@ -68,7 +129,7 @@ func (c *BaseDatabaseTenant) CollectAllItems() ([]*Item, error) {
 	return items, nil
 }
 
-func (c *BaseDatabaseTenant) LoadItem(sellerSKU string) (*Item, error) {
+func (c *BaseDatabaseTenant) LoadItem(ctx context.Context, sellerSKU string) (*Item, error) {
 	inventory, err := c.Dao.FindRecordsByExpr("tenant_inventory", dbx.HashExp{
 		"tenant":     c.ID,
 		"seller_sku": sellerSKU,
@@ -86,7 +147,7 @@ func (c *BaseDatabaseTenant) LoadItem(sellerSKU string) (*Item, error) {
 
 }
 
-func (c *BaseDatabaseTenant) SaveItem(item *Item) error {
+func (c *BaseDatabaseTenant) SaveItem(ctx context.Context, item *Item) error {
 	collection, err := c.Dao.FindCollectionByNameOrId("tenant_inventory")
 	if err != nil {
 		return err
@@ -0,0 +1,164 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/nmcapule/oclz-go/oauth2"
+	"github.com/nmcapule/oclz-go/utils/jsonschema"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+// CredentialsPolicy defines whether a vendor needs oauth2 credentials.
+type CredentialsPolicy int
+
+const (
+	// CredentialsNone means the vendor does not use oauth2 credentials.
+	CredentialsNone CredentialsPolicy = iota
+	// CredentialsRequired means loading fails without oauth2 credentials.
+	CredentialsRequired
+	// CredentialsOptional means the vendor can be loaded without oauth2
+	// credentials, e.g. so that it can be authorized for the first time.
+	CredentialsOptional
+)
+
+// VendorDeps are the shared dependencies passed to vendor factories.
+type VendorDeps struct {
+	Tenant      *BaseTenant
+	Dao         *daos.Dao
+	Filesystem  func() (*filesystem.System, error)
+	Credentials *oauth2.Credentials
+}
+
+// Validator is implemented by vendor configs that can check themselves after
+// being decoded from the tenant config, for checks that the config schema
+// can't express, e.g. fields that depend on each other.
+type Validator interface {
+	Validate() error
+}
+
+// Vendor is a registered vendor.
+type Vendor struct {
+	Name        string
+	Credentials CredentialsPolicy
+	// NewConfig returns a pointer to a new zero value of the vendor config.
+	NewConfig func() any
+
+	schema *jsonschema.Schema
+	load   func(deps *VendorDeps, config any) (IntegrationClient, error)
+}
+
+// Schema returns the JSON schema of the vendor config.
+func (v *Vendor) Schema() *jsonschema.Schema {
+	return v.schema
+}
+
+// ValidateConfig validates the raw tenant config against the vendor config
+// schema, and then against the config's own Validate method if any.
+func (v *Vendor) ValidateConfig(raw json.RawMessage) error {
+	_, err := v.decodeConfig(raw)
+	return err
+}
+
+func (v *Vendor) decodeConfig(raw json.RawMessage) (any, error) {
+	config := v.NewConfig()
+	if len(raw) == 0 {
+		return config, nil
+	}
+	if err := v.schema.Validate(raw); err != nil {
+		return nil, err
+	}
+	var options TenantOptions
+	if err := json.Unmarshal(raw, &options); err != nil {
+		return nil, err
+	}
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, config); err != nil {
+		return nil, err
+	}
+	if validator, ok := config.(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+// Load decodes and validates the raw tenant config, then creates the client.
+func (v *Vendor) Load(deps *VendorDeps, raw json.RawMessage) (IntegrationClient, error) {
+	config, err := v.decodeConfig(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s config: %v", v.Name, err)
+	}
+	return v.load(deps, config)
+}
+
+// MapSecrets replaces the secrets of the raw tenant config by the result of
+// fn, e.g. to encrypt or decrypt them. Secrets are the config fields tagged
+// with `jsonschema:"secret"`.
+func (v *Vendor) MapSecrets(raw json.RawMessage, fn func(string) (string, error)) (json.RawMessage, error) {
+	return v.schema.MapWriteOnly(raw, fn)
+}
+
+var optionsSchema = jsonschema.Reflect(&TenantOptions{})
+
+var (
+	vendorsMu sync.RWMutex
+	vendors   = make(map[string]*Vendor)
+)
+
+// RegisterVendor registers a vendor client factory under the given name,
+// which is matched against the `vendor` field of tenants. This is usually
+// called from the init function of the vendor package. Panics if the name is
+// already registered.
+func RegisterVendor[C any](name string, credentials CredentialsPolicy, factory func(deps *VendorDeps, config *C) (IntegrationClient, error)) {
+	vendorsMu.Lock()
+	defer vendorsMu.Unlock()
+
+	if _, ok := vendors[name]; ok {
+		panic(fmt.Sprintf("vendor %q is already registered", name))
+	}
+	// Allow the common tenant options in every vendor config.
+	schema := jsonschema.Reflect(new(C))
+	for key, prop := range optionsSchema.Properties {
+		schema.Properties[key] = prop
+	}
+	vendors[name] = &Vendor{
+		Name:        name,
+		Credentials: credentials,
+		NewConfig: func() any {
+			return new(C)
+		},
+		schema: schema,
+		load: func(deps *VendorDeps, config any) (IntegrationClient, error) {
+			return factory(deps, config.(*C))
+		},
+	}
+}
+
+// LookupVendor returns the vendor registered under the given name.
+func LookupVendor(name string) (*Vendor, bool) {
+	vendorsMu.RLock()
+	defer vendorsMu.RUnlock()
+
+	v, ok := vendors[name]
+	return v, ok
+}
+
+// VendorNames returns the sorted names of all registered vendors.
+func VendorNames() []string {
+	vendorsMu.RLock()
+	defer vendorsMu.RUnlock()
+
+	var names []string
+	for name := range vendors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -1,18 +1,37 @@
 package opencart
 
 import (
-	"time"
+	"context"
 
 	"github.com/nmcapule/oclz-go/integrations/models"
 	"github.com/nmcapule/oclz-go/utils/scheduler"
 )
 
+// DefaultSchedule is the schedule of the daemon if not configured.
+const DefaultSchedule = "@every 1m"
+
 func (c *Client) Daemon() models.Daemon {
 	return c
 }
 
-func (c *Client) Start() error {
-	scheduler.Loop(func(quit chan struct{}) {
+// schedule returns the configured schedule of the daemon.
+func (c *Config) schedule() (scheduler.Schedule, error) {
+	expr := c.Schedule
+	if expr == "" {
+		expr = DefaultSchedule
+	}
+	return scheduler.ParseSchedule(expr)
+}
+
+func (c *Client) Start(ctx context.Context) error {
+	schedule, err := c.Config.schedule()
+	if err != nil {
+		return err
+	}
+	plan := &scheduler.Plan{Schedule: schedule}
+	return scheduler.Run(ctx, func() *scheduler.Plan {
+		return plan
+	}, func(ctx context.Context) {
 		// log.WithFields(log.Fields{
 		// 	"tenant": c.Name,
 		// }).Infoln("Collecting recent sale orders...")
@@ -20,6 +39,5 @@ func (c *Client) Start() error {
 		// log.Fatalln(c.loadSaleOrderPages(url.Values{
 		// 	"filter_date_modified": []string{"2022-09-13"},
 		// }))
-	}, scheduler.LoopConfig{RetryWait: 5 * time.Second})
-	return nil
+	}, scheduler.RunConfig{})
 }
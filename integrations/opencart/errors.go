@@ -0,0 +1,14 @@
+package opencart
+
+import "errors"
+
+var (
+	// ErrNotLoggedIn is returned when the scraped page is the admin login page
+	// instead of the requested page, e.g. due to wrong credentials.
+	ErrNotLoggedIn = errors.New("opencart: not logged in")
+	// ErrLayoutChanged is returned when the scraped page does not match the
+	// configured selectors, e.g. after a theme change or an OpenCart upgrade.
+	ErrLayoutChanged = errors.New("opencart: unexpected page layout")
+	// ErrNoResults is returned when the scraped page has an empty result set.
+	ErrNoResults = errors.New("opencart: no results")
+)
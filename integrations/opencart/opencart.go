@@ -2,6 +2,8 @@
 package opencart
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -18,9 +20,31 @@ const Vendor = "OPENCART"
 
 // Config is a opencart config.
 type Config struct {
-	Domain   string `json:"domain"`
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Domain   string `json:"domain" jsonschema:"required"`
+	Username string `json:"username" jsonschema:"required"`
+	Password string `json:"password" jsonschema:"required,secret"`
+	// Version selects the built-in scraper selectors. Defaults to
+	// DefaultVersion, which is the only built-in version, and other versions
+	// fail validation.
+	Version string `json:"version"`
+	// Selectors overrides individual scraper selectors, e.g. for a custom
+	// admin theme or another OpenCart version.
+	Selectors *Selectors `json:"selectors"`
+	// Schedule is the cron expression of the background daemon. Defaults to
+	// DefaultSchedule.
+	Schedule string `json:"schedule"`
+}
+
+// Validate checks the selectors and the schedule. Required fields are
+// checked by the config schema.
+func (c *Config) Validate() error {
+	if _, err := c.selectors(); err != nil {
+		return err
+	}
+	if _, err := c.schedule(); err != nil {
+		return err
+	}
+	return nil
 }
 
 // Client is a opencart client.
@@ -30,18 +54,31 @@ type Client struct {
 	Config         *Config
 }
 
+func init() {
+	models.RegisterVendor(Vendor, models.CredentialsNone, func(deps *models.VendorDeps, config *Config) (models.IntegrationClient, error) {
+		return &Client{
+			BaseTenant: deps.Tenant,
+			DatabaseTenant: &models.BaseDatabaseTenant{
+				BaseTenant: deps.Tenant,
+				Dao:        deps.Dao,
+			},
+			Config: config,
+		}, nil
+	})
+}
+
 // CollectAllItems collects and returns all items registered in this client.
-func (c *Client) CollectAllItems() ([]*models.Item, error) {
-	return c.loadCatalogProductPages(nil)
+func (c *Client) CollectAllItems(ctx context.Context) ([]*models.Item, error) {
+	return c.loadCatalogProductPages(ctx, nil)
 }
 
 // LoadItem returns item info for a single SKU.
-func (c *Client) LoadItem(sku string) (*models.Item, error) {
-	items, err := c.loadCatalogProductPages(url.Values{
+func (c *Client) LoadItem(ctx context.Context, sku string) (*models.Item, error) {
+	items, err := c.loadCatalogProductPages(ctx, url.Values{
 		"filter_model": []string{sku},
 	})
 	if err != nil {
-		return nil, fmt.Errorf("retrieving %q: %v", sku, err)
+		return nil, fmt.Errorf("retrieving %q: %w", sku, err)
 	}
 
 	// Discard all items that are not exact match of SKU.
@@ -55,7 +92,7 @@ func (c *Client) LoadItem(sku string) (*models.Item, error) {
 	items = filtered
 
 	if len(items) == 0 {
-		return nil, fmt.Errorf("%q not found", sku)
+		return nil, models.ErrNotFound
 	}
 	if len(items) > 1 {
 		return nil, fmt.Errorf("multiple results for sku %q", sku)
@@ -65,15 +102,15 @@ func (c *Client) LoadItem(sku string) (*models.Item, error) {
 
 // SaveItem saves item info for a single SKU.
 // This only implements updating the product stock.
-func (c *Client) SaveItem(item *models.Item) error {
-	cached, err := c.DatabaseTenant.LoadItem(item.SellerSKU)
+func (c *Client) SaveItem(ctx context.Context, item *models.Item) error {
+	cached, err := c.DatabaseTenant.LoadItem(ctx, item.SellerSKU)
 	if err != nil {
 		return fmt.Errorf("retrieving db tenant item: %v", err)
 	}
 	productID := cached.TenantProps.Get("product_id").Int()
 
 	// TODO(ncapule): This relies on plugin: Quick Editor!
-	_, err = c.request(&http.Request{
+	_, err = c.request(ctx, &http.Request{
 		Method: http.MethodGet,
 		URL: c.url("/tool/stocksetting4/savequantity", url.Values{
 			"id":    []string{strconv.Itoa(int(productID))},
@@ -86,21 +123,29 @@ func (c *Client) SaveItem(item *models.Item) error {
 	return nil
 }
 
-func (c *Client) loadCatalogProductPages(query url.Values) ([]*models.Item, error) {
+func (c *Client) loadCatalogProductPages(ctx context.Context, query url.Values) ([]*models.Item, error) {
 	if query == nil {
 		query = make(url.Values)
 	}
 
+	sel, err := c.Config.selectors()
+	if err != nil {
+		return nil, err
+	}
+
 	page := 1
 	var items []*models.Item
 	for {
 		query.Set("page", strconv.Itoa(page))
-		base, err := c.request(&http.Request{
+		base, err := c.request(ctx, &http.Request{
 			Method: http.MethodGet,
 			URL:    c.url("/catalog/product", query),
-		}, responseParser(scrapeCatalogProduct))
+		}, responseParser(scrapeCatalogProduct(sel)))
+		if errors.Is(err, ErrNoResults) {
+			break
+		}
 		if err != nil {
-			return nil, fmt.Errorf("request to /catalog/product: %v", err)
+			return nil, fmt.Errorf("request to /catalog/product: %w", err)
 		}
 		for _, row := range base.Get("data.rows").Array() {
 			items = append(items, &models.Item{
@@ -114,7 +159,7 @@ func (c *Client) loadCatalogProductPages(query url.Values) ([]*models.Item, erro
 				}),
 			})
 		}
-		log.WithFields(log.Fields{
+		log.WithContext(ctx).WithFields(log.Fields{
 			"tenant": c.Name,
 			"items":  len(items),
 			"offset": base.Get("data.offset").Int(),
@@ -129,21 +174,29 @@ func (c *Client) loadCatalogProductPages(query url.Values) ([]*models.Item, erro
 	return items, nil
 }
 
-func (c *Client) loadSaleOrderPages(query url.Values) (*gjson.Result, error) {
+func (c *Client) loadSaleOrderPages(ctx context.Context, query url.Values) (*gjson.Result, error) {
 	if query == nil {
 		query = make(url.Values)
 	}
 
+	sel, err := c.Config.selectors()
+	if err != nil {
+		return nil, err
+	}
+
 	page := 1
 	var orders []map[string]any
 	for {
 		query.Set("page", strconv.Itoa(page))
-		base, err := c.request(&http.Request{
+		base, err := c.request(ctx, &http.Request{
 			Method: http.MethodGet,
 			URL:    c.url("/sale/order", query),
-		}, responseParser(scrapeSaleOrder))
+		}, responseParser(scrapeSaleOrder(sel)))
+		if errors.Is(err, ErrNoResults) {
+			break
+		}
 		if err != nil {
-			return nil, fmt.Errorf("request to /sale/order: %v", err)
+			return nil, fmt.Errorf("request to /sale/order: %w", err)
 		}
 		for _, row := range base.Get("data.rows").Array() {
 			orders = append(orders, map[string]any{
@@ -151,14 +204,14 @@ func (c *Client) loadSaleOrderPages(query url.Values) (*gjson.Result, error) {
 				"stocks":     row.Get("quantity").Float(),
 			})
 		}
-		log.WithFields(log.Fields{
+		log.WithContext(ctx).WithFields(log.Fields{
 			"tenant": c.Name,
 			"items":  len(orders),
 			"offset": base.Get("data.offset").Int(),
 			"total":  base.Get("data.total").Int(),
 		}).Debugln("Loading sale orders")
 
-		if page == int(base.Get("data.pages").Int()) {
+		if page >= int(base.Get("data.pages").Int()) {
 			break
 		}
 		page += 1
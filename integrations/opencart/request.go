@@ -1,6 +1,7 @@
 package opencart
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -42,7 +43,7 @@ func (c *Client) url(endpoint string, query url.Values) *url.URL {
 	return u
 }
 
-func (c *Client) request(req *http.Request, opts ...requestOption) (*gjson.Result, error) {
+func (c *Client) request(ctx context.Context, req *http.Request, opts ...requestOption) (*gjson.Result, error) {
 	var config requestConfig
 	for _, opt := range opts {
 		opt(&config)
@@ -72,7 +73,7 @@ func (c *Client) request(req *http.Request, opts ...requestOption) (*gjson.Resul
 		return nil, fmt.Errorf("creating cookie jar: %v", err)
 	}
 	client := &http.Client{Jar: jar}
-	res, err := client.Do(login)
+	res, err := client.Do(login.WithContext(ctx))
 	if err != nil {
 		return nil, fmt.Errorf("http request: %v", err)
 	}
@@ -9,85 +9,131 @@ import (
 	"github.com/PuerkitoBio/goquery"
 	"github.com/nmcapule/oclz-go/utils"
 	"github.com/tidwall/gjson"
-
-	log "github.com/sirupsen/logrus"
 )
 
 var pagesRe = regexp.MustCompile(`(?P<offset>\d+) to (?P<offset_limit>\d+) of (?P<total>\d+) \((?P<pages>\d+) Pages\)`)
 
-var MessageNoResults = "No results!"
+type pagination struct {
+	offset      int
+	offsetLimit int
+	total       int
+	pages       int
+}
 
-func scrapeCatalogProduct(input string) (*gjson.Result, error) {
-	doc, err := goquery.NewDocumentFromReader(strings.NewReader(input))
-	if err != nil {
-		return nil, fmt.Errorf("parsing doc: %v", err)
-	}
-	var rows []map[string]interface{}
-	doc.Find("#form-product > div > table > tbody > tr").Each(func(_ int, s *goquery.Selection) {
-		// Check if no results.
-		if strings.TrimSpace(s.Find("td:nth-child(1)").Text()) == MessageNoResults {
-			log.Errorln("No results found for current query!")
-			return
+func scrapeCatalogProduct(sel *Selectors) func(input string) (*gjson.Result, error) {
+	return func(input string) (*gjson.Result, error) {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(input))
+		if err != nil {
+			return nil, fmt.Errorf("parsing doc: %v", err)
 		}
-		rows = append(rows, map[string]interface{}{
-			"model":        strings.TrimSpace(s.Find("td:nth-child(4)").Text()),
-			"quantity":     strings.TrimSpace(s.Find("td:nth-child(6) > span").Text()),
-			"product_name": strings.TrimSpace(s.Find("td:nth-child(3)").Text()),
-			"price":        strings.TrimSpace(s.Find("td:nth-child(5)").Text()),
-			"status":       strings.TrimSpace(s.Find("td:nth-child(7)").Text()),
-			"product_id":   strings.TrimSpace(s.Find("td:nth-child(1) > input").AttrOr("value", "")),
+		if err := checkPage(doc, sel, sel.ProductTable); err != nil {
+			return nil, err
+		}
+		var rows []map[string]interface{}
+		doc.Find(sel.ProductRows).Each(func(_ int, s *goquery.Selection) {
+			// Check if no results.
+			if strings.TrimSpace(s.Text()) == sel.NoResults {
+				return
+			}
+			rows = append(rows, map[string]interface{}{
+				"model":        strings.TrimSpace(s.Find(sel.ProductModel).Text()),
+				"quantity":     strings.TrimSpace(s.Find(sel.ProductQuantity).Text()),
+				"product_name": strings.TrimSpace(s.Find(sel.ProductName).Text()),
+				"price":        strings.TrimSpace(s.Find(sel.ProductPrice).Text()),
+				"status":       strings.TrimSpace(s.Find(sel.ProductStatus).Text()),
+				"product_id":   strings.TrimSpace(s.Find(sel.ProductID).AttrOr("value", "")),
+			})
 		})
-	})
-	tokens := pagesRe.FindStringSubmatch(doc.Find("#form-product + div > div + div").Text())
-	offset, _ := strconv.Atoi(tokens[pagesRe.SubexpIndex("offset")])
-	offsetLimit, _ := strconv.Atoi(tokens[pagesRe.SubexpIndex("offset_limit")])
-	total, _ := strconv.Atoi(tokens[pagesRe.SubexpIndex("total")])
-	pages, _ := strconv.Atoi(tokens[pagesRe.SubexpIndex("pages")])
-	// TODO(nmcapule): Handle other errors.
-	return utils.GJSONFrom(map[string]interface{}{
-		"code":    0,
-		"message": "Success",
-		"data": map[string]interface{}{
-			"rows":   rows,
-			"offset": offset - 1,
-			"limit":  offsetLimit - offset + 1,
-			"total":  total,
-			"pages":  pages,
-		},
-	}), nil
+		p, err := scrapePagination(doc, sel.ProductPagination)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkRows(len(rows), p); err != nil {
+			return nil, err
+		}
+		return p.result(rows), nil
+	}
 }
 
-func scrapeSaleOrder(input string) (*gjson.Result, error) {
-	doc, err := goquery.NewDocumentFromReader(strings.NewReader(input))
-	if err != nil {
-		return nil, fmt.Errorf("parsing doc: %v", err)
-	}
-	var rows []map[string]interface{}
-	doc.Find(`div[id^="collapse_products_"] > div > table > tbody > tr`).Each(func(_ int, s *goquery.Selection) {
-		model := strings.TrimSpace(s.Find("td:nth-child(3)").Text())
-		if model == "" {
-			return
+func scrapeSaleOrder(sel *Selectors) func(input string) (*gjson.Result, error) {
+	return func(input string) (*gjson.Result, error) {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(input))
+		if err != nil {
+			return nil, fmt.Errorf("parsing doc: %v", err)
 		}
-		rows = append(rows, map[string]interface{}{
-			"model":    model,
-			"quantity": strings.TrimSpace(s.Find("td:nth-child(4)").Text()),
+		if err := checkPage(doc, sel, sel.OrderTable); err != nil {
+			return nil, err
+		}
+		var rows []map[string]interface{}
+		doc.Find(sel.OrderRows).Each(func(_ int, s *goquery.Selection) {
+			model := strings.TrimSpace(s.Find(sel.OrderModel).Text())
+			if model == "" {
+				return
+			}
+			rows = append(rows, map[string]interface{}{
+				"model":    model,
+				"quantity": strings.TrimSpace(s.Find(sel.OrderQuantity).Text()),
+			})
 		})
-	})
-	tokens := pagesRe.FindStringSubmatch(doc.Find("#form-order + div > div + div").Text())
-	offset, _ := strconv.Atoi(tokens[pagesRe.SubexpIndex("offset")])
-	offsetLimit, _ := strconv.Atoi(tokens[pagesRe.SubexpIndex("offset_limit")])
-	total, _ := strconv.Atoi(tokens[pagesRe.SubexpIndex("total")])
-	pages, _ := strconv.Atoi(tokens[pagesRe.SubexpIndex("pages")])
-	// TODO(nmcapule): Handle other errors.
+		p, err := scrapePagination(doc, sel.OrderPagination)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkRows(len(rows), p); err != nil {
+			return nil, err
+		}
+		return p.result(rows), nil
+	}
+}
+
+// checkPage verifies that the document is the expected admin page and not,
+// for example, the login page that OpenCart redirects to on a failed login.
+func checkPage(doc *goquery.Document, sel *Selectors, table string) error {
+	if doc.Find(sel.LoginForm).Length() > 0 {
+		return ErrNotLoggedIn
+	}
+	if doc.Find(table).Length() == 0 {
+		return fmt.Errorf("%w: no match for %q", ErrLayoutChanged, table)
+	}
+	return nil
+}
+
+// checkRows verifies that the scraped rows agree with the pagination info.
+func checkRows(count int, p *pagination) error {
+	if count == 0 && p.total == 0 {
+		return ErrNoResults
+	}
+	if count == 0 {
+		return fmt.Errorf("%w: no rows scraped out of %d results", ErrLayoutChanged, p.total)
+	}
+	return nil
+}
+
+func scrapePagination(doc *goquery.Document, selector string) (*pagination, error) {
+	text := strings.TrimSpace(doc.Find(selector).Text())
+	tokens := pagesRe.FindStringSubmatch(text)
+	if tokens == nil {
+		return nil, fmt.Errorf("%w: unrecognized pagination %q", ErrLayoutChanged, text)
+	}
+	var p pagination
+	// Regexp only matches digits, so conversion errors can be ignored.
+	p.offset, _ = strconv.Atoi(tokens[pagesRe.SubexpIndex("offset")])
+	p.offsetLimit, _ = strconv.Atoi(tokens[pagesRe.SubexpIndex("offset_limit")])
+	p.total, _ = strconv.Atoi(tokens[pagesRe.SubexpIndex("total")])
+	p.pages, _ = strconv.Atoi(tokens[pagesRe.SubexpIndex("pages")])
+	return &p, nil
+}
+
+func (p *pagination) result(rows []map[string]interface{}) *gjson.Result {
 	return utils.GJSONFrom(map[string]interface{}{
 		"code":    0,
 		"message": "Success",
 		"data": map[string]interface{}{
 			"rows":   rows,
-			"offset": offset - 1,
-			"limit":  offsetLimit - offset + 1,
-			"total":  total,
-			"pages":  pages,
+			"offset": p.offset - 1,
+			"limit":  p.offsetLimit - p.offset + 1,
+			"total":  p.total,
+			"pages":  p.pages,
 		},
-	}), nil
+	})
 }
@@ -0,0 +1,151 @@
+package opencart
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestScrapers(t *testing.T) {
+	sel, err := (&Config{}).selectors()
+	if err != nil {
+		t.Fatalf("default selectors: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		input   string
+		scraper func(input string) (*gjson.Result, error)
+		wantErr error
+	}{
+		{
+			name:    "catalog product",
+			input:   "catalog_product.html",
+			scraper: scrapeCatalogProduct(sel),
+		},
+		{
+			name:    "catalog product empty",
+			input:   "catalog_product_empty.html",
+			scraper: scrapeCatalogProduct(sel),
+			wantErr: ErrNoResults,
+		},
+		{
+			name:    "catalog product layout changed",
+			input:   "catalog_product_layout_changed.html",
+			scraper: scrapeCatalogProduct(sel),
+			wantErr: ErrLayoutChanged,
+		},
+		{
+			name:    "catalog product not logged in",
+			input:   "login.html",
+			scraper: scrapeCatalogProduct(sel),
+			wantErr: ErrNotLoggedIn,
+		},
+		{
+			name:    "sale order",
+			input:   "sale_order.html",
+			scraper: scrapeSaleOrder(sel),
+		},
+		{
+			name:    "sale order not logged in",
+			input:   "login.html",
+			scraper: scrapeSaleOrder(sel),
+			wantErr: ErrNotLoggedIn,
+		},
+		{
+			name:    "sale order layout changed",
+			input:   "catalog_product.html",
+			scraper: scrapeSaleOrder(sel),
+			wantErr: ErrLayoutChanged,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input, err := os.ReadFile(filepath.Join("testdata", tt.input))
+			if err != nil {
+				t.Fatalf("reading input: %v", err)
+			}
+			got, err := tt.scraper(string(input))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got error %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var buf bytes.Buffer
+			if err := json.Indent(&buf, []byte(got.Raw), "", "  "); err != nil {
+				t.Fatalf("formatting output: %v", err)
+			}
+			golden := filepath.Join("testdata", tt.input+".golden.json")
+			if *update {
+				if err := os.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+					t.Fatalf("updating golden file: %v", err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("reading golden file: %v", err)
+			}
+			if !bytes.Equal(buf.Bytes(), want) {
+				t.Errorf("output mismatch for %s\ngot:\n%s\nwant:\n%s", tt.input, buf.String(), want)
+			}
+		})
+	}
+}
+
+func TestSelectorsOverride(t *testing.T) {
+	config := &Config{
+		Selectors: &Selectors{ProductQuantity: "td:nth-child(6)"},
+	}
+	sel, err := config.selectors()
+	if err != nil {
+		t.Fatalf("selectors: %v", err)
+	}
+	if sel.ProductQuantity != "td:nth-child(6)" {
+		t.Errorf("got ProductQuantity %q, want override", sel.ProductQuantity)
+	}
+	if want := selectorPresets[DefaultVersion].ProductModel; sel.ProductModel != want {
+		t.Errorf("got ProductModel %q, want preset %q", sel.ProductModel, want)
+	}
+
+	if _, err := (&Config{Version: "0"}).selectors(); err == nil {
+		t.Errorf("expected error for unsupported version")
+	}
+}
+
+func TestConfigSelectors(t *testing.T) {
+	if _, err := (&Config{Version: "3"}).selectors(); err != nil {
+		t.Errorf("version 3: %v", err)
+	}
+	if err := (&Config{Version: "4"}).Validate(); err == nil {
+		t.Errorf("Validate() with version 4 succeeded, want an error for the unsupported version")
+	}
+
+	// Overrides only replace the selectors that are set.
+	sel, err := (&Config{Selectors: &Selectors{NoResults: "Walang resulta!"}}).selectors()
+	if err != nil {
+		t.Fatalf("selectors(): %v", err)
+	}
+	if sel.NoResults != "Walang resulta!" {
+		t.Errorf("NoResults = %q, want the override", sel.NoResults)
+	}
+	if want := selectorPresets[DefaultVersion].ProductRows; sel.ProductRows != want {
+		t.Errorf("ProductRows = %q, want the preset %q", sel.ProductRows, want)
+	}
+	if MessageNoResults != "No results!" {
+		t.Errorf("MessageNoResults = %q, want the preset", MessageNoResults)
+	}
+}
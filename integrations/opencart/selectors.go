@@ -0,0 +1,94 @@
+package opencart
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// DefaultVersion is the OpenCart version whose selectors are used when the
+// tenant config does not specify one. Only the stock admin theme of OpenCart
+// 3 is built in. Other versions and themes override the selectors that
+// differ in the tenant config.
+const DefaultVersion = "3"
+
+// MessageNoResults is the text of the only row of an empty table in the stock
+// admin theme.
+//
+// Deprecated: Use Selectors.NoResults, which can be overridden per tenant.
+var MessageNoResults = selectorPresets[DefaultVersion].NoResults
+
+// Selectors are the CSS selectors used to scrape the OpenCart admin pages.
+// Row-level selectors (e.g. ProductModel) are relative to a single row.
+type Selectors struct {
+	LoginForm string `json:"login_form,omitempty"`
+	// NoResults is the text of the only row of an empty table, e.g. in a
+	// translated admin, rather than a selector.
+	NoResults string `json:"no_results,omitempty"`
+
+	ProductTable      string `json:"product_table,omitempty"`
+	ProductRows       string `json:"product_rows,omitempty"`
+	ProductID         string `json:"product_id,omitempty"`
+	ProductName       string `json:"product_name,omitempty"`
+	ProductModel      string `json:"product_model,omitempty"`
+	ProductPrice      string `json:"product_price,omitempty"`
+	ProductQuantity   string `json:"product_quantity,omitempty"`
+	ProductStatus     string `json:"product_status,omitempty"`
+	ProductPagination string `json:"product_pagination,omitempty"`
+
+	OrderTable      string `json:"order_table,omitempty"`
+	OrderRows       string `json:"order_rows,omitempty"`
+	OrderModel      string `json:"order_model,omitempty"`
+	OrderQuantity   string `json:"order_quantity,omitempty"`
+	OrderPagination string `json:"order_pagination,omitempty"`
+}
+
+// selectorPresets are the built-in selectors for each supported OpenCart
+// version, using the stock admin theme. Only DefaultVersion is supported.
+var selectorPresets = map[string]Selectors{
+	"3": {
+		LoginForm: `form input[name="password"]`,
+		NoResults: "No results!",
+
+		ProductTable:      "#form-product > div > table",
+		ProductRows:       "#form-product > div > table > tbody > tr",
+		ProductID:         "td:nth-child(1) > input",
+		ProductName:       "td:nth-child(3)",
+		ProductModel:      "td:nth-child(4)",
+		ProductPrice:      "td:nth-child(5)",
+		ProductQuantity:   "td:nth-child(6) > span",
+		ProductStatus:     "td:nth-child(7)",
+		ProductPagination: "#form-product + div > div + div",
+
+		OrderTable:      "#form-order",
+		OrderRows:       `div[id^="collapse_products_"] > div > table > tbody > tr`,
+		OrderModel:      "td:nth-child(3)",
+		OrderQuantity:   "td:nth-child(4)",
+		OrderPagination: "#form-order + div > div + div",
+	},
+}
+
+// selectors returns the selectors preset for the configured OpenCart version,
+// with any selectors from the tenant config (e.g. for a custom admin theme)
+// taking precedence.
+func (c *Config) selectors() (*Selectors, error) {
+	version := c.Version
+	if version == "" {
+		version = DefaultVersion
+	}
+	preset, ok := selectorPresets[version]
+	if !ok {
+		return nil, fmt.Errorf("unsupported opencart version %q", version)
+	}
+	if c.Selectors == nil {
+		return &preset, nil
+	}
+	// Overlay the non-empty selectors from config on top of the preset.
+	b, err := json.Marshal(c.Selectors)
+	if err != nil {
+		return nil, fmt.Errorf("serializing selectors: %v", err)
+	}
+	if err := json.Unmarshal(b, &preset); err != nil {
+		return nil, fmt.Errorf("merging selectors: %v", err)
+	}
+	return &preset, nil
+}
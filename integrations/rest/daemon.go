@@ -0,0 +1,7 @@
+package rest
+
+import "github.com/nmcapule/oclz-go/integrations/models"
+
+func (c *Client) Daemon() models.Daemon {
+	return nil
+}
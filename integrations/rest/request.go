@@ -0,0 +1,45 @@
+package rest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/nmcapule/oclz-go/integrations/httpx"
+	"github.com/tidwall/gjson"
+)
+
+func (c *Client) url(endpoint []byte, query url.Values) (*url.URL, error) {
+	baseURL := fmt.Sprintf("%s%s", c.Config.Domain, endpoint)
+	if strings.HasPrefix(string(endpoint), "http://") || strings.HasPrefix(string(endpoint), "https://") {
+		baseURL = string(endpoint)
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse url %q: %v", baseURL, err)
+	}
+	// Merge with query params that may already be in the templated path.
+	q := u.Query()
+	for key, values := range query {
+		for _, v := range values {
+			q.Add(key, v)
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u, nil
+}
+
+func (c *Client) request(ctx context.Context, req *http.Request) (*gjson.Result, error) {
+	transport := &httpx.Client{
+		Sign: func(req *http.Request) error {
+			if c.Config.Auth.Header != "" {
+				req.Header.Set(c.Config.Auth.Header, c.Config.Auth.Value)
+			}
+			req.Header.Set("Accept", "application/json")
+			return nil
+		},
+	}
+	return transport.Do(ctx, req)
+}
@@ -0,0 +1,371 @@
+// Package rest implements a declarative tenant client for simple REST APIs,
+// where the endpoints and response paths are described by the tenant config.
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"text/template"
+
+	"github.com/nmcapule/oclz-go/integrations/models"
+	"github.com/nmcapule/oclz-go/utils"
+	"github.com/tidwall/gjson"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const Vendor = "HTTP"
+
+const (
+	PaginationNone   = ""
+	PaginationPage   = "page"
+	PaginationOffset = "offset"
+	PaginationCursor = "cursor"
+)
+
+// Config is a REST config.
+type Config struct {
+	Domain     string     `json:"domain" jsonschema:"required"`
+	Auth       Auth       `json:"auth"`
+	List       Endpoint   `json:"list" jsonschema:"required"`
+	Get        Endpoint   `json:"get"`
+	Update     Endpoint   `json:"update" jsonschema:"required"`
+	Pagination Pagination `json:"pagination"`
+	Paths      Paths      `json:"paths" jsonschema:"required"`
+}
+
+// Auth is a header attached to every request, e.g. "Authorization".
+type Auth struct {
+	Header string `json:"header"`
+	Value  string `json:"value" jsonschema:"secret"`
+}
+
+// Endpoint describes a single API call. Path and Body are Go templates
+// executed with the fields of templateData, e.g. `/products/{{pathescape .SKU}}`.
+// Values in path segments must be escaped with the pathescape func, and values
+// in query params with urlquery, e.g. `/products?sku={{urlquery .SKU}}`.
+// Values in JSON bodies must be escaped with the json func, e.g.
+// `{"sku": {{json .SKU}}, "stock": {{json .Stocks}}}`.
+type Endpoint struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Body   string `json:"body"`
+	// ContentType of Body. Defaults to "application/json".
+	ContentType string `json:"content_type"`
+	// Items is the gjson path to the item or items in the response. Defaults
+	// to the whole response.
+	Items string `json:"items"`
+}
+
+// Pagination describes how the list endpoint is paginated.
+type Pagination struct {
+	// Style is one of "", "page", "offset" or "cursor".
+	Style string `json:"style"`
+	// Param is the query param for the page number, offset or cursor.
+	Param string `json:"param"`
+	// SizeParam is the query param for the page size.
+	SizeParam string `json:"size_param"`
+	Size      int    `json:"size"`
+	// Start is the first page number. Defaults to 1 for "page" style.
+	Start int `json:"start"`
+	// NextCursor is the gjson path to the next cursor in the response.
+	NextCursor string `json:"next_cursor"`
+	// Total is the gjson path to the total item count in the response.
+	Total string `json:"total"`
+	// MaxPages stops listing after as many pages, in case the API ignores the
+	// pagination params. Defaults to DefaultMaxPages.
+	MaxPages int `json:"max_pages"`
+}
+
+// DefaultMaxPages is the default limit of pages listed.
+const DefaultMaxPages = 1000
+
+// Paths are gjson paths relative to a single item in the response.
+type Paths struct {
+	SKU   string `json:"sku" jsonschema:"required"`
+	Stock string `json:"stock" jsonschema:"required"`
+	// Props are saved as tenant props, and are available in templates, e.g.
+	// an item ID needed by the update endpoint.
+	Props map[string]string `json:"props"`
+}
+
+// Validate checks the endpoints and the pagination. Required fields are
+// checked by the config schema.
+func (c *Config) Validate() error {
+	if c.List.Path == "" || c.Update.Path == "" {
+		return errors.New("list and update endpoints are required")
+	}
+	switch c.Pagination.Style {
+	case PaginationNone:
+	case PaginationPage, PaginationOffset:
+		if c.Pagination.Param == "" {
+			return errors.New("pagination.param is required")
+		}
+	case PaginationCursor:
+		if c.Pagination.Param == "" || c.Pagination.NextCursor == "" {
+			return errors.New("pagination.param and pagination.next_cursor are required")
+		}
+	default:
+		return fmt.Errorf("unsupported pagination style %q", c.Pagination.Style)
+	}
+	return nil
+}
+
+// Client is a REST client.
+type Client struct {
+	*models.BaseTenant
+	Config *Config
+}
+
+func init() {
+	models.RegisterVendor(Vendor, models.CredentialsNone, func(deps *models.VendorDeps, config *Config) (models.IntegrationClient, error) {
+		return &Client{
+			BaseTenant: deps.Tenant,
+			Config:     config,
+		}, nil
+	})
+}
+
+type templateData struct {
+	SKU    string
+	Stocks int
+	Props  map[string]any
+}
+
+// CollectAllItems collects and returns all items registered in this client.
+func (c *Client) CollectAllItems(ctx context.Context) ([]*models.Item, error) {
+	p := c.Config.Pagination
+	page := p.Start
+	if p.Style == PaginationPage && page == 0 {
+		page = 1
+	}
+	var cursor string
+	// Cursors seen so far, since a repeated cursor would loop forever.
+	seen := make(map[string]bool)
+	maxPages := p.MaxPages
+	if maxPages <= 0 {
+		maxPages = DefaultMaxPages
+	}
+
+	var items []*models.Item
+	for pages := 1; ; pages++ {
+		query := make(url.Values)
+		switch p.Style {
+		case PaginationPage, PaginationOffset:
+			query.Set(p.Param, strconv.Itoa(page))
+		case PaginationCursor:
+			if cursor != "" {
+				query.Set(p.Param, cursor)
+			}
+		}
+		if p.SizeParam != "" && p.Size > 0 {
+			query.Set(p.SizeParam, strconv.Itoa(p.Size))
+		}
+
+		base, err := c.call(ctx, c.Config.List, templateData{}, query)
+		if err != nil {
+			return nil, fmt.Errorf("list items: %v", err)
+		}
+		parsed := c.parseItems(ctx, base, c.Config.List.Items)
+		items = append(items, parsed...)
+
+		log.WithContext(ctx).WithFields(log.Fields{
+			"tenant": c.Name,
+			"items":  len(items),
+			"total":  base.Get(p.Total).Int(),
+		}).Debugln("Loading fresh items")
+
+		switch p.Style {
+		case PaginationPage:
+			page += 1
+		case PaginationOffset:
+			page += len(parsed)
+		case PaginationCursor:
+			cursor = base.Get(p.NextCursor).String()
+		}
+		if !c.hasNextPage(base, len(parsed), len(items), cursor) {
+			break
+		}
+		if p.Style == PaginationCursor {
+			if seen[cursor] {
+				return nil, fmt.Errorf("list items: cursor %q repeated", cursor)
+			}
+			seen[cursor] = true
+		}
+		if pages >= maxPages {
+			return nil, fmt.Errorf("list items: more than %d pages, set pagination.max_pages if expected", maxPages)
+		}
+	}
+	return items, nil
+}
+
+func (c *Client) hasNextPage(base *gjson.Result, pageItems, totalItems int, cursor string) bool {
+	p := c.Config.Pagination
+	switch p.Style {
+	case PaginationPage, PaginationOffset:
+		if pageItems == 0 || (p.Size > 0 && pageItems < p.Size) {
+			return false
+		}
+		if p.Total != "" && int64(totalItems) >= base.Get(p.Total).Int() {
+			return false
+		}
+		return true
+	case PaginationCursor:
+		return cursor != "" && pageItems > 0
+	default:
+		return false
+	}
+}
+
+// LoadItem returns item info for a single SKU. If no get endpoint is
+// configured, this searches the list endpoint instead.
+func (c *Client) LoadItem(ctx context.Context, sku string) (*models.Item, error) {
+	var items []*models.Item
+	if c.Config.Get.Path == "" {
+		all, err := c.CollectAllItems(ctx)
+		if err != nil {
+			return nil, err
+		}
+		items = all
+	} else {
+		base, err := c.call(ctx, c.Config.Get, templateData{SKU: sku}, nil)
+		if err != nil {
+			return nil, fmt.Errorf("get item: %v", err)
+		}
+		items = c.parseItems(ctx, base, c.Config.Get.Items)
+	}
+
+	// Collect only items with matching SKU.
+	var filtered []*models.Item
+	for i := range items {
+		if items[i].SellerSKU == sku {
+			filtered = append(filtered, items[i])
+		}
+	}
+	items = filtered
+	if len(items) == 0 {
+		return nil, models.ErrNotFound
+	}
+	if len(items) > 1 {
+		return nil, models.ErrMultipleItems
+	}
+	return items[0], nil
+}
+
+// SaveItem saves item info for a single SKU.
+// This only implements updating the product stock.
+func (c *Client) SaveItem(ctx context.Context, item *models.Item) error {
+	props, _ := item.TenantProps.Value().(map[string]any)
+	_, err := c.call(ctx, c.Config.Update, templateData{
+		SKU:    item.SellerSKU,
+		Stocks: item.Stocks,
+		Props:  props,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("update item: %v", err)
+	}
+	return nil
+}
+
+func (c *Client) parseItems(ctx context.Context, base *gjson.Result, path string) []*models.Item {
+	data := *base
+	if path != "" {
+		data = base.Get(path)
+	}
+	var items []*models.Item
+	parse := func(raw gjson.Result) {
+		sku := raw.Get(c.Config.Paths.SKU).String()
+		if sku == "" {
+			log.WithContext(ctx).WithFields(log.Fields{
+				"tenant": c.Name,
+			}).Debugf("Skipping item, empty sku: %s", raw.Raw)
+			return
+		}
+		props := make(map[string]any)
+		for name, path := range c.Config.Paths.Props {
+			props[name] = raw.Get(path).Value()
+		}
+		items = append(items, &models.Item{
+			SellerSKU:   sku,
+			Stocks:      int(raw.Get(c.Config.Paths.Stock).Int()),
+			TenantProps: utils.GJSONFrom(props),
+		})
+	}
+	if data.IsArray() {
+		data.ForEach(func(_, raw gjson.Result) bool {
+			parse(raw)
+			return true
+		})
+	} else if data.Exists() {
+		parse(data)
+	}
+	return items
+}
+
+// call executes the endpoint templates with the given data and sends the
+// request.
+func (c *Client) call(ctx context.Context, endpoint Endpoint, data templateData, query url.Values) (*gjson.Result, error) {
+	path, err := execute(endpoint.Path, data)
+	if err != nil {
+		return nil, fmt.Errorf("path template: %v", err)
+	}
+	method := endpoint.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	u, err := c.url(path, query)
+	if err != nil {
+		return nil, err
+	}
+	req := &http.Request{
+		Method: method,
+		URL:    u,
+		Header: make(http.Header),
+	}
+	if endpoint.Body != "" {
+		body, err := execute(endpoint.Body, data)
+		if err != nil {
+			return nil, fmt.Errorf("body template: %v", err)
+		}
+		contentType := endpoint.ContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		req.Header.Set("Content-Type", contentType)
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+	}
+	return c.request(ctx, req)
+}
+
+// funcs are the extra template funcs.
+var funcs = template.FuncMap{
+	// json encodes the value as JSON, e.g. a quoted and escaped string.
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	// pathescape escapes the value for a path segment, e.g. slashes in SKUs.
+	"pathescape": func(v any) string {
+		return url.PathEscape(fmt.Sprint(v))
+	},
+}
+
+func execute(text string, data templateData) ([]byte, error) {
+	tmpl, err := template.New("").Option("missingkey=zero").Funcs(funcs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -1,6 +1,7 @@
 package shopee
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -18,20 +19,33 @@ func (c *Client) CredentialsManager() oauth2.CredentialsManager {
 	return c
 }
 
-func (c *Client) GenerateAuthorizationURL() string {
+func (c *Client) GenerateAuthorizationURL(state string) string {
 	const endpoint = "/api/v2/shop/auth_partner"
 
 	timestamp := time.Now().Unix()
 
+	// Shopee has no state param, but keeps the query of the redirect URL.
+	redirect := c.Config.RedirectURI
+	if u, err := url.Parse(redirect); err == nil {
+		query := u.Query()
+		query.Set("state", state)
+		u.RawQuery = query.Encode()
+		redirect = u.String()
+	}
+
 	return c.url(endpoint, url.Values{
 		"partner_id": []string{strconv.FormatInt(c.Config.PartnerID, 10)},
 		"timestamp":  []string{strconv.FormatInt(timestamp, 10)},
-		"sign":       []string{signature(c.Config, c.Credentials, endpoint, timestamp, signatureModePublicAPI)},
-		"redirect":   []string{c.Config.RedirectURI},
+		"sign":       []string{signature(c.Config, c.CurrentCredentials(), endpoint, timestamp, signatureModePublicAPI)},
+		"redirect":   []string{redirect},
 	}).String()
 }
 
-func (c *Client) GenerateCredentials(greq gjson.Result) (*oauth2.Credentials, error) {
+// refreshTokenLifetime is the lifetime of Shopee refresh tokens, which is not
+// returned by the API.
+const refreshTokenLifetime = 30 * 24 * time.Hour
+
+func (c *Client) GenerateCredentials(ctx context.Context, greq gjson.Result) (*oauth2.Credentials, error) {
 	body, err := json.Marshal(map[string]interface{}{
 		"code":       greq.Get("code").String(),
 		"shop_id":    c.Config.ShopID,
@@ -41,7 +55,7 @@ func (c *Client) GenerateCredentials(greq gjson.Result) (*oauth2.Credentials, er
 		return nil, fmt.Errorf("compose payload: %v", err)
 	}
 
-	gres, err := c.request(&http.Request{
+	gres, err := c.request(ctx, &http.Request{
 		Method: http.MethodPost,
 		URL:    c.url("/api/v2/auth/token/get", nil),
 		Body:   io.NopCloser(strings.NewReader(string(body))),
@@ -50,27 +64,27 @@ func (c *Client) GenerateCredentials(greq gjson.Result) (*oauth2.Credentials, er
 		return nil, fmt.Errorf("error response: %v", err)
 	}
 
-	c.Credentials = &oauth2.Credentials{
-		Tenant:       c.ID,
-		AccessToken:  gres.Get("access_token").String(),
-		RefreshToken: gres.Get("refresh_token").String(),
-		Expires:      time.Now().Add(time.Duration(gres.Get("expire_in").Int()) * time.Second),
-	}
-
-	return c.Credentials, err
+	return &oauth2.Credentials{
+		Tenant:         c.ID,
+		AccessToken:    gres.Get("access_token").String(),
+		RefreshToken:   gres.Get("refresh_token").String(),
+		Expires:        time.Now().Add(time.Duration(gres.Get("expire_in").Int()) * time.Second),
+		RefreshExpires: time.Now().Add(refreshTokenLifetime),
+		Source:         oauth2.SourceAuthorize,
+	}, nil
 }
 
-func (c *Client) RefreshCredentials() (*oauth2.Credentials, error) {
+func (c *Client) RefreshCredentials(ctx context.Context) (*oauth2.Credentials, error) {
 	body, err := json.Marshal(map[string]interface{}{
 		"shop_id":       c.Config.ShopID,
-		"refresh_token": c.Credentials.RefreshToken,
+		"refresh_token": c.CurrentCredentials().RefreshToken,
 		"partner_id":    c.Config.PartnerID,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("compose payload: %v", err)
 	}
 
-	gres, err := c.request(&http.Request{
+	gres, err := c.request(ctx, &http.Request{
 		Method: http.MethodPost,
 		URL:    c.url("/api/v2/auth/access_token/get", nil),
 		Body:   io.NopCloser(strings.NewReader(string(body))),
@@ -79,13 +93,50 @@ func (c *Client) RefreshCredentials() (*oauth2.Credentials, error) {
 		return nil, fmt.Errorf("error response: %v", err)
 	}
 
-	c.Credentials.AccessToken = gres.Get("access_token").String()
-	c.Credentials.RefreshToken = gres.Get("refresh_token").String()
-	c.Credentials.Expires = time.Now().Add(time.Duration(gres.Get("expire_in").Int()) * time.Second)
+	return &oauth2.Credentials{
+		Tenant:         c.ID,
+		AccessToken:    gres.Get("access_token").String(),
+		RefreshToken:   gres.Get("refresh_token").String(),
+		Expires:        time.Now().Add(time.Duration(gres.Get("expire_in").Int()) * time.Second),
+		RefreshExpires: time.Now().Add(refreshTokenLifetime),
+		Source:         oauth2.SourceRefresh,
+	}, nil
+}
 
-	return c.Credentials, nil
+// ProbeCredentials also checks that the credentials are authorized for the
+// configured shop.
+func (c *Client) ProbeCredentials(ctx context.Context, credentials *oauth2.Credentials) error {
+	probe := &Client{
+		BaseTenant:     c.BaseTenant,
+		DatabaseTenant: c.DatabaseTenant,
+		Config:         c.Config,
+		Credentials:    credentials,
+	}
+	gres, err := probe.doRequest(ctx, &http.Request{
+		Method: http.MethodGet,
+		URL:    probe.url("/api/v2/shop/get_shop_info", nil),
+	}, signatureMode(signatureModeShopAPI))
+	if err != nil {
+		return err
+	}
+	if shopID := gres.Get("shop_id"); shopID.Exists() && shopID.Int() != c.Config.ShopID {
+		return fmt.Errorf("%w %d", oauth2.ErrUnauthorizedShop, c.Config.ShopID)
+	}
+	return nil
 }
 
 func (c *Client) CredentialsExpiry() time.Time {
-	return c.Credentials.Expires
+	return c.CurrentCredentials().Expires
+}
+
+func (c *Client) CurrentCredentials() *oauth2.Credentials {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Credentials
+}
+
+func (c *Client) SetCredentials(credentials *oauth2.Credentials) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Credentials = credentials
 }
@@ -0,0 +1,64 @@
+package shopee
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/nmcapule/oclz-go/integrations/models"
+	"github.com/nmcapule/oclz-go/oauth2"
+)
+
+func TestProbeCredentials(t *testing.T) {
+	config := &Config{ShopID: 100, PartnerID: 7, PartnerKey: "partner-key"}
+	// shops are the shops of the access tokens.
+	shops := map[string]int64{
+		"token":       100,
+		"other-token": 200,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		token := query.Get("access_token")
+		timestamp, _ := strconv.ParseInt(query.Get("timestamp"), 10, 64)
+		creds := &oauth2.Credentials{AccessToken: token}
+		if query.Get("sign") != signature(config, creds, r.URL.Path, timestamp, signatureModeShopAPI) {
+			fmt.Fprint(w, `{"error": "error_sign", "message": "Wrong sign."}`)
+			return
+		}
+		shopID, ok := shops[token]
+		if !ok || query.Get("shop_id") != strconv.FormatInt(config.ShopID, 10) {
+			fmt.Fprint(w, `{"error": "invalid_access_token", "message": "Invalid access_token."}`)
+			return
+		}
+		fmt.Fprintf(w, `{"error": "", "shop_id": %d, "shop_name": "shop"}`, shopID)
+	}))
+	defer srv.Close()
+	config.Domain = srv.URL
+
+	tests := []struct {
+		token string
+		want  error
+	}{
+		{token: "token"},
+		{token: "other-token", want: oauth2.ErrUnauthorizedShop},
+		{token: "expired-token", want: oauth2.ErrInvalidToken},
+	}
+
+	client := &Client{
+		BaseTenant:  &models.BaseTenant{Name: "shopee"},
+		Config:      config,
+		Credentials: &oauth2.Credentials{AccessToken: "token"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.token, func(t *testing.T) {
+			err := client.ProbeCredentials(context.Background(), &oauth2.Credentials{AccessToken: tc.token})
+			if tc.want == nil && err != nil || !errors.Is(err, tc.want) {
+				t.Errorf("ProbeCredentials() error = %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
@@ -1,17 +1,18 @@
 package shopee
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/nmcapule/oclz-go/integrations/httpx"
 	"github.com/nmcapule/oclz-go/oauth2"
 	"github.com/tidwall/gjson"
 
@@ -23,6 +24,13 @@ const (
 	codeCallLimit = "TODO(ncapule): Find out the code for this"
 )
 
+// invalidTokenCodes are the errors of invalid access tokens. Shopee has both
+// spellings.
+var invalidTokenCodes = map[string]bool{
+	"invalid_access_token":  true,
+	"invalid_acceess_token": true,
+}
+
 type mode int
 
 const (
@@ -62,11 +70,47 @@ func (c *Client) url(endpoint string, query url.Values) *url.URL {
 	return u
 }
 
-func (c *Client) request(req *http.Request, opts ...requestOption) (*gjson.Result, error) {
+// request sends the request. If the access token is invalid, the credentials
+// are refreshed once and the request is retried.
+func (c *Client) request(ctx context.Context, req *http.Request, opts ...requestOption) (*gjson.Result, error) {
+	var config requestConfig
+	for _, opt := range opts {
+		opt(&config)
+	}
+	if config.stripAccessToken {
+		return c.doRequest(ctx, req, opts...)
+	}
+	stale := c.CurrentCredentials()
+	return oauth2.RetryOnInvalidToken(req, func(req *http.Request) (*gjson.Result, error) {
+		return c.doRequest(ctx, req, opts...)
+	}, func() error {
+		oauth2Service := &oauth2.Service{Dao: c.DatabaseTenant.Dao}
+		credentials, err := oauth2Service.Refresh(ctx, c.ID, c, stale)
+		if err != nil {
+			return err
+		}
+		c.SetCredentials(credentials)
+		return nil
+	})
+}
+
+func (c *Client) doRequest(ctx context.Context, req *http.Request, opts ...requestOption) (*gjson.Result, error) {
 	var config requestConfig
 	for _, opt := range opts {
 		opt(&config)
 	}
+	transport := &httpx.Client{
+		Sign: func(req *http.Request) error {
+			c.sign(req, config)
+			return nil
+		},
+		Classify: classify,
+	}
+	return transport.Do(ctx, req)
+}
+
+// sign sets the common params and the signature of the request.
+func (c *Client) sign(req *http.Request, config requestConfig) {
 	timestamp := time.Now().Unix()
 
 	// Harvest endpoint and query from request.
@@ -75,47 +119,32 @@ func (c *Client) request(req *http.Request, opts ...requestOption) (*gjson.Resul
 	query := req.URL.Query()
 	query.Set("partner_id", strconv.FormatInt(c.Config.PartnerID, 10))
 	query.Set("timestamp", strconv.FormatInt(timestamp, 10))
-	query.Set("sign", signature(c.Config, c.Credentials, endpoint, timestamp, config.signatureMode))
+	query.Set("sign", signature(c.Config, c.CurrentCredentials(), endpoint, timestamp, config.signatureMode))
 	if !config.stripAccessToken {
-		query.Set("access_token", c.Credentials.AccessToken)
+		query.Set("access_token", c.CurrentCredentials().AccessToken)
 	}
 	if !config.stripShopID {
 		query.Set("shop_id", strconv.FormatInt(c.Config.ShopID, 10))
 	}
 	req.URL.RawQuery = query.Encode()
+}
 
-	retry := 3
-	backoff := 1
-	var gres gjson.Result
-	for retry > 0 {
-		res, err := http.DefaultClient.Do(req)
-		if err != nil {
-			return nil, fmt.Errorf("http request: %v", err)
-		}
-		b, err := io.ReadAll(res.Body)
-		if err != nil {
-			return nil, fmt.Errorf("read body: %v", err)
-		}
-		gres = gjson.ParseBytes(b)
-		if gres.Get("warning").String() != codeOk {
-			log.Debugf("Shopee request warning: %s", gres.Get("warning").String())
-		}
-		if gres.Get("error").String() == codeOk {
-			break
-		}
-		if gres.Get("error").String() == codeCallLimit {
-			log.Warnln("api call exceeded, retrying...")
-			time.Sleep(time.Duration(backoff) * time.Second)
-			retry -= 1
-			backoff *= 2
-			continue
-		}
-		return nil, fmt.Errorf(
-			"%s: %s, %s",
-			gres.Get("request_id").String(),
-			gres.Get("error").String(), gres.Get("message"))
+// classify checks the error of the response.
+func classify(res *http.Response, gres gjson.Result) error {
+	if gres.Get("warning").String() != codeOk {
+		log.WithContext(res.Request.Context()).Debugf("Shopee request warning: %s", gres.Get("warning").String())
+	}
+	code := gres.Get("error").String()
+	switch {
+	case code == codeOk:
+		return nil
+	case code == codeCallLimit:
+		return fmt.Errorf("%s: %w: %s, %s", gres.Get("request_id").String(), httpx.ErrRetryable, code, gres.Get("message"))
+	case invalidTokenCodes[code]:
+		return fmt.Errorf("%s: %w: %s, %s", gres.Get("request_id").String(), oauth2.ErrInvalidToken, code, gres.Get("message"))
+	default:
+		return fmt.Errorf("%s: %s, %s", gres.Get("request_id").String(), code, gres.Get("message"))
 	}
-	return &gres, nil
 }
 
 func signature(config *Config, creds *oauth2.Credentials, endpoint string, timestamp int64, signatureMode mode) string {
@@ -124,9 +153,9 @@ func signature(config *Config, creds *oauth2.Credentials, endpoint string, times
 	case signatureModeShopAPI:
 		base = fmt.Sprintf("%s%s%d", base, creds.AccessToken, config.ShopID)
 	case signatureModeMerchantAPI:
-		log.Errorf("unimplemented: %s", signatureMode)
+		log.Errorf("unimplemented: %d", signatureMode)
 	default:
-		log.Debugf("passthru: %s", signatureMode)
+		log.Debugf("passthru: %d", signatureMode)
 	}
 
 	h := hmac.New(sha256.New, []byte(config.PartnerKey))
@@ -2,12 +2,14 @@
 package shopee
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/nmcapule/oclz-go/integrations/models"
@@ -22,10 +24,10 @@ const Vendor = "SHOPEE"
 
 // Config is a Lazada config.
 type Config struct {
-	Domain      string `json:"domain"`
-	ShopID      int64  `json:"shop_id"`
-	PartnerID   int64  `json:"partner_id"`
-	PartnerKey  string `json:"partner_key"`
+	Domain      string `json:"domain" jsonschema:"required"`
+	ShopID      int64  `json:"shop_id" jsonschema:"required"`
+	PartnerID   int64  `json:"partner_id" jsonschema:"required"`
+	PartnerKey  string `json:"partner_key" jsonschema:"required,secret"`
 	RedirectURI string `json:"redirect_uri"`
 }
 
@@ -34,18 +36,38 @@ type Client struct {
 	*models.BaseTenant
 	DatabaseTenant *models.BaseDatabaseTenant
 	Config         *Config
-	Credentials    *oauth2.Credentials
+	// Credentials are swapped on refreshes and reloads while requests are in
+	// flight, so they are read and set with CurrentCredentials and
+	// SetCredentials.
+	Credentials *oauth2.Credentials
+	mu          sync.Mutex
+}
+
+func init() {
+	// Credentials are optional, since the client is needed to generate them
+	// for the first time.
+	models.RegisterVendor(Vendor, models.CredentialsOptional, func(deps *models.VendorDeps, config *Config) (models.IntegrationClient, error) {
+		return &Client{
+			BaseTenant: deps.Tenant,
+			DatabaseTenant: &models.BaseDatabaseTenant{
+				BaseTenant: deps.Tenant,
+				Dao:        deps.Dao,
+			},
+			Config:      config,
+			Credentials: deps.Credentials,
+		}, nil
+	})
 }
 
 // CollectAllItems collects and returns all items registered in this client.
-func (c *Client) CollectAllItems() ([]*models.Item, error) {
+func (c *Client) CollectAllItems(ctx context.Context) ([]*models.Item, error) {
 	var items []*models.Item
 
 	var offset int64
 	const limit = 50
 
 	for {
-		base, err := c.request(&http.Request{
+		base, err := c.request(ctx, &http.Request{
 			Method: http.MethodGet,
 			URL: c.url("/api/v2/product/get_item_list", url.Values{
 				"offset":      []string{strconv.FormatInt(offset, 10)},
@@ -58,14 +80,14 @@ func (c *Client) CollectAllItems() ([]*models.Item, error) {
 		}
 
 		for _, product := range base.Get("response.item").Array() {
-			parsed, err := c.loadItemsFromProduct(int(product.Get("item_id").Int()))
+			parsed, err := c.loadItemsFromProduct(ctx, int(product.Get("item_id").Int()))
 			if err != nil {
 				return nil, fmt.Errorf("load items from models: %v", err)
 			}
 			items = append(items, parsed...)
 		}
 
-		log.WithFields(log.Fields{
+		log.WithContext(ctx).WithFields(log.Fields{
 			"tenant": c.Name,
 			"items":  len(items),
 			"offset": offset,
@@ -83,15 +105,15 @@ func (c *Client) CollectAllItems() ([]*models.Item, error) {
 
 // LoadItem returns item info for a single SKU. Loading items from the Shopee
 // client requires that this item has already been collected beforehand.
-func (c *Client) LoadItem(sku string) (*models.Item, error) {
-	cached, err := c.DatabaseTenant.LoadItem(sku)
+func (c *Client) LoadItem(ctx context.Context, sku string) (*models.Item, error) {
+	cached, err := c.DatabaseTenant.LoadItem(ctx, sku)
 	if err != nil {
 		return nil, fmt.Errorf("retrieving db tenant item: %v", err)
 	}
 	itemID := cached.TenantProps.Get("item_id").Int()
 
 	// Load all products and models associated to this item id.
-	items, err := c.loadItemsFromProduct(int(itemID))
+	items, err := c.loadItemsFromProduct(ctx, int(itemID))
 	if err != nil {
 		return nil, fmt.Errorf("load items from product: %v", err)
 	}
@@ -108,7 +130,7 @@ func (c *Client) LoadItem(sku string) (*models.Item, error) {
 		return nil, models.ErrNotFound
 	}
 	if len(items) > 1 {
-		log.Warningf("Multiple items with same SKU retrieved for %s: %+v", sku, items)
+		log.WithContext(ctx).Warningf("Multiple items with same SKU retrieved for %s: %+v", sku, items)
 	}
 	return items[0], nil
 }
@@ -116,8 +138,8 @@ func (c *Client) LoadItem(sku string) (*models.Item, error) {
 // SaveItem saves item info for a single SKU. This only implements updating
 // the product stock. Shopee API documentation:
 // https://open.shopee.com/documents/v2/v2.product.update_stock?module=89&type=1
-func (c *Client) SaveItem(item *models.Item) error {
-	_, err := c.request(&http.Request{
+func (c *Client) SaveItem(ctx context.Context, item *models.Item) error {
+	_, err := c.request(ctx, &http.Request{
 		Method: http.MethodPost,
 		URL:    c.url("/api/v2/product/update_stock", nil),
 		Body: io.NopCloser(strings.NewReader(utils.GJSONFrom(map[string]any{
@@ -138,14 +160,14 @@ func (c *Client) SaveItem(item *models.Item) error {
 	}
 
 	// Poll until the update is confirmed propagated to Shopee.
-	return scheduler.Retry(func() bool {
-		log.WithFields(log.Fields{
+	return scheduler.Retry(ctx, func() bool {
+		log.WithContext(ctx).WithFields(log.Fields{
 			"tenant":     c.Name,
 			"seller_sku": item.SellerSKU,
 		}).Debugln("Confirming item update...")
-		live, err := c.LoadItem(item.SellerSKU)
+		live, err := c.LoadItem(ctx, item.SellerSKU)
 		if err != nil {
-			log.WithFields(log.Fields{
+			log.WithContext(ctx).WithFields(log.Fields{
 				"tenant":     c.Name,
 				"seller_sku": item.SellerSKU,
 			}).Errorf("Failed to confirm item update: %v", err)
@@ -159,8 +181,8 @@ func (c *Client) SaveItem(item *models.Item) error {
 	})
 }
 
-func (c *Client) loadItemsFromProduct(id int) ([]*models.Item, error) {
-	base, err := c.request(&http.Request{
+func (c *Client) loadItemsFromProduct(ctx context.Context, id int) ([]*models.Item, error) {
+	base, err := c.request(ctx, &http.Request{
 		Method: http.MethodGet,
 		URL: c.url("/api/v2/product/get_item_base_info", url.Values{
 			"item_id_list": []string{strconv.Itoa(id)},
@@ -174,7 +196,7 @@ func (c *Client) loadItemsFromProduct(id int) ([]*models.Item, error) {
 	for _, item := range base.Get("response.item_list").Array() {
 		// If model exists, load from models endpoint instead.
 		if item.Get("has_model").Bool() {
-			parsed, err := c.loadItemsFromModelOfItemID(id)
+			parsed, err := c.loadItemsFromModelOfItemID(ctx, id)
 			if err != nil {
 				return nil, fmt.Errorf("load from model: %v", err)
 			}
@@ -182,12 +204,13 @@ func (c *Client) loadItemsFromProduct(id int) ([]*models.Item, error) {
 			continue
 		}
 		if item.Get("item_sku").String() == "" {
-			log.Debugf("skipping item %d, empty sku", id)
+			log.WithContext(ctx).Debugf("skipping item %d, empty sku", id)
 			continue
 		}
 		items = append(items, &models.Item{
 			SellerSKU: item.Get("item_sku").String(),
 			Stocks:    int(item.Get("stock_info_v2.summary_info.total_available_stock").Int()),
+			Reserved:  int(item.Get("stock_info_v2.summary_info.total_reserved_stock").Int()),
 			TenantProps: utils.GJSONFrom(map[string]any{
 				"item_id":        id,
 				"current_price":  item.Get("price_info.current_price").Float(),
@@ -200,8 +223,8 @@ func (c *Client) loadItemsFromProduct(id int) ([]*models.Item, error) {
 	return items, nil
 }
 
-func (c *Client) loadItemsFromModelOfItemID(itemID int) ([]*models.Item, error) {
-	base, err := c.request(&http.Request{
+func (c *Client) loadItemsFromModelOfItemID(ctx context.Context, itemID int) ([]*models.Item, error) {
+	base, err := c.request(ctx, &http.Request{
 		Method: http.MethodGet,
 		URL: c.url("/api/v2/product/get_model_list", url.Values{
 			"item_id": []string{strconv.Itoa(itemID)},
@@ -216,6 +239,7 @@ func (c *Client) loadItemsFromModelOfItemID(itemID int) ([]*models.Item, error)
 		items = append(items, &models.Item{
 			SellerSKU: model.Get("model_sku").String(),
 			Stocks:    int(model.Get("stock_info_v2.summary_info.total_available_stock").Int()),
+			Reserved:  int(model.Get("stock_info_v2.summary_info.total_reserved_stock").Int()),
 			TenantProps: utils.GJSONFrom(map[string]any{
 				"item_id":        itemID,
 				"model_id":       model.Get("model_id").Int(),
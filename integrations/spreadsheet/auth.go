@@ -0,0 +1,7 @@
+package spreadsheet
+
+import "github.com/nmcapule/oclz-go/oauth2"
+
+func (c *Client) CredentialsManager() oauth2.CredentialsManager {
+	return nil
+}
@@ -0,0 +1,7 @@
+package spreadsheet
+
+import "github.com/nmcapule/oclz-go/integrations/models"
+
+func (c *Client) Daemon() models.Daemon {
+	return nil
+}
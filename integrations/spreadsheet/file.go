@@ -0,0 +1,221 @@
+package spreadsheet
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pocketbase/pocketbase/models"
+)
+
+const uploadsCollection = "custom_uploads"
+
+// file is a stock file, either on disk or attached to an upload record.
+type file struct {
+	name     string
+	modified time.Time
+	open     func() ([]byte, error)
+}
+
+// latestFile returns the most recent of the source and export files.
+func (c *Client) latestFile() (*file, error) {
+	var source, export *file
+	var err error
+	if c.Config.Upload != "" {
+		source, err = c.uploadFile(c.Config.Upload)
+		if err != nil {
+			return nil, err
+		}
+		if source == nil {
+			return nil, fmt.Errorf("upload %q has no file", c.Config.Upload)
+		}
+		export, err = c.uploadFile(c.exportUpload())
+	} else {
+		source, err = c.directoryFile()
+		if err != nil {
+			return nil, err
+		}
+		export, err = diskFile(c.exportPath(source.name))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("checking export: %v", err)
+	}
+
+	if export != nil && export.modified.After(source.modified) {
+		return export, nil
+	}
+	return source, nil
+}
+
+// writeExport writes the table to the export file and returns the name and
+// modified time of the written file.
+func (c *Client) writeExport(t *table) (string, time.Time, error) {
+	ext := filepath.Ext(c.name)
+	if c.Config.Upload != "" {
+		return c.writeExportUpload(t, c.exportUpload()+ext)
+	}
+
+	path := c.exportPath(c.name)
+	data, err := t.encode(path, c.Config.Sheet)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", time.Time{}, err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", time.Time{}, err
+	}
+	f, err := diskFile(path)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return f.name, f.modified, nil
+}
+
+func (c *Client) writeExportUpload(t *table, filename string) (string, time.Time, error) {
+	data, err := t.encode(filename, c.Config.Sheet)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	collection, err := c.Dao.FindCollectionByNameOrId(uploadsCollection)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	record, err := c.Dao.FindFirstRecordByData(uploadsCollection, "name", c.exportUpload())
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", time.Time{}, fmt.Errorf("loading export upload: %v", err)
+	}
+	if record == nil {
+		record = models.NewRecord(collection)
+		record.RefreshId()
+		record.Set("name", c.exportUpload())
+	}
+
+	fs, err := c.Filesystem()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer fs.Close()
+	if err := fs.Upload(data, record.BaseFilesPath()+"/"+filename); err != nil {
+		return "", time.Time{}, fmt.Errorf("uploading %s: %v", filename, err)
+	}
+	if old := record.GetString("file"); old != "" && old != filename {
+		if err := fs.Delete(record.BaseFilesPath() + "/" + old); err != nil {
+			return "", time.Time{}, fmt.Errorf("deleting previous export %s: %v", old, err)
+		}
+	}
+	record.Set("file", filename)
+	if err := c.Dao.SaveRecord(record); err != nil {
+		return "", time.Time{}, err
+	}
+	return filename, record.GetDateTime("updated").Time(), nil
+}
+
+// uploadFile returns the file attached to the named upload record, or nil if
+// there is no such record or file.
+func (c *Client) uploadFile(name string) (*file, error) {
+	record, err := c.Dao.FindFirstRecordByData(uploadsCollection, "name", name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loading upload %q: %v", name, err)
+	}
+	filename := record.GetString("file")
+	if filename == "" {
+		return nil, nil
+	}
+	return &file{
+		name:     filename,
+		modified: record.GetDateTime("updated").Time(),
+		open: func() ([]byte, error) {
+			fs, err := c.Filesystem()
+			if err != nil {
+				return nil, err
+			}
+			defer fs.Close()
+			r, err := fs.GetFile(record.BaseFilesPath() + "/" + filename)
+			if err != nil {
+				return nil, err
+			}
+			defer r.Close()
+			return io.ReadAll(r)
+		},
+	}, nil
+}
+
+// directoryFile returns the most recently modified file in the watched
+// directory that matches the configured pattern.
+func (c *Client) directoryFile() (*file, error) {
+	pattern := c.Config.Pattern
+	if pattern == "" {
+		pattern = "*"
+	}
+	matches, err := filepath.Glob(filepath.Join(c.Config.Directory, pattern))
+	if err != nil {
+		return nil, fmt.Errorf("listing %s: %v", c.Config.Directory, err)
+	}
+	var latest *file
+	for _, path := range matches {
+		// Skip exports written into the watched directory.
+		if c.Config.ExportPath != "" && filepath.Clean(path) == filepath.Clean(c.Config.ExportPath) {
+			continue
+		}
+		f, err := diskFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if f == nil {
+			continue
+		}
+		if latest == nil || f.modified.After(latest.modified) {
+			latest = f
+		}
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("no files matching %q in %s", pattern, c.Config.Directory)
+	}
+	return latest, nil
+}
+
+func (c *Client) exportUpload() string {
+	if c.Config.ExportUpload != "" {
+		return c.Config.ExportUpload
+	}
+	return c.Config.Upload + "_export"
+}
+
+func (c *Client) exportPath(source string) string {
+	if c.Config.ExportPath != "" {
+		return c.Config.ExportPath
+	}
+	return filepath.Join(c.Config.Directory, "export", c.Name+filepath.Ext(source))
+}
+
+// diskFile returns the regular file at the given path, or nil if it does not
+// exist or is not a regular file.
+func diskFile(path string) (*file, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, nil
+	}
+	return &file{
+		name:     path,
+		modified: info.ModTime(),
+		open: func() ([]byte, error) {
+			return os.ReadFile(path)
+		},
+	}, nil
+}
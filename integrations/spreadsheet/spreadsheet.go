@@ -0,0 +1,228 @@
+// Package spreadsheet implements a tenant client over CSV or XLSX stock files,
+// e.g. stock feeds sent by consignment suppliers.
+package spreadsheet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nmcapule/oclz-go/integrations/models"
+	"github.com/nmcapule/oclz-go/utils"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const Vendor = "SPREADSHEET"
+
+// Config is a spreadsheet config. Exactly one of Upload or Directory must be
+// set as the source of the stock file.
+type Config struct {
+	// Upload is the name of the `custom_uploads` record holding the file.
+	Upload string `json:"upload"`
+	// Directory is watched for files matching Pattern. The most recently
+	// modified match is used.
+	Directory string `json:"directory"`
+	// Pattern is the glob pattern of files in Directory. Defaults to "*".
+	Pattern string `json:"pattern"`
+	// Sheet is the XLSX sheet to read. Defaults to the first sheet.
+	Sheet string `json:"sheet"`
+	// Columns maps header names in the file to item fields.
+	Columns Columns `json:"columns" jsonschema:"required"`
+	// ExportUpload is the name of the `custom_uploads` record that SaveItem
+	// writes to. Defaults to "<upload>_export".
+	ExportUpload string `json:"export_upload"`
+	// ExportPath is the file path that SaveItem writes to. Defaults to
+	// "<directory>/export/<tenant name>.<ext>".
+	ExportPath string `json:"export_path"`
+}
+
+// Columns maps header names in the file to item fields.
+type Columns struct {
+	SKU   string `json:"sku" jsonschema:"required"`
+	Qty   string `json:"qty" jsonschema:"required"`
+	Price string `json:"price"`
+}
+
+// Validate checks that exactly one file source is configured. Required
+// fields are checked by the config schema.
+func (c *Config) Validate() error {
+	if (c.Upload == "") == (c.Directory == "") {
+		return errors.New("exactly one of upload or directory must be configured")
+	}
+	return nil
+}
+
+// Client is a spreadsheet client.
+type Client struct {
+	*models.BaseTenant
+	Dao        *daos.Dao
+	Filesystem func() (*filesystem.System, error)
+	Config     *Config
+
+	mu       sync.Mutex
+	table    *table
+	name     string
+	modified time.Time
+}
+
+func init() {
+	models.RegisterVendor(Vendor, models.CredentialsNone, func(deps *models.VendorDeps, config *Config) (models.IntegrationClient, error) {
+		return &Client{
+			BaseTenant: deps.Tenant,
+			Dao:        deps.Dao,
+			Filesystem: deps.Filesystem,
+			Config:     config,
+		}, nil
+	})
+}
+
+// CollectAllItems collects and returns all items registered in this client.
+func (c *Client) CollectAllItems(ctx context.Context) ([]*models.Item, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t, err := c.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var items []*models.Item
+	for i := range t.rows {
+		item, err := c.itemFromRow(t, i)
+		if err != nil {
+			return nil, err
+		}
+		if item == nil {
+			continue
+		}
+		items = append(items, item)
+	}
+	log.WithContext(ctx).WithFields(log.Fields{
+		"tenant": c.Name,
+		"file":   c.name,
+		"items":  len(items),
+	}).Debugln("Loading fresh items")
+	return items, nil
+}
+
+// LoadItem returns item info for a single SKU.
+func (c *Client) LoadItem(ctx context.Context, sku string) (*models.Item, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t, err := c.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	row, err := t.find(c.Config.Columns.SKU, sku)
+	if err != nil {
+		return nil, err
+	}
+	return c.itemFromRow(t, row)
+}
+
+// SaveItem saves item info for a single SKU. This only implements updating
+// the quantity column, and writes the whole table to the export file.
+func (c *Client) SaveItem(ctx context.Context, item *models.Item) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t, err := c.load(ctx)
+	if err != nil {
+		return err
+	}
+	row, err := t.find(c.Config.Columns.SKU, item.SellerSKU)
+	if err != nil {
+		return err
+	}
+	if err := t.set(row, c.Config.Columns.Qty, strconv.Itoa(item.Stocks)); err != nil {
+		return err
+	}
+	name, modified, err := c.writeExport(t)
+	if err != nil {
+		return fmt.Errorf("writing export: %v", err)
+	}
+	c.name = name
+	c.modified = modified
+	return nil
+}
+
+// load returns the table from the most recent of the source and export
+// files, only parsing it again if it has changed since the last load.
+func (c *Client) load(ctx context.Context) (*table, error) {
+	f, err := c.latestFile()
+	if err != nil {
+		return nil, err
+	}
+	if c.table != nil && f.name == c.name && !f.modified.After(c.modified) {
+		return c.table, nil
+	}
+	data, err := f.open()
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %v", f.name, err)
+	}
+	t, err := parseTable(f.name, data, c.Config.Sheet)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", f.name, err)
+	}
+	log.WithContext(ctx).WithFields(log.Fields{
+		"tenant":   c.Name,
+		"file":     f.name,
+		"modified": f.modified,
+	}).Infoln("Loaded stock file")
+	c.table = t
+	c.name = f.name
+	c.modified = f.modified
+	return t, nil
+}
+
+func (c *Client) itemFromRow(t *table, row int) (*models.Item, error) {
+	sku, err := t.get(row, c.Config.Columns.SKU)
+	if err != nil {
+		return nil, err
+	}
+	if sku == "" {
+		return nil, nil
+	}
+	qty, err := t.get(row, c.Config.Columns.Qty)
+	if err != nil {
+		return nil, err
+	}
+	stocks, err := parseNumber(qty)
+	if err != nil {
+		return nil, fmt.Errorf("parsing quantity of %q: %v", sku, err)
+	}
+
+	props := map[string]any{
+		"file": c.name,
+		"row":  row,
+	}
+	if c.Config.Columns.Price != "" {
+		price, err := t.get(row, c.Config.Columns.Price)
+		if err != nil {
+			return nil, err
+		}
+		props["price"], _ = parseNumber(price)
+	}
+	return &models.Item{
+		SellerSKU:   sku,
+		Stocks:      int(stocks),
+		TenantProps: utils.GJSONFrom(props),
+	}, nil
+}
+
+// parseNumber parses numeric cells, which may be formatted with thousands
+// separators or decimals, e.g. "1,200.00".
+func parseNumber(s string) (float64, error) {
+	s = strings.ReplaceAll(strings.TrimSpace(s), ",", "")
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
@@ -0,0 +1,151 @@
+package spreadsheet
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/nmcapule/oclz-go/integrations/models"
+	"github.com/xuri/excelize/v2"
+)
+
+const defaultSheet = "Sheet1"
+
+// table is a parsed stock file, with the first row as the header.
+type table struct {
+	header []string
+	rows   [][]string
+}
+
+func parseTable(name string, data []byte, sheet string) (*table, error) {
+	var records [][]string
+	switch ext := strings.ToLower(filepath.Ext(name)); ext {
+	case ".csv":
+		r := csv.NewReader(bytes.NewReader(data))
+		r.FieldsPerRecord = -1
+		var err error
+		records, err = r.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+	case ".xlsx":
+		f, err := excelize.OpenReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if sheet == "" {
+			sheet = f.GetSheetList()[0]
+		}
+		records, err = f.GetRows(sheet)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported file type %q", ext)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("missing header row")
+	}
+	return &table{
+		header: records[0],
+		rows:   records[1:],
+	}, nil
+}
+
+// encode serializes the table into the format implied by the file name.
+func (t *table) encode(name string, sheet string) ([]byte, error) {
+	records := append([][]string{t.header}, t.rows...)
+	switch ext := strings.ToLower(filepath.Ext(name)); ext {
+	case ".csv":
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		if err := w.WriteAll(records); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case ".xlsx":
+		f := excelize.NewFile()
+		defer f.Close()
+		if sheet == "" {
+			sheet = defaultSheet
+		}
+		if err := f.SetSheetName(defaultSheet, sheet); err != nil {
+			return nil, err
+		}
+		for i, record := range records {
+			cell, err := excelize.CoordinatesToCellName(1, i+1)
+			if err != nil {
+				return nil, err
+			}
+			if err := f.SetSheetRow(sheet, cell, &record); err != nil {
+				return nil, err
+			}
+		}
+		buf, err := f.WriteToBuffer()
+		if err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unsupported file type %q", ext)
+	}
+}
+
+// column returns the index of the column with the given header name.
+func (t *table) column(name string) (int, error) {
+	for i, header := range t.header {
+		if strings.EqualFold(strings.TrimSpace(header), name) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("column %q not found in header %v", name, t.header)
+}
+
+// find returns the index of the only row with the given value in the column.
+func (t *table) find(column, value string) (int, error) {
+	col, err := t.column(column)
+	if err != nil {
+		return 0, err
+	}
+	found := -1
+	for i, row := range t.rows {
+		if col >= len(row) || strings.TrimSpace(row[col]) != value {
+			continue
+		}
+		if found >= 0 {
+			return 0, models.ErrMultipleItems
+		}
+		found = i
+	}
+	if found < 0 {
+		return 0, models.ErrNotFound
+	}
+	return found, nil
+}
+
+func (t *table) get(row int, column string) (string, error) {
+	col, err := t.column(column)
+	if err != nil {
+		return "", err
+	}
+	if col >= len(t.rows[row]) {
+		return "", nil
+	}
+	return strings.TrimSpace(t.rows[row][col]), nil
+}
+
+func (t *table) set(row int, column, value string) error {
+	col, err := t.column(column)
+	if err != nil {
+		return err
+	}
+	// Rows may be shorter than the header if trailing cells are empty.
+	for len(t.rows[row]) <= col {
+		t.rows[row] = append(t.rows[row], "")
+	}
+	t.rows[row][col] = value
+	return nil
+}
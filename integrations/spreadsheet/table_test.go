@@ -0,0 +1,223 @@
+package spreadsheet
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nmcapule/oclz-go/integrations/models"
+)
+
+func TestParseTable(t *testing.T) {
+	tests := []struct {
+		name       string
+		file       string
+		data       string
+		wantHeader []string
+		wantRows   [][]string
+		wantErr    bool
+	}{
+		{
+			name:       "rows",
+			file:       "stocks.csv",
+			data:       "SKU,Qty\nA-1,5\nA-2,7\n",
+			wantHeader: []string{"SKU", "Qty"},
+			wantRows:   [][]string{{"A-1", "5"}, {"A-2", "7"}},
+		},
+		{
+			name:       "header only",
+			file:       "stocks.csv",
+			data:       "SKU,Qty\n",
+			wantHeader: []string{"SKU", "Qty"},
+			wantRows:   [][]string{},
+		},
+		{
+			name:       "rows shorter than the header",
+			file:       "STOCKS.CSV",
+			data:       "SKU,Qty,Price\nA-1,5\nA-2\n",
+			wantHeader: []string{"SKU", "Qty", "Price"},
+			wantRows:   [][]string{{"A-1", "5"}, {"A-2"}},
+		},
+		{
+			name:    "empty",
+			file:    "stocks.csv",
+			wantErr: true,
+		},
+		{
+			name:    "unsupported",
+			file:    "stocks.txt",
+			data:    "SKU,Qty\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseTable(tc.file, []byte(tc.data), "")
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parseTable() error = %v, want error %t", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(got.header, tc.wantHeader) {
+				t.Errorf("header = %q, want %q", got.header, tc.wantHeader)
+			}
+			if !reflect.DeepEqual(got.rows, tc.wantRows) {
+				t.Errorf("rows = %q, want %q", got.rows, tc.wantRows)
+			}
+		})
+	}
+}
+
+func TestTableFind(t *testing.T) {
+	tbl := &table{
+		header: []string{" sku ", "Qty", "Variant"},
+		rows: [][]string{
+			{"A-1", "5"},
+			{" A-2 ", "7"},
+			{"B-1", "1"},
+			{"B-1", "2"},
+			{},
+		},
+	}
+
+	tests := []struct {
+		value   string
+		want    int
+		wantErr error
+	}{
+		{value: "A-1", want: 0},
+		{value: "A-2", want: 1},
+		{value: "B-1", wantErr: models.ErrMultipleItems},
+		{value: "C-1", wantErr: models.ErrNotFound},
+	}
+	for _, tc := range tests {
+		t.Run(tc.value, func(t *testing.T) {
+			got, err := tbl.find("SKU", tc.value)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("find() error = %v, want %v", err, tc.wantErr)
+			}
+			if err == nil && got != tc.want {
+				t.Errorf("find() = %d, want %d", got, tc.want)
+			}
+		})
+	}
+
+	// Rows shorter than the column don't match, and missing columns fail.
+	if _, err := tbl.find("Variant", "A-1"); !errors.Is(err, models.ErrNotFound) {
+		t.Errorf("find(Variant) error = %v, want %v", err, models.ErrNotFound)
+	}
+	if _, err := tbl.find("Price", "A-1"); err == nil {
+		t.Errorf("find(Price) succeeded, want an error for the missing column")
+	}
+}
+
+func TestTableSet(t *testing.T) {
+	tbl := &table{
+		header: []string{"SKU", "Qty", "Price"},
+		rows:   [][]string{{"A-1", "5", "10"}, {"A-2"}},
+	}
+	if err := tbl.set(0, "qty", "3"); err != nil {
+		t.Fatalf("set(0): %v", err)
+	}
+	// Short rows are padded up to the column.
+	if err := tbl.set(1, "Price", "20"); err != nil {
+		t.Fatalf("set(1): %v", err)
+	}
+	if err := tbl.set(1, "Stock", "1"); err == nil {
+		t.Errorf("set(Stock) succeeded, want an error for the missing column")
+	}
+	want := [][]string{{"A-1", "3", "10"}, {"A-2", "", "20"}}
+	if !reflect.DeepEqual(tbl.rows, want) {
+		t.Errorf("rows = %q, want %q", tbl.rows, want)
+	}
+	if got, err := tbl.get(1, "Qty"); err != nil || got != "" {
+		t.Errorf("get(1, Qty) = %q, %v, want empty", got, err)
+	}
+}
+
+func TestTableEncodeRoundTrip(t *testing.T) {
+	tbl := &table{
+		header: []string{"SKU", "Qty", "Note"},
+		rows: [][]string{
+			{"A-1", "5", "with, comma"},
+			{"A-2", "7", `with "quotes"`},
+			{"A-3", "0"},
+		},
+	}
+
+	tests := []struct {
+		file  string
+		sheet string
+	}{
+		{file: "stocks.csv"},
+		{file: "stocks.xlsx"},
+		{file: "stocks.xlsx", sheet: "Stocks"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.file+"/"+tc.sheet, func(t *testing.T) {
+			data, err := tbl.encode(tc.file, tc.sheet)
+			if err != nil {
+				t.Fatalf("encode(): %v", err)
+			}
+			got, err := parseTable(tc.file, data, tc.sheet)
+			if err != nil {
+				t.Fatalf("parseTable(): %v", err)
+			}
+			if !reflect.DeepEqual(got, tbl) {
+				t.Errorf("round trip = %q %q, want %q %q", got.header, got.rows, tbl.header, tbl.rows)
+			}
+		})
+	}
+}
+
+func TestSaveItemDirectory(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "stocks.csv")
+	if err := os.WriteFile(source, []byte("SKU,Qty\nA-1,5\nA-2,1,200\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	// Backdate the source, so that the export is newer on coarse clocks too.
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(source, old, old); err != nil {
+		t.Fatalf("Chtimes: %v", err)
+	}
+	c := &Client{
+		BaseTenant: &models.BaseTenant{Name: "supplier"},
+		Config: &Config{
+			Directory: dir,
+			Pattern:   "*.csv",
+			Columns:   Columns{SKU: "SKU", Qty: "Qty"},
+		},
+	}
+	ctx := context.Background()
+
+	if err := c.SaveItem(ctx, &models.Item{SellerSKU: "A-1", Stocks: 3}); err != nil {
+		t.Fatalf("SaveItem(): %v", err)
+	}
+	if err := c.SaveItem(ctx, &models.Item{SellerSKU: "A-9", Stocks: 3}); !errors.Is(err, models.ErrNotFound) {
+		t.Errorf("SaveItem(A-9) error = %v, want %v", err, models.ErrNotFound)
+	}
+	export, err := os.ReadFile(filepath.Join(dir, "export", "supplier.csv"))
+	if err != nil {
+		t.Fatalf("reading export: %v", err)
+	}
+	if want := "SKU,Qty\nA-1,3\nA-2,1,200\n"; string(export) != want {
+		t.Errorf("export = %q, want %q", export, want)
+	}
+
+	// A fresh client loads the export, which is newer than the source.
+	c = &Client{BaseTenant: c.BaseTenant, Config: c.Config}
+	item, err := c.LoadItem(ctx, "A-1")
+	if err != nil {
+		t.Fatalf("LoadItem(): %v", err)
+	}
+	if item.Stocks != 3 {
+		t.Errorf("LoadItem().Stocks = %d, want 3", item.Stocks)
+	}
+}
@@ -1,6 +1,7 @@
 package tiktok
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -14,19 +15,19 @@ func (c *Client) CredentialsManager() oauth2.CredentialsManager {
 	return c
 }
 
-func (c *Client) GenerateAuthorizationURL() string {
+func (c *Client) GenerateAuthorizationURL(state string) string {
 	endpoint := "https://auth.tiktok-shops.com/oauth/authorize"
 
-	// TODO: state must be randomized as per documentation:
+	// The state must be random as per documentation:
 	// https://developers.tiktok-shops.com/documents/document/234120
 	return c.url(endpoint, url.Values{
 		"app_key": []string{c.Config.AppKey},
-		"state":   []string{c.Config.AppKey},
+		"state":   []string{state},
 	}).String()
 }
 
-func (c *Client) GenerateCredentials(greq gjson.Result) (*oauth2.Credentials, error) {
-	gres, err := c.request(&http.Request{
+func (c *Client) GenerateCredentials(ctx context.Context, greq gjson.Result) (*oauth2.Credentials, error) {
+	gres, err := c.request(ctx, &http.Request{
 		Method: http.MethodGet,
 		URL: c.url("https://auth.tiktok-shops.com/api/v2/token/get", url.Values{
 			"app_secret": []string{c.Config.AppSecret},
@@ -38,22 +39,22 @@ func (c *Client) GenerateCredentials(greq gjson.Result) (*oauth2.Credentials, er
 		return nil, fmt.Errorf("error response: %v", err)
 	}
 
-	c.Credentials = &oauth2.Credentials{
-		Tenant:       c.ID,
-		AccessToken:  gres.Get("data.access_token").String(),
-		RefreshToken: gres.Get("data.refresh_token").String(),
-		Expires:      time.Unix(gres.Get("data.access_token_expire_in").Int(), 0),
-	}
-
-	return c.Credentials, err
+	return &oauth2.Credentials{
+		Tenant:         c.ID,
+		AccessToken:    gres.Get("data.access_token").String(),
+		RefreshToken:   gres.Get("data.refresh_token").String(),
+		Expires:        time.Unix(gres.Get("data.access_token_expire_in").Int(), 0),
+		RefreshExpires: time.Unix(gres.Get("data.refresh_token_expire_in").Int(), 0),
+		Source:         oauth2.SourceAuthorize,
+	}, nil
 }
 
-func (c *Client) RefreshCredentials() (*oauth2.Credentials, error) {
-	gres, err := c.request(&http.Request{
+func (c *Client) RefreshCredentials(ctx context.Context) (*oauth2.Credentials, error) {
+	gres, err := c.request(ctx, &http.Request{
 		Method: http.MethodGet,
 		URL: c.url("https://auth.tiktok-shops.com/api/v2/token/refresh", url.Values{
 			"app_secret":    []string{c.Config.AppSecret},
-			"refresh_token": []string{c.Credentials.RefreshToken},
+			"refresh_token": []string{c.CurrentCredentials().RefreshToken},
 			"grant_type":    []string{"refresh_token"},
 		}),
 	}, tokenRetrievalMode)
@@ -61,16 +62,52 @@ func (c *Client) RefreshCredentials() (*oauth2.Credentials, error) {
 		return nil, fmt.Errorf("error response: %v", err)
 	}
 
-	c.Credentials = &oauth2.Credentials{
-		Tenant:       c.ID,
-		AccessToken:  gres.Get("data.access_token").String(),
-		RefreshToken: gres.Get("data.refresh_token").String(),
-		Expires:      time.Unix(gres.Get("data.access_token_expire_in").Int(), 0),
-	}
+	return &oauth2.Credentials{
+		Tenant:         c.ID,
+		AccessToken:    gres.Get("data.access_token").String(),
+		RefreshToken:   gres.Get("data.refresh_token").String(),
+		Expires:        time.Unix(gres.Get("data.access_token_expire_in").Int(), 0),
+		RefreshExpires: time.Unix(gres.Get("data.refresh_token_expire_in").Int(), 0),
+		Source:         oauth2.SourceRefresh,
+	}, nil
+}
 
-	return c.Credentials, err
+// ProbeCredentials also checks that the credentials are authorized for the
+// configured shop.
+func (c *Client) ProbeCredentials(ctx context.Context, credentials *oauth2.Credentials) error {
+	probe := &Client{
+		BaseTenant:  c.BaseTenant,
+		Config:      c.Config,
+		Credentials: credentials,
+		Dao:         c.Dao,
+	}
+	gres, err := probe.doRequest(ctx, &http.Request{
+		Method: http.MethodGet,
+		URL:    probe.url("/api/shop/get_authorized_shop", nil),
+	})
+	if err != nil {
+		return err
+	}
+	for _, shop := range gres.Get("data.shop_list").Array() {
+		if shop.Get("shop_id").String() == c.Config.ShopID {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w %s", oauth2.ErrUnauthorizedShop, c.Config.ShopID)
 }
 
 func (c *Client) CredentialsExpiry() time.Time {
-	return c.Credentials.Expires
+	return c.CurrentCredentials().Expires
+}
+
+func (c *Client) CurrentCredentials() *oauth2.Credentials {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Credentials
+}
+
+func (c *Client) SetCredentials(credentials *oauth2.Credentials) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Credentials = credentials
 }
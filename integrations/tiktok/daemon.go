@@ -1,11 +1,15 @@
 package tiktok
 
-import "github.com/nmcapule/oclz-go/integrations/models"
+import (
+	"context"
+
+	"github.com/nmcapule/oclz-go/integrations/models"
+)
 
 func (c *Client) Daemon() models.Daemon {
 	return c
 }
 
-func (c *Client) Start() error {
+func (c *Client) Start(ctx context.Context) error {
 	return nil
 }
@@ -1,17 +1,19 @@
 package tiktok
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
 	"sort"
 	"strings"
 	"time"
 
+	"github.com/nmcapule/oclz-go/integrations/httpx"
+	"github.com/nmcapule/oclz-go/oauth2"
 	log "github.com/sirupsen/logrus"
 	"github.com/tidwall/gjson"
 )
@@ -20,6 +22,13 @@ const (
 	messageOk = "Success"
 )
 
+// invalidTokenCodes are the error codes of invalid or expired access tokens.
+var invalidTokenCodes = map[string]bool{
+	"105001":   true,
+	"105002":   true,
+	"36004004": true,
+}
+
 type requestConfig struct {
 	tokenRetrievalMode bool
 }
@@ -45,12 +54,47 @@ func (c *Client) url(endpoint string, query url.Values) *url.URL {
 	return u
 }
 
-func (c *Client) request(req *http.Request, opts ...requestOption) (*gjson.Result, error) {
+// request sends the request. If the access token is invalid, the credentials
+// are refreshed once and the request is retried.
+func (c *Client) request(ctx context.Context, req *http.Request, opts ...requestOption) (*gjson.Result, error) {
+	var config requestConfig
+	for _, opt := range opts {
+		opt(&config)
+	}
+	if config.tokenRetrievalMode {
+		return c.doRequest(ctx, req, opts...)
+	}
+	stale := c.CurrentCredentials()
+	return oauth2.RetryOnInvalidToken(req, func(req *http.Request) (*gjson.Result, error) {
+		return c.doRequest(ctx, req, opts...)
+	}, func() error {
+		oauth2Service := &oauth2.Service{Dao: c.Dao}
+		credentials, err := oauth2Service.Refresh(ctx, c.ID, c, stale)
+		if err != nil {
+			return err
+		}
+		c.SetCredentials(credentials)
+		return nil
+	})
+}
+
+func (c *Client) doRequest(ctx context.Context, req *http.Request, opts ...requestOption) (*gjson.Result, error) {
 	var config requestConfig
 	for _, opt := range opts {
 		opt(&config)
 	}
+	transport := &httpx.Client{
+		Sign: func(req *http.Request) error {
+			c.sign(req, config)
+			return nil
+		},
+		Classify: classify,
+	}
+	return transport.Do(ctx, req)
+}
 
+// sign sets the common params and the signature of the request.
+func (c *Client) sign(req *http.Request, config requestConfig) {
 	timestamp := time.Now().Unix()
 
 	// Harvest endpoint and query from request.
@@ -64,34 +108,23 @@ func (c *Client) request(req *http.Request, opts ...requestOption) (*gjson.Resul
 		query.Set("shop_id", c.Config.ShopID)
 		// Sign before setting access token.
 		query.Set("sign", signature(c.Config, endpoint, query))
-		query.Set("access_token", c.Credentials.AccessToken)
+		query.Set("access_token", c.CurrentCredentials().AccessToken)
 	}
 	req.URL.RawQuery = query.Encode()
-	req.Header = map[string][]string{
-		"Content-Type": {"application/json"},
-	}
+	req.Header.Set("Content-Type", "application/json")
+}
 
-	retry := 3
-	var gres gjson.Result
-	for retry > 0 {
-		res, err := http.DefaultClient.Do(req)
-		if err != nil {
-			return nil, fmt.Errorf("http request: %v", err)
-		}
-		b, err := io.ReadAll(res.Body)
-		if err != nil {
-			return nil, fmt.Errorf("read body: %v", err)
-		}
-		gres = gjson.ParseBytes(b)
-		if strings.EqualFold(gres.Get("message").String(), messageOk) {
-			break
-		}
-		return nil, fmt.Errorf(
-			"%s: %s, %s",
-			gres.Get("request_id").String(),
-			gres.Get("code").String(), gres.Get("message"))
+// classify checks the message and error code of the response.
+func classify(res *http.Response, gres gjson.Result) error {
+	code := gres.Get("code").String()
+	switch {
+	case strings.EqualFold(gres.Get("message").String(), messageOk):
+		return nil
+	case invalidTokenCodes[code]:
+		return fmt.Errorf("%s: %w: %s, %s", gres.Get("request_id").String(), oauth2.ErrInvalidToken, code, gres.Get("message"))
+	default:
+		return fmt.Errorf("%s: %s, %s", gres.Get("request_id").String(), code, gres.Get("message"))
 	}
-	return &gres, nil
 }
 
 func signature(config *Config, endpoint string, query url.Values) string {
@@ -1,6 +1,7 @@
 package tiktok
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -8,12 +9,14 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/nmcapule/oclz-go/integrations/models"
 	"github.com/nmcapule/oclz-go/oauth2"
 	"github.com/nmcapule/oclz-go/utils"
 	"github.com/nmcapule/oclz-go/utils/scheduler"
+	"github.com/pocketbase/pocketbase/daos"
 	"github.com/tidwall/gjson"
 
 	log "github.com/sirupsen/logrus"
@@ -24,10 +27,10 @@ const Vendor = "TIKTOK"
 
 // Config is a tiktok config.
 type Config struct {
-	Domain      string `json:"domain"`
-	AppKey      string `json:"app_key"`
-	AppSecret   string `json:"app_secret"`
-	ShopID      string `json:"shop_id"`
+	Domain      string `json:"domain" jsonschema:"required"`
+	AppKey      string `json:"app_key" jsonschema:"required"`
+	AppSecret   string `json:"app_secret" jsonschema:"required,secret"`
+	ShopID      string `json:"shop_id" jsonschema:"required"`
 	WarehouseID string `json:"warehouse_id"`
 	RedirectURI string `json:"redirect_uri"`
 }
@@ -35,19 +38,39 @@ type Config struct {
 // Client is a tiktok client.
 type Client struct {
 	*models.BaseTenant
-	Config      *Config
+	Config *Config
+	// Credentials are swapped on refreshes and reloads while requests are in
+	// flight, so they are read and set with CurrentCredentials and
+	// SetCredentials.
 	Credentials *oauth2.Credentials
+	mu          sync.Mutex
+	// Dao saves the credentials refreshed on invalid access tokens.
+	Dao *daos.Dao
 }
 
-func (c *Client) parseItemsFromSearch(data gjson.Result) []*models.Item {
+func init() {
+	models.RegisterVendor(Vendor, models.CredentialsRequired, func(deps *models.VendorDeps, config *Config) (models.IntegrationClient, error) {
+		return &Client{
+			BaseTenant:  deps.Tenant,
+			Config:      config,
+			Credentials: deps.Credentials,
+			Dao:         deps.Dao,
+		}, nil
+	})
+}
+
+func (c *Client) parseItemsFromSearch(ctx context.Context, data gjson.Result) []*models.Item {
 	var items []*models.Item
 	data.Get("products").ForEach(func(_, product gjson.Result) bool {
 		product.Get("skus").ForEach(func(_, sku gjson.Result) bool {
 			if sku.Get("seller_sku").String() == "" {
-				log.Debugln("Skipping sku_id:%s, empty seller_sku", sku.Get("id").String())
+				log.WithContext(ctx).Debugf("Skipping sku_id:%s, empty seller_sku", sku.Get("id").String())
 				return true
 			}
 
+			// The search doesn't report the stocks locked by pending orders, so
+			// Reserved is left at zero and the available stocks are counted
+			// as on-hand.
 			stocks := 0
 			sku.Get("stock_infos").ForEach(func(_, info gjson.Result) bool {
 				if info.Get("warehouse_id").String() == c.Config.WarehouseID {
@@ -70,8 +93,8 @@ func (c *Client) parseItemsFromSearch(data gjson.Result) []*models.Item {
 	return items
 }
 
-func (c *Client) defaultWarehouseID() (string, error) {
-	base, err := c.request(&http.Request{
+func (c *Client) defaultWarehouseID(ctx context.Context) (string, error) {
+	base, err := c.request(ctx, &http.Request{
 		Method: http.MethodPost,
 		URL:    c.url("/api/v2/product/get_item_list", url.Values{}),
 	})
@@ -79,9 +102,9 @@ func (c *Client) defaultWarehouseID() (string, error) {
 }
 
 // CollectAllItems collects and returns all items registered in this client.
-func (c *Client) CollectAllItems() ([]*models.Item, error) {
+func (c *Client) CollectAllItems(ctx context.Context) ([]*models.Item, error) {
 	if c.Config.WarehouseID == "" {
-		id, err := c.defaultWarehouseID()
+		id, err := c.defaultWarehouseID(ctx)
 		if err != nil {
 			return nil, fmt.Errorf("retrieve warehouse: %v", err)
 		}
@@ -92,7 +115,7 @@ func (c *Client) CollectAllItems() ([]*models.Item, error) {
 	var page int64 = 1
 	const limit = int64(50)
 	for {
-		base, err := c.request(&http.Request{
+		base, err := c.request(ctx, &http.Request{
 			Method: http.MethodPost,
 			URL: c.url("/api/products/search", url.Values{
 				"page_number": []string{strconv.FormatInt(page, 10)},
@@ -103,9 +126,9 @@ func (c *Client) CollectAllItems() ([]*models.Item, error) {
 			return nil, fmt.Errorf("error response: %v", err)
 		}
 
-		items = append(items, c.parseItemsFromSearch(base.Get("data"))...)
+		items = append(items, c.parseItemsFromSearch(ctx, base.Get("data"))...)
 
-		log.WithFields(log.Fields{
+		log.WithContext(ctx).WithFields(log.Fields{
 			"tenant": c.Name,
 			"items":  len(items),
 			"offset": page * limit,
@@ -122,7 +145,7 @@ func (c *Client) CollectAllItems() ([]*models.Item, error) {
 }
 
 // LoadItem returns item info for a single SKU.
-func (c *Client) LoadItem(sku string) (*models.Item, error) {
+func (c *Client) LoadItem(ctx context.Context, sku string) (*models.Item, error) {
 	body, err := json.Marshal(map[string]interface{}{
 		"seller_sku_list": sku,
 	})
@@ -130,7 +153,7 @@ func (c *Client) LoadItem(sku string) (*models.Item, error) {
 		return nil, fmt.Errorf("compose payload: %v", err)
 	}
 
-	base, err := c.request(&http.Request{
+	base, err := c.request(ctx, &http.Request{
 		Method: http.MethodPost,
 		URL: c.url("/api/products/search", url.Values{
 			"page_number": []string{strconv.FormatInt(1, 10)},
@@ -142,7 +165,7 @@ func (c *Client) LoadItem(sku string) (*models.Item, error) {
 		return nil, fmt.Errorf("error response: %v", err)
 	}
 	// Collect only items with matching SKU.
-	items := c.parseItemsFromSearch(base.Get("data"))
+	items := c.parseItemsFromSearch(ctx, base.Get("data"))
 	var filtered []*models.Item
 	for i := range items {
 		if items[i].SellerSKU == sku {
@@ -155,15 +178,15 @@ func (c *Client) LoadItem(sku string) (*models.Item, error) {
 		return nil, models.ErrNotFound
 	}
 	if len(items) > 1 {
-		log.Warn("multiple items found for %q: %v", sku, models.ErrMultipleItems)
+		log.WithContext(ctx).Warnf("multiple items found for %q: %v", sku, models.ErrMultipleItems)
 	}
 	return items[0], nil
 }
 
 // SaveItem saves item info for a single SKU.
 // This only implements updating the product stock.
-func (c *Client) SaveItem(item *models.Item) error {
-	_, err := c.request(&http.Request{
+func (c *Client) SaveItem(ctx context.Context, item *models.Item) error {
+	_, err := c.request(ctx, &http.Request{
 		Method: http.MethodPut,
 		URL:    c.url("/api/products/stocks", nil),
 		Body: io.NopCloser(strings.NewReader(utils.GJSONFrom(map[string]any{
@@ -186,14 +209,14 @@ func (c *Client) SaveItem(item *models.Item) error {
 	}
 
 	// Poll until the update is confirmed propagated to Tiktok.
-	return scheduler.Retry(func() bool {
-		log.WithFields(log.Fields{
+	return scheduler.Retry(ctx, func() bool {
+		log.WithContext(ctx).WithFields(log.Fields{
 			"tenant":     c.Name,
 			"seller_sku": item.SellerSKU,
 		}).Debugln("Confirming item update...")
-		live, err := c.LoadItem(item.SellerSKU)
+		live, err := c.LoadItem(ctx, item.SellerSKU)
 		if err != nil {
-			log.WithFields(log.Fields{
+			log.WithContext(ctx).WithFields(log.Fields{
 				"tenant":     c.Name,
 				"seller_sku": item.SellerSKU,
 			}).Errorf("Failed to confirm item update: %v", err)
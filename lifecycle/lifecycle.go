@@ -0,0 +1,151 @@
+// Package lifecycle starts the background services, and stops them
+// gracefully on shutdown: services first stop taking new work and drain the
+// work in progress, and only then are they cancelled.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultTimeout is the deadline to drain the services, which fits in
+	// the kill timeout of the deployment (5s on Fly) with DefaultGrace.
+	DefaultTimeout = 3 * time.Second
+	// DefaultGrace is how long cancelled services have to return.
+	DefaultGrace = time.Second
+)
+
+// ErrStopped is returned when starting a service after shutting down.
+var ErrStopped = errors.New("lifecycle is stopped")
+
+// Service is a background service.
+type Service interface {
+	// Start runs the service until the context is done.
+	Start(ctx context.Context) error
+	// Drain stops the service from taking new work, and waits for the work
+	// in progress until the context is done.
+	Drain(ctx context.Context) error
+}
+
+// Manager runs the background services until shutdown.
+type Manager struct {
+	// Timeout defaults to DefaultTimeout.
+	Timeout time.Duration
+	// Grace defaults to DefaultGrace.
+	Grace time.Duration
+
+	mu       sync.Mutex
+	ctx      context.Context
+	cancel   context.CancelFunc
+	services map[string]Service
+	stopped  bool
+	wg       sync.WaitGroup
+}
+
+// New creates a manager.
+func New() *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{
+		ctx:      ctx,
+		cancel:   cancel,
+		services: make(map[string]Service),
+	}
+}
+
+// Go starts the service in the background. Errors of the service are logged,
+// since they should not take down the other services.
+func (m *Manager) Go(name string, service Service) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopped {
+		return ErrStopped
+	}
+	if _, ok := m.services[name]; ok {
+		return fmt.Errorf("service %q is already started", name)
+	}
+	m.services[name] = service
+	m.wg.Add(1)
+
+	go func() {
+		defer m.wg.Done()
+		logger := log.WithFields(log.Fields{
+			"service": name,
+		})
+		logger.Infoln("Background service has started")
+		if err := service.Start(m.ctx); err != nil {
+			logger.Errorf("Background service unexpectedly exited: %v", err)
+			return
+		}
+		logger.Infoln("Background service has finished")
+	}()
+	return nil
+}
+
+// Shutdown drains all services within the timeout, and then cancels them and
+// waits for them to return within the grace period. Returns an error listing
+// the services that failed to drain or return in time.
+func (m *Manager) Shutdown() error {
+	m.mu.Lock()
+	m.stopped = true
+	services := make(map[string]Service, len(m.services))
+	for name, service := range m.services {
+		services[name] = service
+	}
+	m.mu.Unlock()
+
+	timeout := m.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	grace := m.Grace
+	if grace <= 0 {
+		grace = DefaultGrace
+	}
+
+	log.Infof("Draining %d background services...", len(services))
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	var failed []string
+	var wg sync.WaitGroup
+	for name, service := range services {
+		wg.Add(1)
+		go func(name string, service Service) {
+			defer wg.Done()
+			if err := service.Drain(ctx); err != nil {
+				log.WithFields(log.Fields{
+					"service": name,
+				}).Warnf("Failed to drain background service: %v", err)
+				mu.Lock()
+				failed = append(failed, name)
+				mu.Unlock()
+			}
+		}(name, service)
+	}
+	wg.Wait()
+
+	m.cancel()
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(grace):
+		failed = append(failed, "services did not return in time")
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("shutdown: %s", strings.Join(failed, ", "))
+	}
+	log.Infoln("Background services have stopped")
+	return nil
+}
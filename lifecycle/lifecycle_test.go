@@ -0,0 +1,116 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeService is a service that runs until its context is done, or until
+// released if it ignores cancellation.
+type fakeService struct {
+	// drain is how long draining takes, or forever if negative.
+	drain time.Duration
+	// stuck services ignore cancellation and run until released.
+	stuck   bool
+	release chan struct{}
+
+	started   chan struct{}
+	cancelled chan struct{}
+}
+
+func newFakeService(drain time.Duration, stuck bool) *fakeService {
+	return &fakeService{
+		drain:     drain,
+		stuck:     stuck,
+		release:   make(chan struct{}),
+		started:   make(chan struct{}),
+		cancelled: make(chan struct{}),
+	}
+}
+
+func (s *fakeService) Start(ctx context.Context) error {
+	close(s.started)
+	<-ctx.Done()
+	close(s.cancelled)
+	if s.stuck {
+		<-s.release
+	}
+	return nil
+}
+
+func (s *fakeService) Drain(ctx context.Context) error {
+	if s.drain < 0 {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	select {
+	case <-time.After(s.drain):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	tests := []struct {
+		name    string
+		service *fakeService
+		// wantErr is a substring of the expected error, if any.
+		wantErr string
+	}{
+		{
+			name:    "drained in time",
+			service: newFakeService(time.Millisecond, false),
+		},
+		{
+			name:    "drain timeout",
+			service: newFakeService(-1, false),
+			wantErr: "slow",
+		},
+		{
+			name:    "grace period exceeded",
+			service: newFakeService(0, true),
+			wantErr: "did not return in time",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			defer close(tc.service.release)
+			m := New()
+			m.Timeout = 50 * time.Millisecond
+			m.Grace = 50 * time.Millisecond
+			if err := m.Go("slow", tc.service); err != nil {
+				t.Fatalf("Go(): %v", err)
+			}
+			<-tc.service.started
+
+			err := m.Shutdown()
+			if tc.wantErr == "" && err != nil {
+				t.Errorf("Shutdown() = %v, want no error", err)
+			}
+			if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Errorf("Shutdown() = %v, want an error with %q", err, tc.wantErr)
+			}
+			// Services are cancelled after draining, even if draining failed.
+			select {
+			case <-tc.service.cancelled:
+			default:
+				t.Errorf("service was not cancelled")
+			}
+		})
+	}
+}
+
+func TestGoAfterShutdown(t *testing.T) {
+	m := New()
+	if err := m.Shutdown(); err != nil {
+		t.Fatalf("Shutdown(): %v", err)
+	}
+	if err := m.Go("late", newFakeService(0, false)); !errors.Is(err, ErrStopped) {
+		t.Errorf("Go() = %v, want %v", err, ErrStopped)
+	}
+}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/nmcapule/oclz-go/lifecycle"
 	"github.com/nmcapule/oclz-go/syncer"
 	"github.com/nmcapule/oclz-go/views"
 	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/cmd"
 	"github.com/pocketbase/pocketbase/core"
 
 	log "github.com/sirupsen/logrus"
@@ -12,36 +18,78 @@ import (
 func main() {
 	app := pocketbase.New()
 	noSync := app.RootCmd.PersistentFlags().Bool("nosync", true, "Set to true to deactivate syncing.")
+	shutdownTimeout := app.RootCmd.PersistentFlags().Duration("shutdownTimeout", lifecycle.DefaultTimeout, "Deadline to drain the syncers on shutdown.")
+
+	manager := lifecycle.New()
+
+	syncer.HookConfigValidation(app)
+	syncer.HookSecrets(app)
+	app.RootCmd.AddCommand(syncer.NewSecretsCommand(app))
 
 	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
-		syncer, err := syncer.NewSyncer(app.Dao(), "circuit.rocks")
+		syncers, err := syncer.NewSyncers(app)
 		if err != nil {
-			log.Fatalf("instantiate syncer: %v", err)
+			log.Fatalf("instantiate syncers: %v", err)
 		}
 
 		// Set up custom routes using root view.
 		routes := views.RootView{
-			App:    app,
-			Syncer: syncer,
+			App:     app,
+			Syncers: syncers,
 		}
 		if err := routes.Hook(e.Router); err != nil {
-			log.Fatalf("Hooking custom routes: %w", err)
+			log.Fatalf("Hooking custom routes: %v", err)
 		}
 
 		// If we're not supposed to sync, just return.
 		if *noSync {
 			return nil
 		}
-		go func() {
-			log.Infoln("Syncer background service has started.")
-			err := syncer.Start()
-			if err != nil {
-				log.Fatalf("Syncer background service unexpectedly exited: %v", err)
+		for name, s := range syncers {
+			if err := manager.Go("syncer/"+name, s); err != nil {
+				s.Logger.Errorf("Failed to start syncer background service: %v", err)
 			}
-			log.Infoln("Syncer background service has finished.")
-		}()
+		}
 		return nil
 	})
 
-	log.Fatal(app.Start())
+	app.RootCmd.AddCommand(cmd.NewServeCommand(app, true))
+	app.RootCmd.AddCommand(cmd.NewTempUpgradeCommand(app))
+	if err := execute(app, func() error {
+		manager.Timeout = *shutdownTimeout
+		return manager.Shutdown()
+	}); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// execute runs the root command same as PocketBase's Execute, but shuts down
+// the background services before the app resources are released. PocketBase
+// has no terminate hook, and releases the database right on interrupt, which
+// would cut the syncs that are still draining.
+func execute(app *pocketbase.PocketBase, shutdown func() error) error {
+	if _, _, err := app.RootCmd.Find(os.Args[1:]); err == nil && !app.IsBootstrapped() {
+		if err := app.Bootstrap(); err != nil {
+			return err
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := app.RootCmd.Execute(); err != nil {
+			log.Errorln(err)
+		}
+	}()
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	select {
+	case <-quit:
+	case <-done:
+	}
+
+	if err := shutdown(); err != nil {
+		log.Errorf("Failed to shut down gracefully: %v", err)
+	}
+	return app.ResetBootstrapState()
 }
@@ -0,0 +1,168 @@
+package oauth2
+
+import (
+	"fmt"
+
+	"github.com/nmcapule/oclz-go/secrets"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+)
+
+// Source is how credentials were obtained.
+type Source string
+
+const (
+	// SourceAuthorize is an authorization of the tenant from the marketplace.
+	SourceAuthorize Source = "AUTHORIZE"
+	// SourceRefresh is a refresh of the previous credentials.
+	SourceRefresh Source = "REFRESH"
+	// SourceRollback is a rollback to a previous version.
+	SourceRollback Source = "ROLLBACK"
+)
+
+// HistoryLimit is the number of credentials versions kept per tenant.
+const HistoryLimit = 20
+
+const historyCollection = "tenant_oauth2_history"
+
+// Version is a version of the credentials of a tenant.
+type Version struct {
+	*Credentials
+	ID string
+	// Promoted is true if the version was used as the current credentials.
+	Promoted bool
+	// Note is why the version was not promoted, if any.
+	Note string
+}
+
+// Reject keeps the credentials in the history without promoting them, e.g.
+// because they failed validation, so that they can still be rolled back to.
+func (s *Service) Reject(credentials *Credentials, reason string) error {
+	return s.saveVersion(credentials, false, reason)
+}
+
+// History returns the credentials versions of the tenant, newest first.
+func (s *Service) History(tenant string) ([]*Version, error) {
+	records, err := s.findVersions(tenant)
+	if err != nil {
+		return nil, err
+	}
+	var versions []*Version
+	for _, record := range records {
+		version, err := versionFrom(record)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+// Rollback promotes the version to the current credentials of the tenant.
+func (s *Service) Rollback(tenant, versionID string) (*Credentials, error) {
+	record, err := s.Dao.FindRecordById(historyCollection, versionID)
+	if err != nil || record.GetString("tenant") != tenant {
+		return nil, fmt.Errorf("no credentials version %q", versionID)
+	}
+	version, err := versionFrom(record)
+	if err != nil {
+		return nil, err
+	}
+	credentials := *version.Credentials
+	credentials.Source = SourceRollback
+	if err := s.Save(&credentials); err != nil {
+		return nil, err
+	}
+	return &credentials, nil
+}
+
+func (s *Service) findVersions(tenant string) ([]*models.Record, error) {
+	collection, err := s.Dao.FindCollectionByNameOrId(historyCollection)
+	if err != nil {
+		return nil, err
+	}
+	var records []*models.Record
+	err = s.Dao.RecordQuery(collection).
+		AndWhere(dbx.HashExp{"tenant": tenant}).
+		OrderBy("created DESC").
+		All(&records)
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// saveVersion appends the credentials to the history of the tenant, and drops
+// the oldest versions past HistoryLimit.
+func (s *Service) saveVersion(credentials *Credentials, promoted bool, note string) error {
+	return s.Dao.RunInTransaction(func(txDao *daos.Dao) error {
+		collection, err := txDao.FindCollectionByNameOrId(historyCollection)
+		if err != nil {
+			return err
+		}
+		accessToken, err := secrets.Encrypt(credentials.AccessToken)
+		if err != nil {
+			return fmt.Errorf("encrypting access token: %v", err)
+		}
+		refreshToken, err := secrets.Encrypt(credentials.RefreshToken)
+		if err != nil {
+			return fmt.Errorf("encrypting refresh token: %v", err)
+		}
+		source := credentials.Source
+		if source == "" {
+			source = SourceRefresh
+		}
+
+		record := models.NewRecord(collection)
+		record.Set("tenant", credentials.Tenant)
+		record.Set("access_token", accessToken)
+		record.Set("refresh_token", refreshToken)
+		record.Set("expires", credentials.Expires)
+		if !credentials.RefreshExpires.IsZero() {
+			record.Set("refresh_expires", credentials.RefreshExpires)
+		}
+		record.Set("source", source)
+		record.Set("promoted", promoted)
+		record.Set("note", note)
+		if err := txDao.SaveRecord(record); err != nil {
+			return fmt.Errorf("saving credentials version: %v", err)
+		}
+
+		versions, err := (&Service{Dao: txDao}).findVersions(credentials.Tenant)
+		if err != nil {
+			return err
+		}
+		for i := HistoryLimit; i < len(versions); i++ {
+			if err := txDao.DeleteRecord(versions[i]); err != nil {
+				return fmt.Errorf("dropping old credentials version: %v", err)
+			}
+		}
+		return nil
+	})
+}
+
+func versionFrom(record *models.Record) (*Version, error) {
+	accessToken, err := secrets.Decrypt(record.GetString("access_token"))
+	if err != nil {
+		return nil, fmt.Errorf("decrypting access token: %v", err)
+	}
+	refreshToken, err := secrets.Decrypt(record.GetString("refresh_token"))
+	if err != nil {
+		return nil, fmt.Errorf("decrypting refresh token: %v", err)
+	}
+	return &Version{
+		Credentials: &Credentials{
+			Tenant:         record.GetString("tenant"),
+			AccessToken:    accessToken,
+			RefreshToken:   refreshToken,
+			Expires:        record.GetDateTime("expires").Time(),
+			RefreshExpires: record.GetDateTime("refresh_expires").Time(),
+			Source:         Source(record.GetString("source")),
+			Created:        record.GetDateTime("created").Time(),
+		},
+		ID:       record.GetId(),
+		Promoted: record.GetBool("promoted"),
+		Note:     record.GetString("note"),
+	}, nil
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/nmcapule/oclz-go/secrets"
 	"github.com/pocketbase/dbx"
 	"github.com/pocketbase/pocketbase/daos"
 	"github.com/pocketbase/pocketbase/models"
@@ -19,11 +20,20 @@ type Credentials struct {
 	Tenant       string
 	AccessToken  string
 	RefreshToken string
-	Expires      time.Time
-	Created      time.Time
-	Updated      time.Time
+	// Expires is the expiry of the access token.
+	Expires time.Time
+	// RefreshExpires is the expiry of the refresh token, after which the
+	// tenant has to be authorized again. Zero if unknown.
+	RefreshExpires time.Time
+	// Source is how the credentials were obtained, which is kept in the
+	// credentials history.
+	Source  Source
+	Created time.Time
+	Updated time.Time
 }
 
+// Service loads and saves credentials. The tokens are encrypted at rest with
+// the keyring from the environment, and only decrypted in memory.
 type Service struct {
 	Dao *daos.Dao
 }
@@ -33,21 +43,47 @@ func (s *Service) Load(tenant string) (*Credentials, error) {
 	if err != nil {
 		return nil, ErrNoCredentials
 	}
+	accessToken, err := secrets.Decrypt(record.GetString("access_token"))
+	if err != nil {
+		return nil, fmt.Errorf("decrypting access token: %v", err)
+	}
+	refreshToken, err := secrets.Decrypt(record.GetString("refresh_token"))
+	if err != nil {
+		return nil, fmt.Errorf("decrypting refresh token: %v", err)
+	}
 	return &Credentials{
 		Tenant:       tenant,
-		AccessToken:  record.GetString("access_token"),
-		RefreshToken: record.GetString("refresh_token"),
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
 		// Expires:      record.GetTime("expires"),
 		// Created:      record.GetTime("created"),
 		// Updated:      record.GetTime("updated"),
 		// TODO(nmcapule): Above doesn't work, so we do a workaround.
-		Expires: record.GetDateTime("expires").Time(),
-		Created: record.GetDateTime("created").Time(),
-		Updated: record.GetDateTime("updated").Time(),
+		Expires:        record.GetDateTime("expires").Time(),
+		RefreshExpires: record.GetDateTime("refresh_expires").Time(),
+		Created:        record.GetDateTime("created").Time(),
+		Updated:        record.GetDateTime("updated").Time(),
 	}, nil
 }
 
+// Save promotes the credentials to the current credentials of the tenant, and
+// keeps them in the credentials history.
 func (s *Service) Save(credentials *Credentials) error {
+	return s.save(credentials, "")
+}
+
+// save is Save with a note in the credentials history.
+func (s *Service) save(credentials *Credentials, note string) error {
+	return s.Dao.RunInTransaction(func(txDao *daos.Dao) error {
+		tx := &Service{Dao: txDao}
+		if err := tx.saveCurrent(credentials); err != nil {
+			return err
+		}
+		return tx.saveVersion(credentials, true, note)
+	})
+}
+
+func (s *Service) saveCurrent(credentials *Credentials) error {
 	collection, err := s.Dao.FindCollectionByNameOrId("tenant_oauth2")
 	if err != nil {
 		return err
@@ -62,6 +98,15 @@ func (s *Service) Save(credentials *Credentials) error {
 		return ErrMultipleCredentials
 	}
 
+	accessToken, err := secrets.Encrypt(credentials.AccessToken)
+	if err != nil {
+		return fmt.Errorf("encrypting access token: %v", err)
+	}
+	refreshToken, err := secrets.Encrypt(credentials.RefreshToken)
+	if err != nil {
+		return fmt.Errorf("encrypting refresh token: %v", err)
+	}
+
 	var record *models.Record
 	if len(records) == 1 {
 		record = records[0]
@@ -69,8 +114,11 @@ func (s *Service) Save(credentials *Credentials) error {
 		record = models.NewRecord(collection)
 	}
 	record.Set("tenant", credentials.Tenant)
-	record.Set("access_token", credentials.AccessToken)
-	record.Set("refresh_token", credentials.RefreshToken)
+	record.Set("access_token", accessToken)
+	record.Set("refresh_token", refreshToken)
 	record.Set("expires", credentials.Expires)
+	if !credentials.RefreshExpires.IsZero() {
+		record.Set("refresh_expires", credentials.RefreshExpires)
+	}
 	return s.Dao.Save(record)
 }
@@ -0,0 +1,146 @@
+package oauth2
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ErrInvalidToken is wrapped by vendor request errors when the access token
+// is invalid, e.g. revoked or expired early.
+var ErrInvalidToken = errors.New("invalid access token")
+
+// ErrUnauthorizedShop is wrapped by probe errors when the credentials work,
+// but are not authorized for the configured shop.
+var ErrUnauthorizedShop = errors.New("credentials are not authorized for the shop")
+
+// refresh is an in-flight refresh of the credentials of a tenant.
+type refresh struct {
+	done        chan struct{}
+	credentials *Credentials
+	err         error
+}
+
+var (
+	refreshesMu sync.Mutex
+	refreshes   = make(map[string]*refresh)
+)
+
+// Refresh refreshes the credentials of the tenant through the credentials
+// manager, and promotes them. Concurrent refreshes of the same tenant are
+// coalesced into one, and share its result. If stale is set and the saved
+// credentials already differ from it, e.g. because another request just
+// refreshed them, the saved credentials are returned instead. Waiting for a
+// refresh of another caller stops once the context is done.
+func (s *Service) Refresh(ctx context.Context, tenant string, cm CredentialsManager, stale *Credentials) (*Credentials, error) {
+	refreshesMu.Lock()
+	if r, ok := refreshes[tenant]; ok {
+		refreshesMu.Unlock()
+		select {
+		case <-r.done:
+			return r.credentials, r.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	r := &refresh{done: make(chan struct{})}
+	refreshes[tenant] = r
+	refreshesMu.Unlock()
+
+	r.credentials, r.err = s.refresh(ctx, tenant, cm, stale)
+
+	refreshesMu.Lock()
+	delete(refreshes, tenant)
+	refreshesMu.Unlock()
+	close(r.done)
+	return r.credentials, r.err
+}
+
+func (s *Service) refresh(ctx context.Context, tenant string, cm CredentialsManager, stale *Credentials) (*Credentials, error) {
+	if stale != nil {
+		current, err := s.Load(tenant)
+		if err == nil && current.AccessToken != stale.AccessToken {
+			return current, nil
+		}
+	}
+	credentials, err := cm.RefreshCredentials(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("refreshing credentials: %v", err)
+	}
+	if err := s.Promote(ctx, cm, credentials); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// Promote saves the credentials as the current credentials of the tenant,
+// unless they are empty or the probe request rejects them, i.e. fails with
+// ErrInvalidToken or ErrUnauthorizedShop. Rejected credentials are only kept
+// in the credentials history, and the current credentials are kept. Other
+// probe failures, e.g. the vendor being down, say nothing about the
+// credentials, so they are promoted anyway with a note in the history, since
+// the previous refresh token may already be used up.
+func (s *Service) Promote(ctx context.Context, cm CredentialsManager, credentials *Credentials) error {
+	reject := func(reason string) error {
+		if err := s.Reject(credentials, reason); err != nil {
+			return fmt.Errorf("rejected credentials: %s (keeping them failed: %v)", reason, err)
+		}
+		return fmt.Errorf("rejected credentials: %s", reason)
+	}
+	var empty []string
+	if credentials.AccessToken == "" {
+		empty = append(empty, "access_token")
+	}
+	if credentials.RefreshToken == "" {
+		empty = append(empty, "refresh_token")
+	}
+	if len(empty) > 0 {
+		return reject(fmt.Sprintf("got empty %s", strings.Join(empty, " and ")))
+	}
+	var note string
+	if err := cm.ProbeCredentials(ctx, credentials); err != nil {
+		if errors.Is(err, ErrInvalidToken) || errors.Is(err, ErrUnauthorizedShop) {
+			return reject(fmt.Sprintf("probe failed: %v", err))
+		}
+		note = fmt.Sprintf("promoted without a successful probe: %v", err)
+	}
+	if err := s.save(credentials, note); err != nil {
+		return fmt.Errorf("save credentials: %v", err)
+	}
+	return nil
+}
+
+// RetryOnInvalidToken sends the request with do. If it fails with
+// ErrInvalidToken, the credentials are refreshed once with refresh, and the
+// request is sent again as it was before do changed it.
+func RetryOnInvalidToken[T any](req *http.Request, do func(*http.Request) (T, error), refresh func() error) (T, error) {
+	rawQuery := req.URL.RawQuery
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			var zero T
+			return zero, fmt.Errorf("read body: %v", err)
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	res, err := do(req)
+	if !errors.Is(err, ErrInvalidToken) {
+		return res, err
+	}
+	if err := refresh(); err != nil {
+		return res, fmt.Errorf("%v, and refreshing credentials failed: %v", ErrInvalidToken, err)
+	}
+
+	req.URL.RawQuery = rawQuery
+	if body != nil {
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	return do(req)
+}
@@ -0,0 +1,78 @@
+package oauth2
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+)
+
+// StateTTL is how long an issued state can be used to authorize a tenant.
+const StateTTL = 10 * time.Minute
+
+var ErrInvalidState = errors.New("invalid or expired oauth2 state")
+
+type state struct {
+	tenant  string
+	session [sha256.Size]byte
+	expires time.Time
+}
+
+// States issues the random `state` values of authorization URLs, which are
+// verified in the callback to protect against CSRF. A state can only be used
+// once, by the same tenant and session that it was issued for.
+//
+// States are only kept in memory, so authorizations that are pending when the
+// process restarts fail in the callback, and have to be started again.
+type States struct {
+	mu     sync.Mutex
+	states map[string]state
+}
+
+// Issue returns a new state for authorizing the tenant from the session.
+func (s *States) Issue(tenant, session string) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	value := base64.RawURLEncoding.EncodeToString(raw)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.states == nil {
+		s.states = make(map[string]state)
+	}
+	now := time.Now()
+	for key, st := range s.states {
+		if now.After(st.expires) {
+			delete(s.states, key)
+		}
+	}
+	s.states[value] = state{
+		tenant:  tenant,
+		session: sha256.Sum256([]byte(session)),
+		expires: now.Add(StateTTL),
+	}
+	return value, nil
+}
+
+// Verify consumes the state, and returns ErrInvalidState unless it was issued
+// for the tenant and session and has not expired.
+func (s *States) Verify(value, tenant, session string) error {
+	s.mu.Lock()
+	st, ok := s.states[value]
+	delete(s.states, value)
+	s.mu.Unlock()
+
+	if !ok || value == "" || time.Now().After(st.expires) || st.tenant != tenant {
+		return ErrInvalidState
+	}
+	hash := sha256.Sum256([]byte(session))
+	if session == "" || subtle.ConstantTimeCompare(st.session[:], hash[:]) != 1 {
+		return ErrInvalidState
+	}
+	return nil
+}
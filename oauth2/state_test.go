@@ -0,0 +1,88 @@
+package oauth2
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStates(t *testing.T) {
+	tests := []struct {
+		name    string
+		tenant  string
+		session string
+		// expire expires the state before it is verified.
+		expire  bool
+		wantErr bool
+	}{
+		{name: "valid", tenant: "shop", session: "session"},
+		{name: "other tenant", tenant: "other", session: "session", wantErr: true},
+		{name: "other session", tenant: "shop", session: "other", wantErr: true},
+		{name: "empty session", tenant: "shop", session: "", wantErr: true},
+		{name: "expired", tenant: "shop", session: "session", expire: true, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var states States
+			value, err := states.Issue("shop", "session")
+			if err != nil {
+				t.Fatalf("Issue: %v", err)
+			}
+			if tc.expire {
+				st := states.states[value]
+				st.expires = time.Now().Add(-time.Second)
+				states.states[value] = st
+			}
+			err = states.Verify(value, tc.tenant, tc.session)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Verify() error = %v, want error %t", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestStatesSingleUse(t *testing.T) {
+	var states States
+	value, err := states.Issue("shop", "session")
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if err := states.Verify(value, "shop", "session"); err != nil {
+		t.Fatalf("first Verify: %v", err)
+	}
+	if err := states.Verify(value, "shop", "session"); err != ErrInvalidState {
+		t.Errorf("second Verify error = %v, want %v", err, ErrInvalidState)
+	}
+
+	// A failed verification also consumes the state.
+	value, _ = states.Issue("shop", "session")
+	_ = states.Verify(value, "other", "session")
+	if err := states.Verify(value, "shop", "session"); err != ErrInvalidState {
+		t.Errorf("Verify after mismatch error = %v, want %v", err, ErrInvalidState)
+	}
+}
+
+func TestStatesUnknown(t *testing.T) {
+	var states States
+	for _, value := range []string{"", "unknown"} {
+		if err := states.Verify(value, "shop", "session"); err != ErrInvalidState {
+			t.Errorf("Verify(%q) error = %v, want %v", value, err, ErrInvalidState)
+		}
+	}
+}
+
+func TestStatesPruneExpired(t *testing.T) {
+	var states States
+	expired, _ := states.Issue("shop", "session")
+	st := states.states[expired]
+	st.expires = time.Now().Add(-time.Second)
+	states.states[expired] = st
+
+	value, _ := states.Issue("shop", "session")
+	if _, ok := states.states[expired]; ok {
+		t.Errorf("expired state was kept after issuing another")
+	}
+	if value == expired {
+		t.Errorf("Issue returned the same state twice")
+	}
+}
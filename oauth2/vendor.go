@@ -1,14 +1,30 @@
 package oauth2
 
 import (
+	"context"
 	"time"
 
 	"github.com/tidwall/gjson"
 )
 
 type CredentialsManager interface {
-	GenerateAuthorizationURL() string
-	GenerateCredentials(data gjson.Result) (*Credentials, error)
-	RefreshCredentials() (*Credentials, error)
+	// GenerateAuthorizationURL returns the URL to authorize the tenant. The
+	// state must be passed back to the callback, see States.
+	GenerateAuthorizationURL(state string) string
+	GenerateCredentials(ctx context.Context, data gjson.Result) (*Credentials, error)
+	// RefreshCredentials returns new credentials from the current ones. The
+	// current credentials are kept until the new ones are saved.
+	RefreshCredentials(ctx context.Context) (*Credentials, error)
+	// ProbeCredentials checks that the credentials work for the tenant with a
+	// cheap authenticated request, before they are promoted.
+	ProbeCredentials(ctx context.Context, credentials *Credentials) error
 	CredentialsExpiry() time.Time
 }
+
+// CredentialsHolder is implemented by clients that keep their credentials in
+// memory, so that credentials saved elsewhere, e.g. by a refresh of another
+// client of the tenant, can be swapped in without rebuilding the client.
+type CredentialsHolder interface {
+	CurrentCredentials() *Credentials
+	SetCredentials(credentials *Credentials)
+}
@@ -0,0 +1,277 @@
+// Package secrets encrypts secrets at rest, e.g. oauth2 tokens and vendor API
+// keys, using envelope encryption. Each value is encrypted with its own random
+// data key, and the data key is encrypted with a key from the environment, so
+// that rotating the key only re-encrypts the data keys.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	// KeyEnv is the environment variable of the base64-encoded 32-byte key
+	// that encrypts the secrets.
+	KeyEnv = "OCLZ_SECRETS_KEY"
+	// PreviousKeysEnv is the environment variable of the comma-separated
+	// previous keys, which are only used to decrypt secrets that have not yet
+	// been rotated.
+	PreviousKeysEnv = "OCLZ_SECRETS_PREVIOUS_KEYS"
+
+	prefix  = "enc:v1:"
+	keySize = 32
+)
+
+var (
+	ErrNoKey       = errors.New("secret is encrypted, but no key is set in " + KeyEnv)
+	ErrUnknownKey  = errors.New("secret is encrypted with an unknown key")
+	ErrInvalidKey  = fmt.Errorf("key must be %d base64-encoded bytes", keySize)
+	ErrInvalidData = errors.New("invalid encrypted secret")
+)
+
+type key struct {
+	id   string
+	aead cipher.AEAD
+}
+
+func parseKey(encoded string) (*key, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(raw) != keySize {
+		return nil, ErrInvalidKey
+	}
+	aead, err := newAEAD(raw)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(raw)
+	return &key{id: hex.EncodeToString(sum[:4]), aead: aead}, nil
+}
+
+func newAEAD(raw []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func seal(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(aead cipher.AEAD, sealed []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrInvalidData
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, ErrInvalidData
+	}
+	return plaintext, nil
+}
+
+// GenerateKey returns a new random base64-encoded key.
+func GenerateKey() (string, error) {
+	raw := make([]byte, keySize)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(raw), nil
+}
+
+// Keyring encrypts secrets with its primary key, and decrypts secrets with
+// any of its keys. A nil keyring leaves secrets in plain text.
+type Keyring struct {
+	primary *key
+	keys    map[string]*key
+}
+
+// NewKeyring creates a keyring from base64-encoded keys. The primary key
+// encrypts new secrets, and the previous keys can still decrypt old secrets.
+func NewKeyring(primary string, previous ...string) (*Keyring, error) {
+	k, err := parseKey(primary)
+	if err != nil {
+		return nil, err
+	}
+	keyring := &Keyring{
+		primary: k,
+		keys:    map[string]*key{k.id: k},
+	}
+	for _, encoded := range previous {
+		if strings.TrimSpace(encoded) == "" {
+			continue
+		}
+		k, err := parseKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("previous key: %v", err)
+		}
+		keyring.keys[k.id] = k
+	}
+	return keyring, nil
+}
+
+// KeyringFromEnv creates a keyring from KeyEnv and PreviousKeysEnv. Returns a
+// nil keyring if KeyEnv is not set.
+func KeyringFromEnv() (*Keyring, error) {
+	primary := os.Getenv(KeyEnv)
+	if primary == "" {
+		return nil, nil
+	}
+	keyring, err := NewKeyring(primary, strings.Split(os.Getenv(PreviousKeysEnv), ",")...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", KeyEnv, err)
+	}
+	return keyring, nil
+}
+
+var (
+	defaultOnce    sync.Once
+	defaultKeyring *Keyring
+	defaultErr     error
+)
+
+// Default returns the keyring from the environment, which is loaded once.
+func Default() (*Keyring, error) {
+	defaultOnce.Do(func() {
+		defaultKeyring, defaultErr = KeyringFromEnv()
+	})
+	return defaultKeyring, defaultErr
+}
+
+// IsEncrypted returns true if the value is an encrypted secret.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// Encrypt encrypts the plain text value. Values that are empty or already
+// encrypted are returned as is, and so are all values if the keyring is nil.
+func (k *Keyring) Encrypt(value string) (string, error) {
+	if k == nil || value == "" || IsEncrypted(value) {
+		return value, nil
+	}
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := seal(aead, []byte(value))
+	if err != nil {
+		return "", err
+	}
+	return k.wrap(dataKey, sealed)
+}
+
+// wrap encrypts the data key with the primary key, and formats the encrypted
+// secret as `enc:v1:<key id>:<encrypted data key>:<encrypted value>`.
+func (k *Keyring) wrap(dataKey, sealed []byte) (string, error) {
+	wrapped, err := seal(k.primary.aead, dataKey)
+	if err != nil {
+		return "", err
+	}
+	return prefix + strings.Join([]string{
+		k.primary.id,
+		base64.RawStdEncoding.EncodeToString(wrapped),
+		base64.RawStdEncoding.EncodeToString(sealed),
+	}, ":"), nil
+}
+
+// unwrap returns the data key and the encrypted value of the secret.
+func (k *Keyring) unwrap(value string) ([]byte, []byte, error) {
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 {
+		return nil, nil, ErrInvalidData
+	}
+	if k == nil {
+		return nil, nil, ErrNoKey
+	}
+	kek, ok := k.keys[parts[0]]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w %q", ErrUnknownKey, parts[0])
+	}
+	wrapped, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, nil, ErrInvalidData
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, nil, ErrInvalidData
+	}
+	dataKey, err := open(kek.aead, wrapped)
+	if err != nil {
+		return nil, nil, err
+	}
+	return dataKey, sealed, nil
+}
+
+// Decrypt decrypts the encrypted secret. Plain text values are returned as is,
+// e.g. secrets saved before encryption was enabled.
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	dataKey, sealed, err := k.unwrap(value)
+	if err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(aead, sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// Rotate re-encrypts the secret with the primary key of the keyring. Only the
+// data key is re-encrypted. Plain text values are encrypted, and secrets that
+// are already encrypted with the primary key are returned as is.
+func (k *Keyring) Rotate(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return k.Encrypt(value)
+	}
+	dataKey, sealed, err := k.unwrap(value)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(value, prefix+k.primary.id+":") {
+		return value, nil
+	}
+	return k.wrap(dataKey, sealed)
+}
+
+// Encrypt encrypts the value with the default keyring.
+func Encrypt(value string) (string, error) {
+	keyring, err := Default()
+	if err != nil {
+		return "", err
+	}
+	return keyring.Encrypt(value)
+}
+
+// Decrypt decrypts the value with the default keyring.
+func Decrypt(value string) (string, error) {
+	keyring, err := Default()
+	if err != nil {
+		return "", err
+	}
+	return keyring.Decrypt(value)
+}
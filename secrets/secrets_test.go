@@ -0,0 +1,171 @@
+package secrets
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func newTestKeyring(t *testing.T, previous ...string) (*Keyring, string) {
+	t.Helper()
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	keyring, err := NewKeyring(key, previous...)
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	return keyring, key
+}
+
+func TestRoundTrip(t *testing.T) {
+	keyring, _ := newTestKeyring(t)
+
+	for _, value := range []string{"secret", "with:colons", "ünïcödé", strings.Repeat("x", 4096)} {
+		encrypted, err := keyring.Encrypt(value)
+		if err != nil {
+			t.Fatalf("Encrypt(%q): %v", value, err)
+		}
+		if !IsEncrypted(encrypted) || strings.Contains(encrypted, value) {
+			t.Errorf("Encrypt(%q) = %q, want an encrypted value", value, encrypted)
+		}
+		decrypted, err := keyring.Decrypt(encrypted)
+		if err != nil {
+			t.Fatalf("Decrypt(%q): %v", encrypted, err)
+		}
+		if decrypted != value {
+			t.Errorf("Decrypt(Encrypt(%q)) = %q", value, decrypted)
+		}
+	}
+
+	a, _ := keyring.Encrypt("secret")
+	b, _ := keyring.Encrypt("secret")
+	if a == b {
+		t.Errorf("Encrypt returned the same value twice, want a new data key each time")
+	}
+}
+
+func TestPassthrough(t *testing.T) {
+	keyring, _ := newTestKeyring(t)
+
+	// Empty and already encrypted values are left as is.
+	encrypted, _ := keyring.Encrypt("secret")
+	for _, value := range []string{"", encrypted} {
+		got, err := keyring.Encrypt(value)
+		if err != nil || got != value {
+			t.Errorf("Encrypt(%q) = %q, %v, want it as is", value, got, err)
+		}
+	}
+
+	// Plain text values are decrypted as is, e.g. secrets saved before
+	// encryption was enabled.
+	got, err := keyring.Decrypt("plain")
+	if err != nil || got != "plain" {
+		t.Errorf("Decrypt(plain) = %q, %v, want it as is", got, err)
+	}
+
+	// A nil keyring leaves secrets in plain text.
+	var none *Keyring
+	got, err = none.Encrypt("plain")
+	if err != nil || got != "plain" {
+		t.Errorf("nil Encrypt(plain) = %q, %v, want it as is", got, err)
+	}
+	if _, err := none.Decrypt(encrypted); !errors.Is(err, ErrNoKey) {
+		t.Errorf("nil Decrypt(encrypted) error = %v, want %v", err, ErrNoKey)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	oldKeyring, oldKey := newTestKeyring(t)
+	encrypted, err := oldKeyring.Encrypt("secret")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	keyring, newKey := newTestKeyring(t, oldKey)
+	rotated, err := keyring.Rotate(encrypted)
+	if err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if rotated == encrypted {
+		t.Fatalf("Rotate returned the value as is, want it re-encrypted")
+	}
+	// Only the data key is re-encrypted.
+	sealed := func(v string) string {
+		return v[strings.LastIndex(v, ":")+1:]
+	}
+	if sealed(rotated) != sealed(encrypted) {
+		t.Errorf("Rotate re-encrypted the value, want only the data key re-encrypted")
+	}
+
+	// The rotated secret no longer needs the old key.
+	newOnly, err := NewKeyring(newKey)
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	if got, err := newOnly.Decrypt(rotated); err != nil || got != "secret" {
+		t.Errorf("Decrypt(rotated) = %q, %v, want %q", got, err, "secret")
+	}
+
+	// Rotating again leaves the secret as is, and plain text is encrypted.
+	if again, err := keyring.Rotate(rotated); err != nil || again != rotated {
+		t.Errorf("Rotate(rotated) = %q, %v, want it as is", again, err)
+	}
+	plain, err := keyring.Rotate("plain")
+	if err != nil || !IsEncrypted(plain) {
+		t.Errorf("Rotate(plain) = %q, %v, want it encrypted", plain, err)
+	}
+}
+
+func TestUnknownKey(t *testing.T) {
+	other, _ := newTestKeyring(t)
+	encrypted, _ := other.Encrypt("secret")
+
+	keyring, _ := newTestKeyring(t)
+	if _, err := keyring.Decrypt(encrypted); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Decrypt error = %v, want %v", err, ErrUnknownKey)
+	}
+	if _, err := keyring.Rotate(encrypted); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Rotate error = %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestTamper(t *testing.T) {
+	keyring, _ := newTestKeyring(t)
+	encrypted, _ := keyring.Encrypt("secret")
+	parts := strings.Split(strings.TrimPrefix(encrypted, prefix), ":")
+
+	flip := func(encoded string) string {
+		raw, err := base64.RawStdEncoding.DecodeString(encoded)
+		if err != nil {
+			t.Fatalf("decoding %q: %v", encoded, err)
+		}
+		raw[len(raw)-1] ^= 1
+		return base64.RawStdEncoding.EncodeToString(raw)
+	}
+	tests := map[string]string{
+		"data key":      prefix + strings.Join([]string{parts[0], flip(parts[1]), parts[2]}, ":"),
+		"value":         prefix + strings.Join([]string{parts[0], parts[1], flip(parts[2])}, ":"),
+		"swapped parts": prefix + strings.Join([]string{parts[0], parts[2], parts[1]}, ":"),
+		"missing part":  prefix + strings.Join(parts[:2], ":"),
+		"bad base64":    prefix + strings.Join([]string{parts[0], "!", parts[2]}, ":"),
+		"truncated":     prefix + strings.Join([]string{parts[0], parts[1], "AA"}, ":"),
+	}
+	for name, value := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := keyring.Decrypt(value); !errors.Is(err, ErrInvalidData) {
+				t.Errorf("Decrypt error = %v, want %v", err, ErrInvalidData)
+			}
+		})
+	}
+}
+
+func TestInvalidKey(t *testing.T) {
+	for _, key := range []string{"", "not base64!", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, err := NewKeyring(key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("NewKeyring(%q) error = %v, want %v", key, err, ErrInvalidKey)
+		}
+	}
+}
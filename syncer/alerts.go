@@ -0,0 +1,356 @@
+package syncer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/mail"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/nmcapule/oclz-go/integrations/models"
+	"github.com/pocketbase/dbx"
+	pbmodels "github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/mailer"
+	"github.com/pocketbase/pocketbase/tools/types"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// AlertKind is the kind of a stock alert.
+type AlertKind string
+
+const (
+	// AlertLowStock is raised when the intent stocks are below the threshold.
+	AlertLowStock AlertKind = "LOW_STOCK"
+	// AlertOutOfStock is raised when the intent stocks are zero.
+	AlertOutOfStock AlertKind = "OUT_OF_STOCK"
+	// AlertDiverged is raised when a tenant fails to follow the intent for
+	// too many syncs.
+	AlertDiverged AlertKind = "DIVERGED"
+	// AlertRefreshFailed is raised when the credentials of a tenant can't be
+	// refreshed.
+	AlertRefreshFailed AlertKind = "REFRESH_FAILED"
+	// AlertReauthRequired is raised when the refresh token of a tenant is
+	// about to expire, and the tenant has to be authorized again.
+	AlertReauthRequired AlertKind = "REAUTH_REQUIRED"
+)
+
+const defaultDivergedCycles = 3
+
+// webhookClient posts the alerts to the webhook. The timeout keeps a slow
+// webhook from piling up deliveries.
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// Alert is a stock alert.
+type Alert struct {
+	ID          string    `json:"id"`
+	TenantGroup string    `json:"tenant_group"`
+	Kind        AlertKind `json:"kind"`
+	SellerSKU   string    `json:"seller_sku,omitempty"`
+	// Tenant is the name of the alerted tenant, if any.
+	Tenant  string `json:"tenant,omitempty"`
+	Stocks  int    `json:"stocks"`
+	Message string `json:"message"`
+	// Link is where the alert can be acted upon, if any.
+	Link    string    `json:"link,omitempty"`
+	Created time.Time `json:"created"`
+
+	tenantID string
+	// seeded alerts were already due before the sync, e.g. for items that
+	// were out of stock before alerts were enabled. They are recorded as
+	// delivered without being sent.
+	seeded bool
+}
+
+// checkStockAlerts raises or resolves the stock alerts of the intent stocks,
+// given the intent stocks before and after the sync.
+func (s *Syncer) checkStockAlerts(ctx context.Context, sellerSKU string, previous, stocks int) {
+	if stocks <= 0 {
+		s.raiseAlert(ctx, &Alert{
+			Kind:      AlertOutOfStock,
+			SellerSKU: sellerSKU,
+			Stocks:    stocks,
+			Message:   fmt.Sprintf("%s is out of stock", sellerSKU),
+			seeded:    previous <= 0,
+		})
+	} else {
+		s.resolveAlert(ctx, AlertOutOfStock, sellerSKU, "")
+	}
+
+	thresholds, err := s.stockThresholds(ctx)
+	if err != nil {
+		s.logger(ctx).WithFields(log.Fields{
+			"seller_sku": sellerSKU,
+		}).Errorf("Failed to load stock thresholds: %v", err)
+		return
+	}
+	threshold, ok := thresholds.match(sellerSKU)
+	if ok && stocks > 0 && stocks < threshold {
+		s.raiseAlert(ctx, &Alert{
+			Kind:      AlertLowStock,
+			SellerSKU: sellerSKU,
+			Stocks:    stocks,
+			Message:   fmt.Sprintf("%s is low on stock: %d left, threshold is %d", sellerSKU, stocks, threshold),
+			seeded:    previous > 0 && previous < threshold,
+		})
+	} else {
+		s.resolveAlert(ctx, AlertLowStock, sellerSKU, "")
+	}
+}
+
+// checkDivergence counts the consecutive syncs that the tenant failed to
+// follow the intent, and raises an alert once there are too many.
+func (s *Syncer) checkDivergence(ctx context.Context, tenant models.IntegrationClient, sellerSKU string, diverged bool) {
+	key := tenant.Tenant().ID + "/" + sellerSKU
+
+	s.alertsMu.Lock()
+	if !diverged {
+		delete(s.divergence, key)
+	} else {
+		s.divergence[key]++
+	}
+	cycles := s.divergence[key]
+	s.alertsMu.Unlock()
+
+	if !diverged {
+		s.resolveAlert(ctx, AlertDiverged, sellerSKU, tenant.Tenant().ID)
+		return
+	}
+	limit := s.Config().Alerts.DivergedCycles
+	if limit <= 0 {
+		limit = defaultDivergedCycles
+	}
+	if cycles < limit {
+		return
+	}
+	s.raiseAlert(ctx, &Alert{
+		Kind:      AlertDiverged,
+		SellerSKU: sellerSKU,
+		Tenant:    tenant.Tenant().Name,
+		Message:   fmt.Sprintf("%s on %s has not followed the intent for %d syncs", sellerSKU, tenant.Tenant().Name, cycles),
+		tenantID:  tenant.Tenant().ID,
+	})
+}
+
+// stockThresholds are the stock thresholds of a tenant group, keyed by seller
+// SKU or pattern.
+type stockThresholds map[string]int
+
+// thresholdsKey is the context key of the thresholds loaded for a sweep.
+type thresholdsKey struct{}
+
+// withStockThresholds returns a context that reuses the thresholds, so that
+// they are loaded once per sweep instead of once per item.
+func withStockThresholds(ctx context.Context, thresholds stockThresholds) context.Context {
+	return context.WithValue(ctx, thresholdsKey{}, thresholds)
+}
+
+// stockThresholds returns the thresholds of the sweep of the context, or else
+// loads them.
+func (s *Syncer) stockThresholds(ctx context.Context) (stockThresholds, error) {
+	if thresholds, ok := ctx.Value(thresholdsKey{}).(stockThresholds); ok {
+		return thresholds, nil
+	}
+	return s.loadStockThresholds()
+}
+
+func (s *Syncer) loadStockThresholds() (stockThresholds, error) {
+	records, err := s.Dao.FindRecordsByExpr("stock_thresholds", dbx.HashExp{
+		"tenant_group": s.groupID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	thresholds := make(stockThresholds)
+	for _, record := range records {
+		thresholds[record.GetString("seller_sku")] = record.GetInt("threshold")
+	}
+	return thresholds, nil
+}
+
+// match returns the threshold of the seller SKU. Thresholds of the exact
+// seller SKU come first. Otherwise, the longest matching pattern is used,
+// e.g. "CLR-*" for a whole category of seller SKUs.
+func (t stockThresholds) match(sellerSKU string) (int, bool) {
+	if threshold, ok := t[sellerSKU]; ok {
+		return threshold, true
+	}
+	var pattern string
+	var threshold int
+	var found bool
+	for p, n := range t {
+		if ok, _ := path.Match(p, sellerSKU); ok && len(p) > len(pattern) {
+			pattern, threshold, found = p, n, true
+		}
+	}
+	return threshold, found
+}
+
+// raiseAlert saves and delivers the alert, unless the same alert is already
+// raised and not yet resolved.
+func (s *Syncer) raiseAlert(ctx context.Context, alert *Alert) {
+	logger := s.logger(ctx).WithFields(log.Fields{
+		"seller_sku": alert.SellerSKU,
+		"tenant":     alert.Tenant,
+		"kind":       alert.Kind,
+	})
+	existing, err := s.unresolvedAlerts(alert.Kind, alert.SellerSKU, alert.tenantID)
+	if err != nil {
+		logger.Errorf("Failed to load stock alerts: %v", err)
+		return
+	}
+	if len(existing) > 0 {
+		return
+	}
+
+	collection, err := s.Dao.FindCollectionByNameOrId("stock_alerts")
+	if err != nil {
+		logger.Errorf("Failed to load stock alerts: %v", err)
+		return
+	}
+	record := pbmodels.NewRecord(collection)
+	record.Set("tenant_group", s.groupID)
+	record.Set("kind", string(alert.Kind))
+	record.Set("seller_sku", alert.SellerSKU)
+	record.Set("tenant", alert.tenantID)
+	record.Set("stocks", alert.Stocks)
+	record.Set("message", alert.Message)
+	record.Set("delivered", alert.seeded)
+	if err := s.Dao.SaveRecord(record); err != nil {
+		logger.Errorf("Failed to save stock alert: %v", err)
+		return
+	}
+	if alert.seeded {
+		logger.Infof("Recorded alert without delivering, already due before the sync: %s", alert.Message)
+		return
+	}
+	logger.Warnln(alert.Message)
+
+	alert.ID = record.GetId()
+	alert.TenantGroup = s.TenantGroupName
+	alert.Created = record.Created.Time()
+	// Deliver in the background, so that slow mailers and webhooks don't hold
+	// up the sync.
+	go func() {
+		if err := s.deliverAlert(alert); err != nil {
+			logger.Errorf("Failed to deliver stock alert: %v", err)
+			return
+		}
+		if err := s.setAlertFlag(alert.ID, "delivered"); err != nil {
+			logger.Errorf("Failed to save stock alert: %v", err)
+		}
+	}()
+}
+
+// resolveAlert resolves the raised alerts of the same kind, if any.
+func (s *Syncer) resolveAlert(ctx context.Context, kind AlertKind, sellerSKU, tenantID string) {
+	records, err := s.unresolvedAlerts(kind, sellerSKU, tenantID)
+	if err != nil {
+		s.logger(ctx).WithFields(log.Fields{
+			"seller_sku": sellerSKU,
+			"kind":       kind,
+		}).Errorf("Failed to load stock alerts: %v", err)
+		return
+	}
+	for _, record := range records {
+		if err := s.setAlertFlag(record.Id, "resolved"); err != nil {
+			s.logger(ctx).WithFields(log.Fields{
+				"seller_sku": sellerSKU,
+				"kind":       kind,
+			}).Errorf("Failed to resolve stock alert: %v", err)
+		}
+	}
+}
+
+// setAlertFlag sets the flag column of the alert, without saving the rest of
+// the record, so that an alert resolved while it is being delivered, or the
+// other way around, keeps both flags.
+func (s *Syncer) setAlertFlag(id, flag string) error {
+	_, err := s.Dao.DB().Update("stock_alerts", dbx.Params{
+		flag:      true,
+		"updated": types.NowDateTime(),
+	}, dbx.HashExp{"id": id}).Execute()
+	return err
+}
+
+func (s *Syncer) unresolvedAlerts(kind AlertKind, sellerSKU, tenantID string) ([]*pbmodels.Record, error) {
+	return s.Dao.FindRecordsByExpr("stock_alerts", dbx.HashExp{
+		"tenant_group": s.groupID,
+		"kind":         string(kind),
+		"seller_sku":   sellerSKU,
+		"tenant":       tenantID,
+		"resolved":     false,
+	})
+}
+
+// deliverAlert sends the alert to the configured emails and webhook.
+func (s *Syncer) deliverAlert(alert *Alert) error {
+	config := s.Config().Alerts
+	var errs []string
+	if len(config.Emails) > 0 {
+		var to []mail.Address
+		for _, email := range config.Emails {
+			to = append(to, mail.Address{Address: email})
+		}
+		meta := s.App.Settings().Meta
+		err := s.App.NewMailClient().Send(&mailer.Message{
+			From:    mail.Address{Name: meta.SenderName, Address: meta.SenderAddress},
+			To:      to,
+			Subject: alertSubject(s.TenantGroupName, alert),
+			HTML:    alertHTML(alert),
+			Text:    strings.TrimSpace(alert.Message + "\n" + alert.Link),
+		})
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("email: %v", err))
+		}
+	}
+	if config.WebhookURL != "" {
+		if err := postWebhook(config.WebhookURL, alert); err != nil {
+			errs = append(errs, fmt.Sprintf("webhook: %v", err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func alertSubject(group string, alert *Alert) string {
+	subject := fmt.Sprintf("[%s] %s", group, alert.Kind)
+	if alert.SellerSKU != "" {
+		subject += ": " + alert.SellerSKU
+	}
+	if alert.Tenant != "" {
+		subject += " (" + alert.Tenant + ")"
+	}
+	return subject
+}
+
+func alertHTML(alert *Alert) string {
+	body := fmt.Sprintf("<p>%s</p>", html.EscapeString(alert.Message))
+	if alert.Link != "" {
+		body += fmt.Sprintf(`<p><a href="%s">%s</a></p>`, html.EscapeString(alert.Link), html.EscapeString(alert.Link))
+	}
+	return body
+}
+
+func postWebhook(url string, alert *Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	res, err := webhookClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+	return nil
+}
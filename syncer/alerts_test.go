@@ -0,0 +1,33 @@
+package syncer
+
+import "testing"
+
+func TestStockThresholdsMatch(t *testing.T) {
+	thresholds := stockThresholds{
+		"CLR-*":     5,
+		"CLR-RED-*": 10,
+		"CLR-RED-1": 2,
+		"BLK":       3,
+	}
+
+	tests := []struct {
+		sellerSKU string
+		want      int
+		wantOK    bool
+	}{
+		{sellerSKU: "CLR-RED-1", want: 2, wantOK: true},
+		{sellerSKU: "CLR-RED-2", want: 10, wantOK: true},
+		{sellerSKU: "CLR-BLU-1", want: 5, wantOK: true},
+		{sellerSKU: "BLK", want: 3, wantOK: true},
+		{sellerSKU: "BLK-1", wantOK: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.sellerSKU, func(t *testing.T) {
+			got, ok := thresholds.match(tc.sellerSKU)
+			if got != tc.want || ok != tc.wantOK {
+				t.Errorf("match(%q) = %d, %t, want %d, %t", tc.sellerSKU, got, ok, tc.want, tc.wantOK)
+			}
+		})
+	}
+}
@@ -1,9 +1,11 @@
 package syncer
 
 import (
+	"context"
 	"fmt"
 	"time"
 
+	"github.com/nmcapule/oclz-go/integrations/intent"
 	"github.com/nmcapule/oclz-go/integrations/models"
 
 	log "github.com/sirupsen/logrus"
@@ -11,68 +13,140 @@ import (
 
 // CollectAllItems collects and saves fresh item details from each of the
 // registered tenants for the syncer.
-func (s *Syncer) CollectAllItems() error {
-	intentItems, err := s.IntentTenant.CollectAllItems()
+func (s *Syncer) CollectAllItems(ctx context.Context) error {
+	intentTenant := s.IntentTenant()
+	if intentTenant == nil {
+		return ErrNoIntentTenant
+	}
+	intentItems, err := intentTenant.CollectAllItems(ctx)
 	if err != nil {
 		return fmt.Errorf("collect all intent items: %v", err)
 	}
+	intentItems, err = s.withoutExcluded(intentTenant, intentItems)
+	if err != nil {
+		return err
+	}
+	s.count(ctx, "items_collected", len(intentItems))
 	intentItemsLookup := make(map[string]struct{})
 	for _, item := range intentItems {
 		intentItemsLookup[item.SellerSKU] = struct{}{}
 	}
 
+	// A master tenant of a real vendor has a live inventory that needs to be
+	// recorded, same as the other tenants.
+	isMaster := intentTenant.Tenant().Vendor != intent.Vendor
+	if isMaster {
+		if err := s.recordTenantInventory(ctx, intentTenant, intentItems); err != nil {
+			return err
+		}
+	}
+
 	// Collect all items that are not intent items.
 	itemsOutsideIntent := make(map[string]*models.Item)
 	for _, tenant := range s.nonIntentTenants() {
-		log.WithFields(log.Fields{
+		if s.isDraining() {
+			return ErrDraining
+		}
+		s.logger(ctx).WithFields(log.Fields{
 			"tenant": tenant.Tenant().Name,
 		}).Infoln("Starting live items collection...")
 		start := time.Now()
-		items, err := tenant.CollectAllItems()
+		items, err := tenant.CollectAllItems(ctx)
 		if err != nil {
 			return fmt.Errorf("collect tenant items for %q: %v", tenant.Tenant().Name, err)
 		}
 		elapsed := time.Since(start)
-		log.WithFields(log.Fields{
+		s.logger(ctx).WithFields(log.Fields{
 			"tenant":  tenant.Tenant().Name,
 			"elapsed": elapsed,
 		}).Infof("Finished live items collection after %s.", elapsed.String())
 
+		items, err = s.withoutExcluded(tenant, items)
+		if err != nil {
+			return err
+		}
+		s.count(ctx, "items_collected", len(items))
+
+		if err := s.recordTenantInventory(ctx, tenant, items); err != nil {
+			return err
+		}
+		// Only tenants that are synced from can add new items to the intent.
+		if !tenant.Tenant().CanPull() {
+			continue
+		}
 		for _, item := range items {
-			item := item
-			_, err := s.tenantInventory(tenant.Tenant().Name, item.SellerSKU)
-			// If not found, means that this is the first time we detected
-			// the item on this tenant.
-			if err == models.ErrNotFound {
-				log.WithFields(log.Fields{
-					"tenant":     tenant.Tenant().Name,
-					"seller_sku": item.SellerSKU,
-				}).Infof("Recording tenant inventory for the first time")
-				// Save fresh copy to the tenant inventory.
-				err = s.saveTenantInventory(tenant.Tenant().Name, item)
-				if err != nil {
-					return fmt.Errorf("save fresh item: %v", err)
-				}
-			} else if err != nil {
-				return fmt.Errorf("retrieving cached item for %s: %v", item.SellerSKU, err)
-			}
 			if _, ok := intentItemsLookup[item.SellerSKU]; !ok {
 				itemsOutsideIntent[item.SellerSKU] = item
 			}
 		}
 	}
 
-	// Save all new items that are not in the intent into the intent.
+	// Save all new items that are not in the intent into the intent. Items
+	// can't be created in a master tenant, so they are only reported.
 	for _, item := range itemsOutsideIntent {
-		log.WithFields(log.Fields{
-			"tenant":     s.IntentTenant.Tenant().Name,
+		if isMaster {
+			s.logger(ctx).WithFields(log.Fields{
+				"tenant":     intentTenant.Tenant().Name,
+				"seller_sku": item.SellerSKU,
+			}).Warnln("Item does not exist in the master tenant")
+			s.count(ctx, "items_missing", 1)
+			continue
+		}
+		s.logger(ctx).WithFields(log.Fields{
+			"tenant":     intentTenant.Tenant().Name,
 			"seller_sku": item.SellerSKU,
 		}).Infof("Recording intent tenant inventory")
-		err := s.saveTenantInventory(s.IntentTenant.Tenant().Name, item)
+		err := s.saveTenantInventory(ctx, intentTenant, item)
 		if err != nil {
 			return fmt.Errorf("save tenant items: %v", err)
 		}
+		s.count(ctx, "items_added", 1)
 	}
 
 	return nil
 }
+
+// recordTenantInventory saves the items that are seen on the tenant for the
+// first time to the tenant inventory.
+func (s *Syncer) recordTenantInventory(ctx context.Context, tenant models.IntegrationClient, items []*models.Item) error {
+	for _, item := range items {
+		item := item
+		_, err := s.tenantInventory(tenant, item.SellerSKU)
+		// If not found, means that this is the first time we detected
+		// the item on this tenant.
+		if err == models.ErrNotFound {
+			s.logger(ctx).WithFields(log.Fields{
+				"tenant":     tenant.Tenant().Name,
+				"seller_sku": item.SellerSKU,
+			}).Infof("Recording tenant inventory for the first time")
+			// Save fresh copy to the tenant inventory.
+			err = s.saveTenantInventory(ctx, tenant, item)
+			if err != nil {
+				return fmt.Errorf("save fresh item: %v", err)
+			}
+			s.count(ctx, "items_recorded", 1)
+		} else if err != nil {
+			return fmt.Errorf("retrieving cached item for %s: %v", item.SellerSKU, err)
+		}
+	}
+	return nil
+}
+
+// withoutExcluded filters out the items that are excluded from sync on the
+// tenant by policy.
+func (s *Syncer) withoutExcluded(tenant models.IntegrationClient, items []*models.Item) ([]*models.Item, error) {
+	excluded, err := s.excludedSKUs(tenant.Tenant().ID)
+	if err != nil {
+		return nil, fmt.Errorf("loading excluded items of %q: %v", tenant.Tenant().Name, err)
+	}
+	if len(excluded) == 0 {
+		return items, nil
+	}
+	var filtered []*models.Item
+	for _, item := range items {
+		if !excluded[item.SellerSKU] {
+			filtered = append(filtered, item)
+		}
+	}
+	return filtered, nil
+}
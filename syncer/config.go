@@ -1,17 +1,153 @@
 package syncer
 
 import (
-	"github.com/nmcapule/oclz-go/utils"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nmcapule/oclz-go/utils/jsonschema"
+	"github.com/nmcapule/oclz-go/utils/scheduler"
 	"github.com/pocketbase/pocketbase/models"
 )
 
 // Config contains configurable behavior flags for the syncer.
 type Config struct {
-	ContinueOnSyncItemError bool
+	ContinueOnSyncItemError bool `json:"continue_on_sync_item_error"`
+	// IntentTenant is the name of the tenant that is the source of truth of
+	// the stocks. It can be a real vendor, e.g. an OPENCART tenant. Defaults
+	// to the only DEFAULT vendor tenant of the group.
+	IntentTenant string `json:"intent_tenant"`
+	// RunTimeoutMinutes is the deadline of each run of a background job, after
+	// which its in-flight work is cancelled. Defaults to 60.
+	RunTimeoutMinutes int `json:"run_timeout_minutes"`
+	// Alerts configures how stock alerts are delivered.
+	Alerts AlertsConfig `json:"alerts"`
+	// Schedules configures when the background jobs run.
+	Schedules SchedulesConfig `json:"schedules"`
+}
+
+// AlertsConfig contains the delivery options of stock alerts.
+type AlertsConfig struct {
+	// Emails receive the alerts through the app mailer.
+	Emails []string `json:"emails"`
+	// WebhookURL receives the alerts as JSON POST requests.
+	WebhookURL string `json:"webhook_url"`
+	// DivergedCycles is the number of consecutive syncs that a tenant can fail
+	// to follow the intent before alerting. Defaults to 3.
+	DivergedCycles int `json:"diverged_cycles"`
+	// ReauthDays is the number of days before the refresh token of a tenant
+	// expires to start reminding to authorize it again. Defaults to 7.
+	ReauthDays int `json:"reauth_days"`
+}
+
+// SchedulesConfig contains the schedules of the background jobs. Schedules are
+// cron expressions, e.g. "0 3 * * *", or intervals, e.g. "@every 6h".
+type SchedulesConfig struct {
+	// Collect defaults to "@every 24h". Items are also collected on start.
+	Collect string `json:"collect"`
+	// Sync defaults to "@every 1h".
+	Sync string `json:"sync"`
+	// RefreshCredentials defaults to "@every 30m". Credentials are also
+	// refreshed on start, and even within the quiet hours.
+	RefreshCredentials string `json:"refresh_credentials"`
+	// JitterSeconds is the maximum random delay of each run.
+	JitterSeconds int `json:"jitter_seconds"`
+	// QuietHours is a daily window when items are not collected nor synced.
+	QuietHours QuietHoursConfig `json:"quiet_hours"`
+	// Timezone is the time zone of the schedules and the quiet hours, e.g.
+	// "Asia/Manila". Defaults to the local time zone.
+	Timezone string `json:"timezone"`
+}
+
+// QuietHoursConfig is a daily window from start to end, as "HH:MM". The window
+// wraps around midnight if it ends before it starts, e.g. "22:00" to "06:00".
+type QuietHoursConfig struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+var defaultSchedules = map[Job]string{
+	JobCollect:            "@every 24h",
+	JobSync:               "@every 1h",
+	JobRefreshCredentials: "@every 30m",
+}
+
+// Plans returns the run plan of each job.
+func (c SchedulesConfig) Plans() (map[Job]*scheduler.Plan, error) {
+	if c.JitterSeconds < 0 {
+		return nil, fmt.Errorf("jitter_seconds: can't be negative")
+	}
+	location := time.Local
+	if c.Timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(c.Timezone); err != nil {
+			return nil, fmt.Errorf("timezone: %v", err)
+		}
+	}
+	var quiet *scheduler.QuietHours
+	if c.QuietHours.Start != "" || c.QuietHours.End != "" {
+		var err error
+		if quiet, err = scheduler.ParseQuietHours(c.QuietHours.Start, c.QuietHours.End); err != nil {
+			return nil, fmt.Errorf("quiet_hours: %v", err)
+		}
+	}
+
+	exprs := map[Job]string{
+		JobCollect:            c.Collect,
+		JobSync:               c.Sync,
+		JobRefreshCredentials: c.RefreshCredentials,
+	}
+	plans := make(map[Job]*scheduler.Plan)
+	for _, job := range Jobs {
+		expr := exprs[job]
+		if expr == "" {
+			expr = defaultSchedules[job]
+		}
+		schedule, err := scheduler.ParseSchedule(expr)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", job, err)
+		}
+		plan := &scheduler.Plan{
+			Schedule: schedule,
+			Jitter:   time.Duration(c.JitterSeconds) * time.Second,
+			Quiet:    quiet,
+			Location: location,
+		}
+		// Expired credentials would fail the first sync after the quiet hours.
+		if job == JobRefreshCredentials {
+			plan.Quiet = nil
+		}
+		plans[job] = plan
+	}
+	return plans, nil
+}
+
+// ConfigSchema is the JSON schema of the tenant group config.
+var ConfigSchema = jsonschema.Reflect(&Config{})
+
+// ConfigFrom decodes the config of the tenant group record.
+func ConfigFrom(group *models.Record) (Config, error) {
+	var config Config
+	if raw := group.GetString("config"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &config); err != nil {
+			return config, err
+		}
+	}
+	return config, nil
 }
 
 func (s *Syncer) loadConfigFromGroup(group *models.Record) error {
-	data := utils.GJSONFrom(group.Get("config"))
-	s.Config.ContinueOnSyncItemError = data.Get("continue_on_sync_item_error").Bool()
+	config, err := ConfigFrom(group)
+	if err != nil {
+		return err
+	}
+	plans, err := config.Schedules.Plans()
+	if err != nil {
+		return fmt.Errorf("schedules: %v", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config = config
+	s.plans = plans
 	return nil
 }
@@ -0,0 +1,149 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nmcapule/oclz-go/integrations/models"
+	"github.com/nmcapule/oclz-go/oauth2"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultReauthDays = 7
+
+// RefreshCredentials refreshes the credentials of the tenants that are about
+// to expire. A failing tenant does not stop the others from being refreshed,
+// and raises an alert instead. Tenants whose refresh token is about to expire
+// are reminded to be authorized again.
+func (s *Syncer) RefreshCredentials(ctx context.Context) error {
+	var failed []string
+	for _, tenant := range s.Tenants() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if s.isDraining() {
+			return ErrDraining
+		}
+		cm := tenant.CredentialsManager()
+		if cm == nil {
+			s.logger(ctx).WithFields(log.Fields{
+				"tenant": tenant.Tenant().Name,
+			}).Debugln("Skip credentials refresh, no credentials manager")
+			continue
+		}
+
+		if err := s.refreshTenantCredentials(ctx, tenant, cm); err != nil {
+			s.logger(ctx).WithFields(log.Fields{
+				"tenant": tenant.Tenant().Name,
+			}).Errorf("Failed to refresh credentials: %v", err)
+			failed = append(failed, tenant.Tenant().Name)
+			s.count(ctx, "tenants_failed", 1)
+			s.raiseAlert(ctx, &Alert{
+				Kind:     AlertRefreshFailed,
+				Tenant:   tenant.Tenant().Name,
+				Message:  fmt.Sprintf("Failed to refresh the credentials of %s: %v", tenant.Tenant().Name, err),
+				Link:     s.reauthURL(tenant),
+				tenantID: tenant.Tenant().ID,
+			})
+		} else {
+			s.resolveAlert(ctx, AlertRefreshFailed, "", tenant.Tenant().ID)
+		}
+		s.checkReauth(ctx, tenant)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("refreshing credentials failed for %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func (s *Syncer) refreshTenantCredentials(ctx context.Context, tenant models.IntegrationClient, cm oauth2.CredentialsManager) error {
+	const expiryThreshold = 6 * time.Hour
+
+	// Only refresh credentials if credentials is about to expire.
+	if cm.CredentialsExpiry().Sub(time.Now()) >= expiryThreshold {
+		s.logger(ctx).WithFields(log.Fields{
+			"tenant": tenant.Tenant().Name,
+		}).Debugln("Skip credentials refresh, not yet near expiry")
+		return nil
+	}
+
+	s.logger(ctx).WithFields(log.Fields{
+		"tenant": tenant.Tenant().Name,
+	}).Debugln("Refreshing credentials")
+	// Shares the refresh with requests that failed on an invalid token.
+	oauth2Service := &oauth2.Service{Dao: s.Dao}
+	if _, err := oauth2Service.Refresh(ctx, tenant.Tenant().ID, cm, nil); err != nil {
+		return err
+	}
+	s.logger(ctx).WithFields(log.Fields{
+		"tenant": tenant.Tenant().Name,
+	}).Infoln("Refreshed credentials")
+	s.count(ctx, "tenants_refreshed", 1)
+	return nil
+}
+
+// PromoteCredentials saves the credentials as the current credentials of the
+// tenant, if they pass a probe request. Otherwise, the credentials are only
+// kept in the credentials history, and the current credentials are kept.
+func (s *Syncer) PromoteCredentials(ctx context.Context, tenant models.IntegrationClient, credentials *oauth2.Credentials) error {
+	oauth2Service := &oauth2.Service{Dao: s.Dao}
+	if err := oauth2Service.Promote(ctx, tenant.CredentialsManager(), credentials); err != nil {
+		return err
+	}
+	s.logger(ctx).WithFields(log.Fields{
+		"tenant": tenant.Tenant().Name,
+		"source": credentials.Source,
+	}).Infoln("Promoted new credentials")
+	return nil
+}
+
+// checkReauth raises an alert if the refresh token of the tenant is about to
+// expire, or resolves it once the tenant is authorized again.
+func (s *Syncer) checkReauth(ctx context.Context, tenant models.IntegrationClient) {
+	oauth2Service := &oauth2.Service{Dao: s.Dao}
+	credentials, err := oauth2Service.Load(tenant.Tenant().ID)
+	if err != nil || credentials.RefreshExpires.IsZero() {
+		return
+	}
+
+	days := s.Config().Alerts.ReauthDays
+	if days <= 0 {
+		days = defaultReauthDays
+	}
+	left := time.Until(credentials.RefreshExpires)
+	if left >= time.Duration(days)*24*time.Hour {
+		s.resolveAlert(ctx, AlertReauthRequired, "", tenant.Tenant().ID)
+		return
+	}
+	message := fmt.Sprintf("The authorization of %s expires on %s. Authorize it again before then.",
+		tenant.Tenant().Name,
+		credentials.RefreshExpires.Format(time.RFC1123))
+	if left <= 0 {
+		message = fmt.Sprintf("The authorization of %s has expired. Authorize it again to resume syncing.", tenant.Tenant().Name)
+	}
+	s.raiseAlert(ctx, &Alert{
+		Kind:     AlertReauthRequired,
+		Tenant:   tenant.Tenant().Name,
+		Message:  message,
+		Link:     s.reauthURL(tenant),
+		tenantID: tenant.Tenant().ID,
+	})
+}
+
+// reauthURL returns the link to authorize the tenant again, or an empty
+// string if the application URL is not set, since a relative link is of no
+// use in alerts.
+func (s *Syncer) reauthURL(tenant models.IntegrationClient) string {
+	appURL := strings.TrimSuffix(s.App.Settings().Meta.AppUrl, "/")
+	if appURL == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/authentication/%s/reauth/%s",
+		appURL,
+		url.PathEscape(s.TenantGroupName),
+		url.PathEscape(tenant.Tenant().Name))
+}
@@ -1,71 +1,81 @@
 package syncer
 
 import (
-	"time"
+	"context"
+	"errors"
 
-	"github.com/nmcapule/oclz-go/integrations/models"
 	"github.com/nmcapule/oclz-go/utils/scheduler"
-
-	log "github.com/sirupsen/logrus"
 )
 
-// Start starts the syncer's background service.
-func (s *Syncer) Start() error {
-	for i := range s.Tenants {
-		job := s.Tenants[i].Daemon()
-		if job == nil {
-			continue
-		}
+// Start starts the syncer's background service, which runs until the context
+// is done. Cancelling the context also stops the runs in progress, which are
+// waited for before returning. See Drain to stop gracefully. The jobs follow
+// the schedules of the tenant group config, which are reloaded at runtime.
+func (s *Syncer) Start(ctx context.Context) error {
+	s.mu.Lock()
+	s.running = true
+	s.ctx = ctx
+	for _, tenant := range s.tenants {
+		s.startDaemon(tenant)
+	}
+	s.mu.Unlock()
+	defer s.runsWG.Wait()
+
+	if err := s.failStaleRuns(); err != nil {
+		s.Logger.Errorf("Failed to mark stale job runs as failed: %v", err)
+	}
+	if err := s.seedLastRuns(); err != nil {
+		s.Logger.Errorf("Failed to load the last job runs: %v", err)
+	}
+	if s.App.Settings().Meta.AppUrl == "" {
+		s.Logger.Warnln("Application URL is not set, so alerts have no links to authorize tenants again")
+	}
 
-		go func(tenant models.IntegrationClient) {
-			log.WithFields(log.Fields{
-				"tenant": tenant.Tenant().Name,
-			}).Infoln("Background job has started")
-			if err := job.Start(); err != nil {
-				log.WithFields(log.Fields{
-					"tenant": tenant.Tenant().Name,
-				}).Fatalf("Background job has unexpectedly halted: %v", err)
-			}
-			log.WithFields(log.Fields{
-				"tenant": tenant.Tenant().Name,
-			}).Infoln("Background job has finished")
-		}(s.Tenants[i])
+	// Resume before the scheduled jobs start, so that the resumed syncs don't
+	// race the collect on start.
+	if s.IntentTenant() != nil {
+		s.logRun("Resume interrupted syncs", s.runJob(ctx, JobSync, s.ResumeInterrupted))
 	}
 
-	go scheduler.Loop(func(quit chan struct{}) {
-		log.Infoln("Start collecting inventory from all tenants...")
-		if s.IntentTenant == nil {
-			log.Warnf("Skipping item collection. No active intent tenant.")
+	go scheduler.Run(ctx, func() *scheduler.Plan {
+		return s.plan(JobCollect)
+	}, func(ctx context.Context) {
+		s.Logger.Infoln("Start collecting inventory from all tenants...")
+		if s.IntentTenant() == nil {
+			s.Logger.Warnf("Skipping item collection. No active intent tenant.")
 			return
 		}
-		if err := s.CollectAllItems(); err != nil {
-			log.Errorf("Collect all live tenant items: %v", err)
-		}
-	}, scheduler.LoopConfig{RetryWait: 24 * time.Hour})
+		s.logRun("Collect all live tenant items", s.runJob(ctx, JobCollect, s.CollectAllItems))
+	}, scheduler.RunConfig{RunOnStart: true, OnNext: s.planned(JobCollect)})
 
-	go scheduler.Loop(func(quit chan struct{}) {
-		log.Infoln("Refreshing oauth2 credentials of all tenants...")
-		if err := s.RefreshCredentials(); err != nil {
-			log.Errorf("Refreshing all tenants credentials: %v", err)
-		}
-	}, scheduler.LoopConfig{RetryWait: 30 * time.Minute})
+	go scheduler.Run(ctx, func() *scheduler.Plan {
+		return s.plan(JobRefreshCredentials)
+	}, func(ctx context.Context) {
+		s.Logger.Infoln("Refreshing oauth2 credentials of all tenants...")
+		s.logRun("Refreshing all tenants credentials", s.runJob(ctx, JobRefreshCredentials, s.RefreshCredentials))
+	}, scheduler.RunConfig{RunOnStart: true, OnNext: s.planned(JobRefreshCredentials)})
 
-	return scheduler.Loop(func(quit chan struct{}) {
-		log.Info("Sync inventory...")
-		items, err := s.IntentTenant.CollectAllItems()
-		if err != nil {
-			log.Fatalf("Collect all intent items: %v", err)
-		}
-		for i, item := range items {
-			log.WithFields(log.Fields{
-				"seller_sku": item.SellerSKU,
-				"index":      i,
-				"total":      len(items),
-			}).Debugln("Syncing item")
-			err := s.SyncItem(item.SellerSKU)
-			if err != nil {
-				log.Fatalf("Syncing %q: %v", item.SellerSKU, err)
-			}
+	return scheduler.Run(ctx, func() *scheduler.Plan {
+		return s.plan(JobSync)
+	}, func(ctx context.Context) {
+		s.Logger.Info("Sync inventory...")
+		if s.IntentTenant() == nil {
+			s.Logger.Warnf("Skipping inventory sync. No active intent tenant.")
+			return
 		}
-	}, scheduler.LoopConfig{InitialWait: 1 * time.Hour, RetryWait: 1 * time.Hour})
+		s.logRun("Sync inventory", s.runJob(ctx, JobSync, s.SyncAllItems))
+	}, scheduler.RunConfig{OnNext: s.planned(JobSync)})
+}
+
+// logRun logs the error of a run, if any. Runs stopped by a shutdown or a
+// cancellation, or skipped since the job is already running, are only warned
+// about.
+func (s *Syncer) logRun(name string, err error) {
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrDraining), errors.Is(err, ErrAlreadyRunning), errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		s.Logger.Warnf("%s: %v", name, err)
+	default:
+		s.Logger.Errorf("%s: %v", name, err)
+	}
 }
@@ -0,0 +1,176 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nmcapule/oclz-go/integrations/models"
+	"github.com/pocketbase/dbx"
+	pbmodels "github.com/pocketbase/pocketbase/models"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// recordInterrupted records that the sync of the seller SKU was interrupted,
+// e.g. on shutdown, so that it is resumed on the next start. If a push to the
+// tenant was interrupted, the pushed stocks are recorded too, since the push
+// may or may not have been applied by the vendor.
+func (s *Syncer) recordInterrupted(ctx context.Context, sellerSKU string, tenant models.IntegrationClient, stocks int) {
+	var tenantID string
+	if tenant != nil {
+		tenantID = tenant.Tenant().ID
+	}
+	logger := s.logger(ctx).WithFields(log.Fields{
+		"seller_sku": sellerSKU,
+		"tenant":     tenantID,
+	})
+
+	records, err := s.Dao.FindRecordsByExpr("interrupted_syncs", dbx.HashExp{
+		"tenant_group": s.groupID,
+		"seller_sku":   sellerSKU,
+		"tenant":       tenantID,
+	})
+	if err != nil {
+		logger.Errorf("Failed to load interrupted syncs: %v", err)
+		return
+	}
+	var record *pbmodels.Record
+	if len(records) > 0 {
+		record = records[0]
+	} else {
+		collection, err := s.Dao.FindCollectionByNameOrId("interrupted_syncs")
+		if err != nil {
+			logger.Errorf("Failed to load interrupted syncs: %v", err)
+			return
+		}
+		record = pbmodels.NewRecord(collection)
+		record.Set("tenant_group", s.groupID)
+		record.Set("seller_sku", sellerSKU)
+		record.Set("tenant", tenantID)
+	}
+	record.Set("stocks", stocks)
+	if err := s.Dao.SaveRecord(record); err != nil {
+		logger.Errorf("Failed to record interrupted sync: %v", err)
+		return
+	}
+	logger.Warnln("Recorded interrupted sync, resuming on next start")
+}
+
+// ResumeInterrupted resumes the interrupted syncs of the tenant group. The
+// interrupted pushes that were applied by the vendor are saved to the tenant
+// inventory first, so that they are not mistaken for changes on the tenant.
+// Then, the items are synced again. Items that fail to resume are kept for
+// the next start, unless they no longer exist on a tenant.
+func (s *Syncer) ResumeInterrupted(ctx context.Context) error {
+	records, err := s.Dao.FindRecordsByExpr("interrupted_syncs", dbx.HashExp{
+		"tenant_group": s.groupID,
+	})
+	if err != nil {
+		return fmt.Errorf("loading interrupted syncs: %v", err)
+	}
+	var sellerSKUs []string
+	interrupted := make(map[string][]*pbmodels.Record)
+	for _, record := range records {
+		sellerSKU := record.GetString("seller_sku")
+		if _, ok := interrupted[sellerSKU]; !ok {
+			sellerSKUs = append(sellerSKUs, sellerSKU)
+		}
+		interrupted[sellerSKU] = append(interrupted[sellerSKU], record)
+	}
+
+	for _, sellerSKU := range sellerSKUs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if s.isDraining() {
+			return ErrDraining
+		}
+		logger := s.logger(ctx).WithFields(log.Fields{
+			"seller_sku": sellerSKU,
+		})
+		logger.Infoln("Resuming interrupted sync")
+		err := s.resumeItem(ctx, sellerSKU, interrupted[sellerSKU])
+		switch {
+		case err == nil:
+			s.count(ctx, "items_resumed", 1)
+		case errors.Is(err, models.ErrNotFound):
+			logger.Warnf("Dropping interrupted sync: %v", err)
+			s.count(ctx, "items_dropped", 1)
+		default:
+			if ctx.Err() != nil {
+				return err
+			}
+			logger.Errorf("Failed to resume interrupted sync, retrying on next start: %v", err)
+			s.count(ctx, "items_failed", 1)
+			continue
+		}
+		for _, record := range interrupted[sellerSKU] {
+			if err := s.Dao.DeleteRecord(record); err != nil {
+				logger.Errorf("Failed to delete interrupted sync: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// resumeItem resumes the interrupted pushes of the seller SKU, and syncs it.
+func (s *Syncer) resumeItem(ctx context.Context, sellerSKU string, records []*pbmodels.Record) error {
+	for _, record := range records {
+		if record.GetString("tenant") == "" {
+			continue
+		}
+		if err := s.resumePush(ctx, record); err != nil {
+			return fmt.Errorf("resuming push: %w", err)
+		}
+	}
+	if err := s.SyncItem(ctx, sellerSKU); err != nil {
+		return fmt.Errorf("syncing: %w", err)
+	}
+	return nil
+}
+
+// resumePush saves the live item to the tenant inventory if the interrupted
+// push was applied, i.e. the live stocks are the pushed stocks. Items that no
+// longer exist on the tenant have nothing to resume.
+func (s *Syncer) resumePush(ctx context.Context, record *pbmodels.Record) error {
+	var tenant models.IntegrationClient
+	for _, t := range s.Tenants() {
+		if t.Tenant().ID == record.GetString("tenant") {
+			tenant = t
+		}
+	}
+	if tenant == nil {
+		return nil
+	}
+	sellerSKU := record.GetString("seller_sku")
+	logger := s.logger(ctx).WithFields(log.Fields{
+		"seller_sku": sellerSKU,
+		"tenant":     tenant.Tenant().Name,
+		"stocks":     record.GetInt("stocks"),
+	})
+	cached, err := s.tenantInventory(tenant, sellerSKU)
+	if errors.Is(err, models.ErrNotFound) {
+		logger.Infoln("Item of interrupted push is no longer cached")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("loading cached item from %s: %v", tenant.Tenant().Name, err)
+	}
+	live, err := tenant.LoadItem(ctx, sellerSKU)
+	if errors.Is(err, models.ErrNotFound) {
+		logger.Infoln("Item of interrupted push no longer exists")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("loading live item from %s: %v", tenant.Tenant().Name, err)
+	}
+	if live.Stocks != record.GetInt("stocks") {
+		logger.Infoln("Interrupted push was not applied")
+		return nil
+	}
+	logger.Infoln("Interrupted push was applied, saving it")
+	live.ID = cached.ID
+	live.Created = cached.Created
+	return s.saveTenantInventory(ctx, tenant, live)
+}
@@ -0,0 +1,44 @@
+package syncer
+
+import "sync"
+
+// itemLocks are the locks of the seller SKUs being synced, so that the same
+// item is never synced by two runs at once, e.g. by a triggered sync_item and
+// the scheduled sync. The zero value is ready to use.
+type itemLocks struct {
+	mu    sync.Mutex
+	locks map[string]*itemLock
+}
+
+type itemLock struct {
+	mu sync.Mutex
+	// refs is the number of holders and waiters of the lock, which is
+	// removed once there are none.
+	refs int
+}
+
+// lock locks the seller SKU, and returns the func that unlocks it.
+func (l *itemLocks) lock(sellerSKU string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*itemLock)
+	}
+	lock, ok := l.locks[sellerSKU]
+	if !ok {
+		lock = &itemLock{}
+		l.locks[sellerSKU] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(l.locks, sellerSKU)
+		}
+	}
+}
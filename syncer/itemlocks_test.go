@@ -0,0 +1,47 @@
+package syncer
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func TestItemLocks(t *testing.T) {
+	var locks itemLocks
+
+	// Syncs of the same item never overlap.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var syncing, overlaps int
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock := locks.lock("SKU-1")
+			defer unlock()
+			mu.Lock()
+			syncing++
+			if syncing > 1 {
+				overlaps++
+			}
+			mu.Unlock()
+			runtime.Gosched()
+			mu.Lock()
+			syncing--
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if overlaps > 0 {
+		t.Errorf("syncs of the same item overlapped %d times", overlaps)
+	}
+
+	// Other items are not blocked.
+	unlock := locks.lock("SKU-1")
+	locks.lock("SKU-2")()
+	unlock()
+
+	if len(locks.locks) != 0 {
+		t.Errorf("locks = %v, want them removed once unlocked", locks.locks)
+	}
+}
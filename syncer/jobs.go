@@ -0,0 +1,278 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nmcapule/oclz-go/utils/scheduler"
+)
+
+// Job is a background job of the syncer.
+type Job string
+
+const (
+	// JobCollect collects the items of all tenants.
+	JobCollect Job = "collect"
+	// JobSync syncs all items of the intent tenant.
+	JobSync Job = "sync"
+	// JobRefreshCredentials refreshes the credentials of all tenants.
+	JobRefreshCredentials Job = "refresh_credentials"
+	// JobSyncItem syncs a single item. It is only run on demand.
+	JobSyncItem Job = "sync_item"
+)
+
+const defaultRunTimeoutMinutes = 60
+
+// ErrDraining is returned when starting or continuing a run while the syncer
+// is draining for shutdown.
+var ErrDraining = errors.New("syncer is draining")
+
+// ErrAlreadyRunning is returned when starting a run of a job that is already
+// running. Runs of JobSyncItem only wait for the syncs of the same item.
+var ErrAlreadyRunning = errors.New("already running")
+
+// Jobs are all the scheduled background jobs of the syncer.
+var Jobs = []Job{JobCollect, JobSync, JobRefreshCredentials}
+
+// jobRun is a run of a job in progress.
+type jobRun struct {
+	id        string
+	job       Job
+	sellerSKU string
+	started   time.Time
+	ctx       context.Context
+	cancel    context.CancelFunc
+
+	// Guards the counts of the run, e.g. of synced items.
+	countsMu sync.Mutex
+	counts   map[string]int
+}
+
+// JobRun is a snapshot of a run of a job.
+type JobRun struct {
+	// ID is the ID of the job_runs record of the run, which is also attached
+	// to the logs of the run.
+	ID  string
+	Job Job
+	// SellerSKU is the synced item of a JobSyncItem run.
+	SellerSKU string
+	Started   time.Time
+	// Finished is zero while the run is in progress.
+	Finished time.Time
+	Status   RunStatus
+	Counts   map[string]int
+	// Err is the error of the finished run, if any.
+	Err error
+}
+
+// Duration returns how long the finished run took, to the second.
+func (r JobRun) Duration() time.Duration {
+	return r.Finished.Sub(r.Started).Round(time.Second)
+}
+
+// JobStatus is the schedule and the last run of a job.
+type JobStatus struct {
+	Job Job
+	// Next is the next planned run, or zero if not planned, e.g. while the
+	// syncer is not started.
+	Next time.Time
+	// Last is the last finished run, if any.
+	Last *JobRun
+}
+
+// runJob runs fn as a run of the job. The context of the run is cancelled
+// once the run deadline is reached, the job is cancelled, or the parent
+// context is done, e.g. on shutdown. The error of a stopped run wraps the
+// context error. No runs are started once the syncer is draining, or while
+// the job is already running.
+func (s *Syncer) runJob(ctx context.Context, job Job, fn func(ctx context.Context) error) error {
+	run, err := s.startRun(ctx, job, "")
+	if err != nil {
+		return err
+	}
+	return s.finishRun(run, fn)
+}
+
+// startRun registers a run of the job, and records it in job_runs. Fails with
+// ErrAlreadyRunning if the job is already running, unless it is JobSyncItem.
+func (s *Syncer) startRun(ctx context.Context, job Job, sellerSKU string) (*jobRun, error) {
+	minutes := s.Config().RunTimeoutMinutes
+	if minutes <= 0 {
+		minutes = defaultRunTimeoutMinutes
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(minutes)*time.Minute)
+
+	run := &jobRun{
+		job:       job,
+		sellerSKU: sellerSKU,
+		started:   time.Now(),
+		cancel:    cancel,
+		counts:    make(map[string]int),
+	}
+	s.runsMu.Lock()
+	if s.draining {
+		s.runsMu.Unlock()
+		cancel()
+		return nil, ErrDraining
+	}
+	if job != JobSyncItem {
+		for other := range s.runs {
+			if other.job == job {
+				s.runsMu.Unlock()
+				cancel()
+				return nil, fmt.Errorf("%s is %w", job, ErrAlreadyRunning)
+			}
+		}
+	}
+	s.runs[run] = struct{}{}
+	s.runsWG.Add(1)
+	s.runsMu.Unlock()
+
+	run.id = s.recordRunStarted(run)
+	run.ctx = context.WithValue(ctx, runKey{}, run)
+	return run, nil
+}
+
+// finishRun calls fn with the context of the run, and then unregisters the
+// run and records its result.
+func (s *Syncer) finishRun(run *jobRun, fn func(ctx context.Context) error) (err error) {
+	defer func() {
+		run.cancel()
+		snapshot := run.snapshot()
+		snapshot.Finished = time.Now()
+		snapshot.Status = runStatus(err)
+		snapshot.Err = err
+
+		s.runsMu.Lock()
+		delete(s.runs, run)
+		s.lastRuns[run.job] = snapshot
+		s.runsMu.Unlock()
+		s.recordRunFinished(snapshot)
+		s.runsWG.Done()
+	}()
+
+	err = fn(run.ctx)
+	if err != nil && run.ctx.Err() != nil {
+		return fmt.Errorf("%s run stopped: %v: %w", run.job, err, run.ctx.Err())
+	}
+	return err
+}
+
+// snapshot returns a snapshot of the run in progress.
+func (r *jobRun) snapshot() JobRun {
+	r.countsMu.Lock()
+	defer r.countsMu.Unlock()
+	counts := make(map[string]int, len(r.counts))
+	for name, n := range r.counts {
+		counts[name] = n
+	}
+	return JobRun{
+		ID:        r.id,
+		Job:       r.job,
+		SellerSKU: r.sellerSKU,
+		Started:   r.started,
+		Status:    RunRunning,
+		Counts:    counts,
+	}
+}
+
+// Running returns the runs of jobs in progress, oldest first.
+func (s *Syncer) Running() []JobRun {
+	s.runsMu.Lock()
+	defer s.runsMu.Unlock()
+	var runs []JobRun
+	for run := range s.runs {
+		runs = append(runs, run.snapshot())
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].Started.Before(runs[j].Started)
+	})
+	return runs
+}
+
+// Statuses returns the schedule and the last run of all jobs.
+func (s *Syncer) Statuses() []JobStatus {
+	s.runsMu.Lock()
+	defer s.runsMu.Unlock()
+	var statuses []JobStatus
+	for _, job := range Jobs {
+		status := JobStatus{Job: job, Next: s.nextRuns[job]}
+		if last, ok := s.lastRuns[job]; ok {
+			status.Last = &last
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// plan returns the current run plan of the job, which is replaced whenever
+// the tenant group config is reloaded.
+func (s *Syncer) plan(job Job) *scheduler.Plan {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.plans[job]
+}
+
+// planned returns a callback that records the next planned run of the job.
+func (s *Syncer) planned(job Job) func(next time.Time) {
+	return func(next time.Time) {
+		s.runsMu.Lock()
+		defer s.runsMu.Unlock()
+		s.nextRuns[job] = next
+	}
+}
+
+// Cancel cancels the runs of the job in progress, which stops their in-flight
+// vendor requests. Returns false if the job is not running.
+func (s *Syncer) Cancel(job Job) bool {
+	s.runsMu.Lock()
+	defer s.runsMu.Unlock()
+	var cancelled bool
+	for run := range s.runs {
+		if run.job == job {
+			run.cancel()
+			cancelled = true
+		}
+	}
+	return cancelled
+}
+
+// Drain stops the syncer from starting new runs, and lets the runs in
+// progress stop at the next item or tenant. Waits for the runs until the
+// context is done, and then cancels them, which interrupts their in-flight
+// vendor requests. Start returns once the cancelled runs have returned.
+// Interrupted item syncs are resumed on the next start.
+func (s *Syncer) Drain(ctx context.Context) error {
+	s.runsMu.Lock()
+	s.draining = true
+	s.runsMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.runsWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.runsMu.Lock()
+	for run := range s.runs {
+		run.cancel()
+	}
+	s.runsMu.Unlock()
+	return ctx.Err()
+}
+
+// isDraining returns true if the syncer is draining for shutdown.
+func (s *Syncer) isDraining() bool {
+	s.runsMu.Lock()
+	defer s.runsMu.Unlock()
+	return s.draining
+}
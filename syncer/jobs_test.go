@@ -0,0 +1,71 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// addRun registers a run of the job without recording it in job_runs, and
+// returns a func that finishes it.
+func addRun(s *Syncer, job Job) (*jobRun, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &jobRun{job: job, ctx: ctx, cancel: cancel, counts: make(map[string]int)}
+	s.runsMu.Lock()
+	s.runs[run] = struct{}{}
+	s.runsWG.Add(1)
+	s.runsMu.Unlock()
+	return run, func() {
+		s.runsMu.Lock()
+		delete(s.runs, run)
+		s.runsMu.Unlock()
+		s.runsWG.Done()
+	}
+}
+
+func TestDrain(t *testing.T) {
+	s := &Syncer{runs: make(map[*jobRun]struct{})}
+
+	// Runs stop at the next item once draining.
+	run, finish := addRun(s, JobSync)
+	go func() {
+		for !s.isDraining() {
+			time.Sleep(time.Millisecond)
+		}
+		finish()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := s.Drain(ctx); err != nil {
+		t.Fatalf("Drain() = %v, want no error", err)
+	}
+	if run.ctx.Err() != nil {
+		t.Errorf("drained run was cancelled, want it to finish on its own")
+	}
+
+	// No runs are started once draining.
+	if _, err := s.startRun(context.Background(), JobCollect, ""); !errors.Is(err, ErrDraining) {
+		t.Errorf("startRun() = %v, want %v", err, ErrDraining)
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	s := &Syncer{runs: make(map[*jobRun]struct{})}
+
+	// Runs that don't stop in time are cancelled.
+	run, finish := addRun(s, JobCollect)
+	go func() {
+		<-run.ctx.Done()
+		finish()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Drain() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if run.ctx.Err() == nil {
+		t.Errorf("run was not cancelled after the drain timeout")
+	}
+	s.runsWG.Wait()
+}
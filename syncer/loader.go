@@ -1,109 +1,58 @@
 package syncer
 
 import (
-	"encoding/json"
 	"fmt"
 
-	"github.com/nmcapule/oclz-go/integrations/intent"
-	"github.com/nmcapule/oclz-go/integrations/lazada"
 	"github.com/nmcapule/oclz-go/integrations/models"
-	"github.com/nmcapule/oclz-go/integrations/opencart"
-	"github.com/nmcapule/oclz-go/integrations/shopee"
-	"github.com/nmcapule/oclz-go/integrations/tiktok"
 	"github.com/nmcapule/oclz-go/oauth2"
-	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/core"
 
 	log "github.com/sirupsen/logrus"
+
+	// Register built-in vendors.
+	_ "github.com/nmcapule/oclz-go/integrations/intent"
+	_ "github.com/nmcapule/oclz-go/integrations/lazada"
+	_ "github.com/nmcapule/oclz-go/integrations/opencart"
+	_ "github.com/nmcapule/oclz-go/integrations/rest"
+	_ "github.com/nmcapule/oclz-go/integrations/shopee"
+	_ "github.com/nmcapule/oclz-go/integrations/spreadsheet"
+	_ "github.com/nmcapule/oclz-go/integrations/tiktok"
 )
 
-// LoadClient loads a client depending on the config vendor.
-func LoadClient(dao *daos.Dao, tenantName string) (models.IntegrationClient, error) {
+// LoadClient loads a client depending on the config vendor. Vendors must be
+// registered beforehand with models.RegisterVendor.
+func LoadClient(app core.App, tenantName string) (models.IntegrationClient, error) {
+	dao := app.Dao()
 	record, err := dao.FindFirstRecordByData("tenants", "name", tenantName)
 	if err != nil {
 		return nil, err
 	}
 
-	oauth2Service := &oauth2.Service{Dao: dao}
 	tenant := models.TenantFrom(record)
-	switch tenant.Vendor {
-	case intent.Vendor:
-		var config intent.Config
-		err := json.Unmarshal(tenant.Config, &config)
-		if err != nil {
-			return nil, err
-		}
-		return &intent.Client{
-			BaseDatabaseTenant: &models.BaseDatabaseTenant{
-				BaseTenant: tenant,
-				Dao:        dao,
-			},
-		}, nil
-	case opencart.Vendor:
-		var config opencart.Config
-		err := json.Unmarshal(tenant.Config, &config)
-		if err != nil {
-			return nil, err
-		}
-		return &opencart.Client{
-			BaseTenant: tenant,
-			DatabaseTenant: &models.BaseDatabaseTenant{
-				BaseTenant: tenant,
-				Dao:        dao,
-			},
-			Config: &config,
-		}, nil
-	case tiktok.Vendor:
-		var config tiktok.Config
-		err := json.Unmarshal(tenant.Config, &config)
-		if err != nil {
-			return nil, err
-		}
-		credentials, err := oauth2Service.Load(tenant.ID)
-		if err != nil {
-			return nil, err
-		}
-		return &tiktok.Client{
-			BaseTenant:  tenant,
-			Config:      &config,
-			Credentials: credentials,
-		}, nil
-	case lazada.Vendor:
-		var config lazada.Config
-		err := json.Unmarshal(tenant.Config, &config)
-		if err != nil {
-			return nil, err
-		}
-		credentials, err := oauth2Service.Load(tenant.ID)
-		if err != nil {
-			return nil, err
-		}
-		return &lazada.Client{
-			BaseTenant:  tenant,
-			Config:      &config,
-			Credentials: credentials,
-		}, nil
-	case shopee.Vendor:
-		var config shopee.Config
-		err := json.Unmarshal(tenant.Config, &config)
-		if err != nil {
-			return nil, err
-		}
+	vendor, ok := models.LookupVendor(tenant.Vendor)
+	if !ok {
+		return nil, fmt.Errorf("unsupported vendor %q", tenant.Vendor)
+	}
+
+	deps := &models.VendorDeps{
+		Tenant:     tenant,
+		Dao:        dao,
+		Filesystem: app.NewFilesystem,
+	}
+	if vendor.Credentials != models.CredentialsNone {
+		oauth2Service := &oauth2.Service{Dao: dao}
 		credentials, err := oauth2Service.Load(tenant.ID)
-		if err == oauth2.ErrNoCredentials {
+		if err == oauth2.ErrNoCredentials && vendor.Credentials == models.CredentialsOptional {
 			log.Warnf("no credentials found for %s, anyways...", tenant.Name)
 		} else if err != nil {
 			return nil, err
 		}
-		return &shopee.Client{
-			BaseTenant: tenant,
-			DatabaseTenant: &models.BaseDatabaseTenant{
-				BaseTenant: tenant,
-				Dao:        dao,
-			},
-			Config:      &config,
-			Credentials: credentials,
-		}, nil
-	default:
-		return nil, fmt.Errorf("unsupported vendor %q", tenant.Vendor)
+		deps.Credentials = credentials
+	}
+	// Secrets are only decrypted in memory.
+	config, err := decryptConfig(vendor, tenant.Config)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting %s config: %v", tenant.Name, err)
 	}
+	return vendor.Load(deps, config)
 }
@@ -0,0 +1,120 @@
+package syncer
+
+import (
+	"context"
+	"sort"
+
+	"github.com/nmcapule/oclz-go/integrations/intent"
+	"github.com/nmcapule/oclz-go/integrations/models"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// locationTargets applies the stock deltas of the tenants to the locations of
+// the intent item, and returns the target stocks of each tenant, which are the
+// stocks of the locations that the tenant ships from. Sales are deducted from
+// the locations of the tenant in order, and restocks are added to its first
+// location. The reserved stocks of a tenant are held at its first location,
+// and are not available to the other tenants.
+func (s *Syncer) locationTargets(ctx context.Context, ic *intent.Client, intentItem *models.Item, tenants map[string]models.IntegrationClient, deltas, reserved map[string]int) map[string]int {
+	stocks := ic.LocationStocks(intentItem)
+
+	// Apply in a stable order, so that competing sales are deducted the same
+	// way on every run.
+	var names []string
+	for name := range deltas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		delta := deltas[name]
+		locations := tenantLocations(ic, tenants[name])
+		if delta > 0 {
+			stocks[locations[0]] += delta
+			continue
+		}
+		for _, location := range locations {
+			if delta == 0 {
+				break
+			}
+			take := stocks[location]
+			if take > -delta {
+				take = -delta
+			}
+			if take > 0 {
+				stocks[location] -= take
+				delta += take
+			}
+		}
+		if delta < 0 {
+			s.logger(ctx).WithFields(log.Fields{
+				"seller_sku": intentItem.SellerSKU,
+				"tenant":     name,
+				"oversold":   -delta,
+			}).Warnln("Tenant sold more than the stocks of its locations")
+		}
+	}
+	ic.SetLocationStocks(intentItem, stocks)
+
+	held := make(map[string]int)
+	for name, n := range reserved {
+		held[tenantLocations(ic, tenants[name])[0]] += n
+	}
+	targets := make(map[string]int)
+	for name, tenant := range tenants {
+		if tenant.Tenant().ID == ic.ID {
+			targets[name] = intentItem.Stocks
+			continue
+		}
+		for _, location := range tenantLocations(ic, tenant) {
+			if available := stocks[location] - held[location]; available > 0 {
+				targets[name] += available
+			}
+		}
+	}
+	return targets
+}
+
+// tenantLocations returns the intent locations that the tenant ships from, in
+// order of priority. Tenants without locations ship from all locations.
+// Unknown locations are ignored, see warnUnknownLocations.
+func tenantLocations(ic *intent.Client, tenant models.IntegrationClient) []string {
+	known := make(map[string]bool)
+	for _, location := range ic.Config.Locations {
+		known[location] = true
+	}
+	var locations []string
+	for _, location := range tenant.Tenant().Locations {
+		if known[location] {
+			locations = append(locations, location)
+		}
+	}
+	if len(locations) == 0 {
+		return ic.Config.Locations
+	}
+	return locations
+}
+
+// warnUnknownLocations warns about the tenant locations that are not locations
+// of the intent tenant, once whenever the tenants or the intent tenant change
+// rather than on every sync. Must be called with mu held.
+func (s *Syncer) warnUnknownLocations() {
+	ic, ok := s.intentTenant.(*intent.Client)
+	if !ok || !ic.HasLocations() {
+		return
+	}
+	known := make(map[string]bool)
+	for _, location := range ic.Config.Locations {
+		known[location] = true
+	}
+	for _, tenant := range s.tenants {
+		for _, location := range tenant.Tenant().Locations {
+			if !known[location] {
+				s.Logger.WithFields(log.Fields{
+					"tenant":   tenant.Tenant().Name,
+					"location": location,
+				}).Warnln("Ignoring unknown intent location")
+			}
+		}
+	}
+}
@@ -9,6 +9,26 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// RunHook is a logrus hook that attaches the ID of the job run of the entry
+// context, so that the logs of a run can be found, including the logs of the
+// vendor clients, which log with log.WithContext.
+type RunHook struct{}
+
+func (RunHook) Fire(entry *log.Entry) error {
+	if entry.Context == nil {
+		return nil
+	}
+	if run := runFrom(entry.Context); run != nil {
+		entry.Data["run_id"] = run.id
+	}
+	return nil
+}
+
+// Levels define on which log levels this hook would trigger
+func (RunHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
 // LogHook is a logrus hook that writes the logs to the database.
 type LogHook struct {
 	LogLevels  []log.Level
@@ -29,6 +49,9 @@ func (h *LogHook) Fire(entry *log.Entry) error {
 	record.Set("fields", entry.Data)
 	record.Set("level", entry.Level)
 	record.Set("caller", entry.Caller.Function)
+	if runID, ok := entry.Data["run_id"]; ok {
+		record.Set("run_id", runID)
+	}
 	return h.Dao.SaveRecord(record)
 }
 
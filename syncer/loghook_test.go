@@ -0,0 +1,34 @@
+package syncer
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestRunHook(t *testing.T) {
+	logger := log.New()
+	logger.SetOutput(io.Discard)
+	logger.AddHook(RunHook{})
+	hook := test.NewLocal(logger)
+	run := &jobRun{id: "run-1"}
+	ctx := context.WithValue(context.Background(), runKey{}, run)
+
+	// E.g. a vendor client, which only has the context of the run.
+	logger.WithContext(ctx).WithFields(log.Fields{"tenant": "shop"}).Warnln("in run")
+	if got := hook.LastEntry().Data["run_id"]; got != "run-1" {
+		t.Errorf("run_id = %v, want %q", got, "run-1")
+	}
+
+	logger.WithContext(context.Background()).Warnln("outside run")
+	if got, ok := hook.LastEntry().Data["run_id"]; ok {
+		t.Errorf("run_id = %v, want none outside a run", got)
+	}
+	logger.Warnln("without context")
+	if got, ok := hook.LastEntry().Data["run_id"]; ok {
+		t.Errorf("run_id = %v, want none without a context", got)
+	}
+}
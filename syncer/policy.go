@@ -0,0 +1,73 @@
+package syncer
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/models"
+)
+
+// Policy is a sync policy of a seller SKU on a tenant.
+type Policy string
+
+const (
+	// PolicyExclude never touches the item on the tenant.
+	PolicyExclude Policy = "EXCLUDE"
+	// PolicyPin always pushes the fixed stocks of the policy to the tenant,
+	// while still pulling the stock changes on the tenant.
+	PolicyPin Policy = "PIN"
+	// PolicyOneWay pulls the stock changes on the tenant, but never pushes.
+	PolicyOneWay Policy = "ONE_WAY"
+)
+
+// SyncPolicy is a sync policy record.
+type SyncPolicy struct {
+	ID        string
+	TenantID  string
+	SellerSKU string
+	Policy    Policy
+	// Stocks are the pinned stocks of PolicyPin.
+	Stocks int
+	Note   string
+}
+
+// SyncPolicyFrom creates a sync policy from a db record.
+func SyncPolicyFrom(record *models.Record) *SyncPolicy {
+	return &SyncPolicy{
+		ID:        record.GetId(),
+		TenantID:  record.GetString("tenant"),
+		SellerSKU: record.GetString("seller_sku"),
+		Policy:    Policy(record.GetString("policy")),
+		Stocks:    record.GetInt("stocks"),
+		Note:      record.GetString("note"),
+	}
+}
+
+// syncPolicies returns the sync policies of the seller SKU, keyed by tenant ID.
+func (s *Syncer) syncPolicies(sellerSKU string) (map[string]*SyncPolicy, error) {
+	records, err := s.Dao.FindRecordsByExpr("sync_policies", dbx.HashExp{
+		"seller_sku": sellerSKU,
+	})
+	if err != nil {
+		return nil, err
+	}
+	policies := make(map[string]*SyncPolicy)
+	for _, record := range records {
+		policies[record.GetString("tenant")] = SyncPolicyFrom(record)
+	}
+	return policies, nil
+}
+
+// excludedSKUs returns the seller SKUs excluded from sync on the tenant.
+func (s *Syncer) excludedSKUs(tenantID string) (map[string]bool, error) {
+	records, err := s.Dao.FindRecordsByExpr("sync_policies", dbx.HashExp{
+		"tenant": tenantID,
+		"policy": string(PolicyExclude),
+	})
+	if err != nil {
+		return nil, err
+	}
+	excluded := make(map[string]bool)
+	for _, record := range records {
+		excluded[record.GetString("seller_sku")] = true
+	}
+	return excluded, nil
+}
@@ -0,0 +1,176 @@
+package syncer
+
+import (
+	"github.com/nmcapule/oclz-go/integrations/models"
+	"github.com/nmcapule/oclz-go/oauth2"
+	"github.com/pocketbase/pocketbase/core"
+	pbmodels "github.com/pocketbase/pocketbase/models"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// hookReload subscribes to changes of tenant records, so that tenants can be
+// enabled, disabled or reconfigured without restarting the process.
+func (s *Syncer) hookReload() {
+	reloadTenant := func(e *core.ModelEvent) error {
+		if record, ok := e.Model.(*pbmodels.Record); ok {
+			s.reloadTenant(record)
+		}
+		return nil
+	}
+	s.App.OnModelAfterCreate("tenants").Add(reloadTenant)
+	s.App.OnModelAfterUpdate("tenants").Add(reloadTenant)
+	s.App.OnModelAfterDelete("tenants").Add(func(e *core.ModelEvent) error {
+		if !s.hasTenant(e.Model.GetId()) {
+			return nil
+		}
+		if err := s.unloadTenant(e.Model.GetId()); err != nil {
+			s.Logger.Errorf("Syncing is paused after deleting tenant: %v", err)
+		}
+		return nil
+	})
+
+	s.App.OnModelAfterUpdate("tenant_groups").Add(func(e *core.ModelEvent) error {
+		if record, ok := e.Model.(*pbmodels.Record); ok {
+			s.reloadTenantGroup(record)
+		}
+		return nil
+	})
+
+	reloadCredentials := func(e *core.ModelEvent) error {
+		if record, ok := e.Model.(*pbmodels.Record); ok {
+			s.reloadCredentials(record)
+		}
+		return nil
+	}
+	s.App.OnModelAfterCreate("tenant_oauth2").Add(reloadCredentials)
+	s.App.OnModelAfterUpdate("tenant_oauth2").Add(reloadCredentials)
+}
+
+// hookGroupChanges warns about the tenant groups that are created, enabled or
+// disabled at runtime, since syncers are only created on start.
+func hookGroupChanges(app core.App, syncers map[string]*Syncer) {
+	warn := func(e *core.ModelEvent) error {
+		record, ok := e.Model.(*pbmodels.Record)
+		if !ok {
+			return nil
+		}
+		logger := log.WithFields(log.Fields{
+			"tenant_group": record.GetString("name"),
+		})
+		_, running := syncers[record.GetString("name")]
+		switch {
+		case running && record.GetBool("disable"):
+			logger.Warnln("Tenant group is disabled, but keeps syncing until the next start")
+		case !running && !record.GetBool("disable"):
+			logger.Warnln("Tenant group is enabled, but only starts syncing on the next start")
+		}
+		return nil
+	}
+	app.OnModelAfterCreate("tenant_groups").Add(warn)
+	app.OnModelAfterUpdate("tenant_groups").Add(warn)
+}
+
+// reloadTenant rebuilds the client of the changed tenant record, and swaps it
+// with the current one. The current client is kept if the rebuild fails.
+func (s *Syncer) reloadTenant(record *pbmodels.Record) {
+	logger := s.Logger.WithFields(log.Fields{
+		"tenant": record.GetString("name"),
+	})
+	if record.GetString("tenant_group") != s.groupID || !record.GetBool("enable") {
+		if s.hasTenant(record.GetId()) {
+			logger.Infoln("Unloading tenant after record change")
+			if err := s.unloadTenant(record.GetId()); err != nil {
+				logger.Errorf("Syncing is paused after unloading tenant: %v", err)
+			}
+		}
+		return
+	}
+
+	client, err := LoadClient(s.App, record.GetString("name"))
+	if err != nil {
+		logger.Errorf("Failed to reload tenant, keeping previous client: %v", err)
+		return
+	}
+	logger.Infoln("Reloading tenant after record change")
+	if err := s.swap(record.GetId(), client); err != nil {
+		logger.Errorf("Syncing is paused after reloading tenant: %v", err)
+	}
+}
+
+func (s *Syncer) unloadTenant(tenantID string) error {
+	return s.swap(tenantID, nil)
+}
+
+// reloadTenantGroup reloads the syncer config if the group is this syncer's.
+func (s *Syncer) reloadTenantGroup(record *pbmodels.Record) {
+	if record.GetId() != s.groupID {
+		return
+	}
+	if err := s.loadConfigFromGroup(record); err != nil {
+		s.Logger.Errorf("Failed to reload tenant group config: %v", err)
+		return
+	}
+	s.Logger.Infoln("Reloaded tenant group config")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.selectIntent(); err != nil {
+		s.Logger.Errorf("Syncing is paused after reloading tenant group config: %v", err)
+	}
+}
+
+// reloadCredentials swaps the changed credentials into the client of the
+// tenant. Saves of the credentials the client already has, e.g. after its own
+// refresh, are ignored. Clients that don't hold their credentials are
+// reloaded instead.
+func (s *Syncer) reloadCredentials(record *pbmodels.Record) {
+	tenantID := record.GetString("tenant")
+	client := s.tenantByID(tenantID)
+	if client == nil {
+		return
+	}
+	if holder, ok := client.CredentialsManager().(oauth2.CredentialsHolder); ok {
+		logger := s.Logger.WithFields(log.Fields{
+			"tenant": client.Tenant().Name,
+		})
+		oauth2Service := &oauth2.Service{Dao: s.Dao}
+		credentials, err := oauth2Service.Load(tenantID)
+		if err != nil {
+			logger.Errorf("Failed to load changed credentials: %v", err)
+			return
+		}
+		if current := holder.CurrentCredentials(); current != nil && current.AccessToken == credentials.AccessToken {
+			return
+		}
+		logger.Infoln("Swapping in changed credentials")
+		holder.SetCredentials(credentials)
+		return
+	}
+
+	tenant, err := s.Dao.FindRecordById("tenants", tenantID)
+	if err != nil {
+		s.Logger.WithFields(log.Fields{
+			"tenant_id": tenantID,
+		}).Errorf("Failed to find tenant of changed credentials: %v", err)
+		return
+	}
+	s.reloadTenant(tenant)
+}
+
+func (s *Syncer) hasTenant(tenantID string) bool {
+	return s.tenantByID(tenantID) != nil
+}
+
+// tenantByID returns the loaded client of the tenant, or nil if the tenant is
+// not loaded.
+func (s *Syncer) tenantByID(tenantID string) models.IntegrationClient {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, tenant := range s.tenants {
+		if tenant.Tenant().ID == tenantID {
+			return tenant
+		}
+	}
+	return nil
+}
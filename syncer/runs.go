@@ -0,0 +1,257 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/pocketbase/dbx"
+	pbmodels "github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/security"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const runsCollection = "job_runs"
+
+// RunStatus is the status of a job run.
+type RunStatus string
+
+const (
+	RunRunning   RunStatus = "running"
+	RunSucceeded RunStatus = "succeeded"
+	RunFailed    RunStatus = "failed"
+	// RunCancelled is the status of runs stopped by a cancellation or a
+	// shutdown.
+	RunCancelled RunStatus = "cancelled"
+)
+
+// runStatus returns the status of a run that finished with the error.
+func runStatus(err error) RunStatus {
+	switch {
+	case err == nil:
+		return RunSucceeded
+	case errors.Is(err, ErrDraining), errors.Is(err, context.Canceled):
+		return RunCancelled
+	default:
+		return RunFailed
+	}
+}
+
+// runKey is the context key of the run in progress.
+type runKey struct{}
+
+// runFrom returns the run of the context, or nil if not within a run.
+func runFrom(ctx context.Context) *jobRun {
+	run, _ := ctx.Value(runKey{}).(*jobRun)
+	return run
+}
+
+// logger returns the logger of the syncer with the context attached, so that
+// RunHook attaches the ID of the run of the context, if any.
+func (s *Syncer) logger(ctx context.Context) *log.Entry {
+	return s.Logger.WithContext(ctx)
+}
+
+// count adds n to the named count of the run of the context, if any.
+func (s *Syncer) count(ctx context.Context, name string, n int) {
+	run := runFrom(ctx)
+	if run == nil {
+		return
+	}
+	run.countsMu.Lock()
+	defer run.countsMu.Unlock()
+	run.counts[name] += n
+}
+
+// Trigger starts a run of the job in the background, e.g. on demand from the
+// UI, and returns the ID of the run. The seller SKU is only used by
+// JobSyncItem.
+func (s *Syncer) Trigger(job Job, sellerSKU string) (string, error) {
+	var fn func(ctx context.Context) error
+	switch job {
+	case JobCollect:
+		fn = s.CollectAllItems
+	case JobSync:
+		fn = s.SyncAllItems
+	case JobRefreshCredentials:
+		fn = s.RefreshCredentials
+	case JobSyncItem:
+		if sellerSKU == "" {
+			return "", errors.New("missing seller SKU")
+		}
+		fn = func(ctx context.Context) error {
+			return s.SyncItem(ctx, sellerSKU)
+		}
+	default:
+		return "", fmt.Errorf("unknown job %q", job)
+	}
+	if job != JobRefreshCredentials && s.IntentTenant() == nil {
+		return "", ErrNoIntentTenant
+	}
+
+	s.mu.RLock()
+	ctx := s.ctx
+	s.mu.RUnlock()
+	if ctx == nil {
+		return "", errors.New("syncer is not started")
+	}
+	run, err := s.startRun(ctx, job, sellerSKU)
+	if err != nil {
+		return "", err
+	}
+	s.logger(run.ctx).WithFields(log.Fields{
+		"job":        job,
+		"seller_sku": sellerSKU,
+	}).Infoln("Triggered job run")
+	go func() {
+		s.logRun(fmt.Sprintf("Triggered %s", job), s.finishRun(run, fn))
+	}()
+	return run.id, nil
+}
+
+// recordRunStarted saves the run to job_runs, and returns its ID. Failing to
+// record the run does not stop it, so the ID is generated either way.
+func (s *Syncer) recordRunStarted(run *jobRun) string {
+	id := security.RandomStringWithAlphabet(pbmodels.DefaultIdLength, pbmodels.DefaultIdAlphabet)
+	collection, err := s.Dao.FindCollectionByNameOrId(runsCollection)
+	if err != nil {
+		s.Logger.Errorf("Failed to record %s run: %v", run.job, err)
+		return id
+	}
+	record := pbmodels.NewRecord(collection)
+	record.SetId(id)
+	record.Set("tenant_group", s.groupID)
+	record.Set("job", string(run.job))
+	record.Set("seller_sku", run.sellerSKU)
+	record.Set("started", run.started)
+	record.Set("status", string(RunRunning))
+	if err := s.Dao.SaveRecord(record); err != nil {
+		s.Logger.Errorf("Failed to record %s run: %v", run.job, err)
+	}
+	return id
+}
+
+// recordRunFinished saves the result of the run to job_runs.
+func (s *Syncer) recordRunFinished(run JobRun) {
+	logger := s.Logger.WithFields(log.Fields{
+		"run_id": run.ID,
+	})
+	record, err := s.Dao.FindRecordById(runsCollection, run.ID)
+	if err != nil {
+		logger.Errorf("Failed to record finished %s run: %v", run.Job, err)
+		return
+	}
+	record.Set("finished", run.Finished)
+	record.Set("status", string(run.Status))
+	record.Set("counts", run.Counts)
+	if run.Err != nil {
+		record.Set("error", run.Err.Error())
+	}
+	if err := s.Dao.SaveRecord(record); err != nil {
+		logger.Errorf("Failed to record finished %s run: %v", run.Job, err)
+	}
+}
+
+// failStaleRuns marks the runs that were left running by a crash as failed.
+func (s *Syncer) failStaleRuns() error {
+	records, err := s.Dao.FindRecordsByExpr(runsCollection, dbx.HashExp{
+		"tenant_group": s.groupID,
+		"status":       string(RunRunning),
+	})
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		record.Set("status", string(RunFailed))
+		record.Set("error", "process exited during the run")
+		if err := s.Dao.SaveRecord(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecentRuns returns the last runs of the tenant group from job_runs, newest
+// first.
+func (s *Syncer) RecentRuns(limit int) ([]JobRun, error) {
+	collection, err := s.Dao.FindCollectionByNameOrId(runsCollection)
+	if err != nil {
+		return nil, err
+	}
+	var records []*pbmodels.Record
+	err = s.Dao.RecordQuery(collection).
+		AndWhere(dbx.HashExp{"tenant_group": s.groupID}).
+		OrderBy("started DESC").
+		Limit(int64(limit)).
+		All(&records)
+	if err != nil {
+		return nil, err
+	}
+	var runs []JobRun
+	for _, record := range records {
+		run, err := jobRunFromRecord(record)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+// seedLastRuns loads the last runs of the jobs from job_runs, so that the
+// statuses show the runs from before the start.
+func (s *Syncer) seedLastRuns() error {
+	collection, err := s.Dao.FindCollectionByNameOrId(runsCollection)
+	if err != nil {
+		return err
+	}
+	for _, job := range Jobs {
+		var records []*pbmodels.Record
+		err := s.Dao.RecordQuery(collection).
+			AndWhere(dbx.HashExp{
+				"tenant_group": s.groupID,
+				"job":          string(job),
+			}).
+			AndWhere(dbx.Not(dbx.HashExp{"status": string(RunRunning)})).
+			OrderBy("started DESC").
+			Limit(1).
+			All(&records)
+		if err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			continue
+		}
+		run, err := jobRunFromRecord(records[0])
+		if err != nil {
+			return err
+		}
+		s.runsMu.Lock()
+		if _, ok := s.lastRuns[job]; !ok {
+			s.lastRuns[job] = run
+		}
+		s.runsMu.Unlock()
+	}
+	return nil
+}
+
+func jobRunFromRecord(record *pbmodels.Record) (JobRun, error) {
+	run := JobRun{
+		ID:        record.Id,
+		Job:       Job(record.GetString("job")),
+		SellerSKU: record.GetString("seller_sku"),
+		Started:   record.GetDateTime("started").Time(),
+		Finished:  record.GetDateTime("finished").Time(),
+		Status:    RunStatus(record.GetString("status")),
+	}
+	if record.GetString("counts") != "" {
+		if err := record.UnmarshalJSONField("counts", &run.Counts); err != nil {
+			return JobRun{}, fmt.Errorf("decoding counts of run %s: %v", record.Id, err)
+		}
+	}
+	if message := record.GetString("error"); message != "" {
+		run.Err = errors.New(message)
+	}
+	return run, nil
+}
@@ -0,0 +1,203 @@
+package syncer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	imodels "github.com/nmcapule/oclz-go/integrations/models"
+	"github.com/nmcapule/oclz-go/secrets"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/spf13/cobra"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// NewKeyEnv is the environment variable of the new key when rotating keys.
+const NewKeyEnv = "OCLZ_SECRETS_NEW_KEY"
+
+// oauth2Secrets are the encrypted fields of the oauth2 collections.
+var (
+	oauth2Collections = []string{"tenant_oauth2", "tenant_oauth2_history"}
+	oauth2Secrets     = []string{"access_token", "refresh_token"}
+)
+
+// HookSecrets encrypts the secrets of tenant configs and oauth2 credentials
+// before they are saved, including secrets entered in plain text from the
+// admin UI. Nothing is encrypted if no key is set in the environment.
+func HookSecrets(app core.App) {
+	keyring, err := secrets.Default()
+	if err != nil {
+		log.Fatalf("Loading secrets key: %v", err)
+	}
+	if keyring == nil {
+		log.Warnf("%s is not set, secrets are saved in plain text", secrets.KeyEnv)
+	}
+
+	encryptTenant := func(e *core.ModelEvent) error {
+		if record, ok := e.Model.(*models.Record); ok {
+			return encryptTenantConfig(keyring.Encrypt, record)
+		}
+		return nil
+	}
+	app.OnModelBeforeCreate("tenants").Add(encryptTenant)
+	app.OnModelBeforeUpdate("tenants").Add(encryptTenant)
+
+	encryptCredentials := func(e *core.ModelEvent) error {
+		if record, ok := e.Model.(*models.Record); ok {
+			return encryptFields(keyring.Encrypt, record, oauth2Secrets)
+		}
+		return nil
+	}
+	app.OnModelBeforeCreate(oauth2Collections...).Add(encryptCredentials)
+	app.OnModelBeforeUpdate(oauth2Collections...).Add(encryptCredentials)
+}
+
+// encryptTenantConfig replaces the secrets of the tenant config by the result
+// of fn. Tenants of unknown vendors are left as is.
+func encryptTenantConfig(fn func(string) (string, error), record *models.Record) error {
+	vendor, ok := imodels.LookupVendor(record.GetString("vendor"))
+	if !ok {
+		return nil
+	}
+	raw := json.RawMessage(record.GetString("config"))
+	config, err := vendor.MapSecrets(raw, fn)
+	if err != nil {
+		return fmt.Errorf("tenant %s config: %v", record.GetString("name"), err)
+	}
+	if string(config) != string(raw) {
+		record.Set("config", config)
+	}
+	return nil
+}
+
+func encryptFields(fn func(string) (string, error), record *models.Record, fields []string) error {
+	for _, field := range fields {
+		value, err := fn(record.GetString(field))
+		if err != nil {
+			return fmt.Errorf("%s: %v", field, err)
+		}
+		record.Set(field, value)
+	}
+	return nil
+}
+
+// decryptConfig returns the tenant config with its secrets decrypted.
+func decryptConfig(vendor *imodels.Vendor, raw json.RawMessage) (json.RawMessage, error) {
+	return vendor.MapSecrets(raw, secrets.Decrypt)
+}
+
+// RotateSecrets re-encrypts all secrets with the primary key of the keyring,
+// which must also hold the keys that the secrets are currently encrypted
+// with. Plain text secrets are encrypted. Records whose secrets are already
+// encrypted with the primary key are left as is. Nothing is saved if any
+// secret fails. Returns the number of updated records.
+func RotateSecrets(dao *daos.Dao, keyring *secrets.Keyring) (int, error) {
+	var updated int
+	err := dao.RunInTransaction(func(txDao *daos.Dao) error {
+		tenants, err := txDao.FindRecordsByExpr("tenants")
+		if err != nil {
+			return err
+		}
+		for _, record := range tenants {
+			before := record.GetString("config")
+			if err := encryptTenantConfig(keyring.Rotate, record); err != nil {
+				return err
+			}
+			if record.GetString("config") == before {
+				continue
+			}
+			if err := txDao.SaveRecord(record); err != nil {
+				return fmt.Errorf("saving tenant %s: %v", record.GetString("name"), err)
+			}
+			updated++
+		}
+
+		for _, collection := range oauth2Collections {
+			credentials, err := txDao.FindRecordsByExpr(collection)
+			if err != nil {
+				return err
+			}
+			for _, record := range credentials {
+				var before []string
+				for _, field := range oauth2Secrets {
+					before = append(before, record.GetString(field))
+				}
+				if err := encryptFields(keyring.Rotate, record, oauth2Secrets); err != nil {
+					return fmt.Errorf("credentials of tenant %s: %v", record.GetString("tenant"), err)
+				}
+				changed := false
+				for i, field := range oauth2Secrets {
+					if record.GetString(field) != before[i] {
+						changed = true
+					}
+				}
+				if !changed {
+					continue
+				}
+				if err := txDao.SaveRecord(record); err != nil {
+					return fmt.Errorf("saving credentials of tenant %s: %v", record.GetString("tenant"), err)
+				}
+				updated++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return updated, nil
+}
+
+// NewSecretsCommand creates the console command to manage the key that
+// encrypts the secrets.
+func NewSecretsCommand(app core.App) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "secrets",
+		Short: "Manages the key that encrypts tenant secrets and oauth2 tokens",
+	}
+	command.AddCommand(&cobra.Command{
+		Use:   "generate-key",
+		Short: "Prints a new random key",
+		RunE: func(command *cobra.Command, args []string) error {
+			key, err := secrets.GenerateKey()
+			if err != nil {
+				return err
+			}
+			fmt.Println(key)
+			return nil
+		},
+	})
+	command.AddCommand(&cobra.Command{
+		Use:   "rotate-key",
+		Short: "Re-encrypts all secrets with a new key",
+		Long: fmt.Sprintf(`
+Re-encrypts all secrets with the key in %[1]s. Secrets are decrypted with the
+keys in %[2]s and %[3]s. Plain text secrets are encrypted.
+
+Stop the server first. Once done, set %[2]s to the new key and start the
+server again.
+`, NewKeyEnv, secrets.KeyEnv, secrets.PreviousKeysEnv),
+		RunE: func(command *cobra.Command, args []string) error {
+			newKey := os.Getenv(NewKeyEnv)
+			if newKey == "" {
+				return fmt.Errorf("%s is not set", NewKeyEnv)
+			}
+			oldKeys := append([]string{os.Getenv(secrets.KeyEnv)}, strings.Split(os.Getenv(secrets.PreviousKeysEnv), ",")...)
+			keyring, err := secrets.NewKeyring(newKey, oldKeys...)
+			if err != nil {
+				return err
+			}
+			updated, err := RotateSecrets(app.Dao(), keyring)
+			if err != nil {
+				return fmt.Errorf("rotating secrets: %v", err)
+			}
+			fmt.Printf("Re-encrypted the secrets of %d records. Set %s to the new key.\n", updated, secrets.KeyEnv)
+			return nil
+		},
+	})
+	return command
+}
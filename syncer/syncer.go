@@ -1,58 +1,180 @@
 package syncer
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/nmcapule/oclz-go/integrations/intent"
 	"github.com/nmcapule/oclz-go/integrations/models"
-	"github.com/nmcapule/oclz-go/oauth2"
+	"github.com/nmcapule/oclz-go/utils/scheduler"
 	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
 	"github.com/pocketbase/pocketbase/daos"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// ErrNoIntentTenant is returned when a tenant group has no valid intent tenant.
+var ErrNoIntentTenant = errors.New("no valid intent tenant")
+
 // Syncer orchestrates how to sync items across multiple tenants.
 type Syncer struct {
 	TenantGroupName string
+	App             core.App
 	Dao             *daos.Dao
-	Tenants         map[string]models.IntegrationClient
-	IntentTenant    models.IntegrationClient
-	Config          Config
-	Logger          *log.Logger
+	Logger          *log.Entry
+
+	// Guards the fields below, which can be swapped by hot-reloads while
+	// syncs are running.
+	mu           sync.RWMutex
+	groupID      string
+	config       Config
+	plans        map[Job]*scheduler.Plan
+	tenants      map[string]models.IntegrationClient
+	intentTenant models.IntegrationClient
+	daemons      map[string]context.CancelFunc
+	running      bool
+	// ctx is the context of the background service, which is done once the
+	// service stops.
+	ctx context.Context
+
+	// Guards the divergence counts of tenant items, keyed by tenant ID and
+	// seller SKU.
+	alertsMu   sync.Mutex
+	divergence map[string]int
+
+	// Guards the runs of jobs in progress, and whether the syncer is draining
+	// for shutdown.
+	runsMu   sync.Mutex
+	runs     map[*jobRun]struct{}
+	runsWG   sync.WaitGroup
+	draining bool
+	// Next planned and last finished runs of each job.
+	nextRuns map[Job]time.Time
+	lastRuns map[Job]JobRun
+	// Locks of the items being synced.
+	itemLocks itemLocks
 }
 
-// NewSyncer creates a new syncer instance.
-func NewSyncer(dao *daos.Dao, tenantGroupName string) (*Syncer, error) {
-	// Setup logger from standard logger. Note that this affects **all** logrus
-	// loggers within the application.
-	// TODO(nmcapule): Inject to every service that needs to log.
-	logger := log.StandardLogger()
-	logger.SetReportCaller(true)
-	logger.AddHook(&LogHook{
-		Dao: dao,
-		LogLevels: []log.Level{
-			log.InfoLevel,
-			log.WarnLevel,
-			log.ErrorLevel,
-			log.FatalLevel,
-			log.PanicLevel,
-		},
+var setupLoggerOnce sync.Once
+
+// setupLogger sets up the standard logger to also write to the database. Note
+// that this affects **all** logrus loggers within the application.
+// TODO(nmcapule): Inject to every service that needs to log.
+func setupLogger(dao *daos.Dao) {
+	setupLoggerOnce.Do(func() {
+		logger := log.StandardLogger()
+		logger.SetReportCaller(true)
+		// Attach the run IDs before the logs are written to the database.
+		logger.AddHook(RunHook{})
+		logger.AddHook(&LogHook{
+			Dao: dao,
+			LogLevels: []log.Level{
+				log.InfoLevel,
+				log.WarnLevel,
+				log.ErrorLevel,
+				log.FatalLevel,
+				log.PanicLevel,
+			},
+		})
+	})
+}
+
+// NewSyncers creates a syncer for each tenant group that is not disabled,
+// keyed by the tenant group name. Each syncer is isolated from the others.
+// Tenant groups that are created, enabled or disabled at runtime only take
+// effect on the next start, which is warned about when they are saved.
+func NewSyncers(app core.App) (map[string]*Syncer, error) {
+	// Groups are enabled by default, so that the groups from before there
+	// were many of them keep syncing.
+	groups, err := app.Dao().FindRecordsByExpr("tenant_groups", dbx.HashExp{
+		"disable": false,
 	})
+	if err != nil {
+		return nil, err
+	}
+	syncers := make(map[string]*Syncer)
+	for _, group := range groups {
+		// A misconfigured group should not take down the other groups.
+		s, err := NewSyncer(app, group.GetString("name"))
+		if err != nil {
+			log.WithFields(log.Fields{
+				"tenant_group": group.GetString("name"),
+			}).Errorf("Failed to instantiate syncer, skipping tenant group: %v", err)
+			continue
+		}
+		syncers[s.TenantGroupName] = s
+	}
+	if len(syncers) == 0 {
+		log.Warnln("No enabled tenant groups found.")
+	}
+	hookGroupChanges(app, syncers)
+	return syncers, nil
+}
+
+// NewSyncer creates a new syncer instance.
+func NewSyncer(app core.App, tenantGroupName string) (*Syncer, error) {
+	dao := app.Dao()
+	setupLogger(dao)
 
 	s := &Syncer{
 		TenantGroupName: tenantGroupName,
+		App:             app,
 		Dao:             dao,
-		Logger:          logger,
+		Logger: log.WithFields(log.Fields{
+			"tenant_group": tenantGroupName,
+		}),
+		tenants:    make(map[string]models.IntegrationClient),
+		daemons:    make(map[string]context.CancelFunc),
+		divergence: make(map[string]int),
+		runs:       make(map[*jobRun]struct{}),
+		nextRuns:   make(map[Job]time.Time),
+		lastRuns:   make(map[Job]JobRun),
 	}
 	err := s.registerTenantGroup(tenantGroupName)
 	if err != nil {
 		return nil, fmt.Errorf("register tenant group: %v", err)
 	}
+	s.hookReload()
 	return s, nil
 }
 
+// Config returns the current syncer config.
+func (s *Syncer) Config() Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config
+}
+
+// Tenants returns a snapshot of the registered tenants, keyed by name.
+func (s *Syncer) Tenants() map[string]models.IntegrationClient {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tenants := make(map[string]models.IntegrationClient, len(s.tenants))
+	for name, tenant := range s.tenants {
+		tenants[name] = tenant
+	}
+	return tenants
+}
+
+// Tenant returns the registered tenant with the given name.
+func (s *Syncer) Tenant(name string) (models.IntegrationClient, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tenant, ok := s.tenants[name]
+	return tenant, ok
+}
+
+// IntentTenant returns the intent tenant, or nil if there is none.
+func (s *Syncer) IntentTenant() models.IntegrationClient {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.intentTenant
+}
+
 // registerTenantGroup registers all tenants under the given tenant group name.
 func (s *Syncer) registerTenantGroup(tenantGroupName string) error {
 	// Load tenant gruop.
@@ -60,6 +182,7 @@ func (s *Syncer) registerTenantGroup(tenantGroupName string) error {
 	if err != nil {
 		return err
 	}
+	s.groupID = group.GetId()
 	// Set config from tenant group.
 	if err := s.loadConfigFromGroup(group); err != nil {
 		return fmt.Errorf("loading tenant group config: %v", err)
@@ -81,78 +204,124 @@ func (s *Syncer) registerTenantGroup(tenantGroupName string) error {
 			return err
 		}
 	}
-	return nil
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.selectIntent()
 }
 
 // Registers a new vendor client using the given tenant name.
 func (s *Syncer) register(tenantName string) error {
-	tenant, err := LoadClient(s.Dao, tenantName)
+	tenant, err := LoadClient(s.App, tenantName)
 	if err != nil {
 		return err
 	}
-	if s.Tenants == nil {
-		s.Tenants = make(map[string]models.IntegrationClient)
-	}
-	s.Tenants[tenantName] = tenant
-	if tenant.Tenant().Vendor == intent.Vendor {
-		s.IntentTenant = tenant
-	}
+	// The intent tenant is selected once all tenants are registered.
+	_ = s.swap(tenant.Tenant().ID, tenant)
 	return nil
 }
 
-func (s *Syncer) RefreshCredentials() error {
-	const expiryThreshold = 6 * time.Hour
+// swap replaces the tenant with the given ID by the given client, stopping
+// the daemon of the previous client and starting the daemon of the new one
+// if the syncer is running. If client is nil, the tenant is only removed.
+// Returns an error if there is no valid intent tenant after the swap.
+func (s *Syncer) swap(tenantID string, client models.IntegrationClient) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	now := time.Now()
-	oauth2Service := &oauth2.Service{Dao: s.Dao}
-	for _, tenant := range s.nonIntentTenants() {
-		cm := tenant.CredentialsManager()
-		if cm == nil {
-			log.WithFields(log.Fields{
-				"tenant": tenant.Tenant().Name,
-			}).Debugln("Skip credentials refresh, no credentials manager")
+	for name, tenant := range s.tenants {
+		if tenant.Tenant().ID != tenantID {
 			continue
 		}
-
-		// Only refresh credentials if credentials is about to expire.
-		if cm.CredentialsExpiry().Sub(now) >= expiryThreshold {
-			log.WithFields(log.Fields{
-				"tenant": tenant.Tenant().Name,
-			}).Debugln("Skip credentials refresh, not yet near expiry")
-			continue
+		if cancel, ok := s.daemons[name]; ok {
+			cancel()
+			delete(s.daemons, name)
 		}
-
-		log.WithFields(log.Fields{
-			"tenant": tenant.Tenant().Name,
-		}).Debugln("Refreshing credentials")
-		credentials, err := cm.RefreshCredentials()
-		if err != nil {
-			return fmt.Errorf("refreshing credentials for %s: %v", tenant.Tenant().Name, err)
+		delete(s.tenants, name)
+	}
+	if client != nil {
+		s.tenants[client.Tenant().Name] = client
+		if s.running {
+			s.startDaemon(client)
 		}
-		if credentials.RefreshToken == "" || credentials.AccessToken == "" {
-			return fmt.Errorf("got empty tokens! refresh_token=%q, access_token=%q", credentials.RefreshToken, credentials.AccessToken)
+	}
+	return s.selectIntent()
+}
+
+// selectIntent selects the intent tenant, which is the source of truth of the
+// stocks. This is the tenant named by the `intent_tenant` config if set, which
+// can be of any vendor. Otherwise, this is the only tenant with the DEFAULT
+// vendor. If there is no valid intent tenant, the intent tenant is unset and
+// an error is returned. Must be called with mu held.
+func (s *Syncer) selectIntent() error {
+	s.intentTenant = nil
+	if name := s.config.IntentTenant; name != "" {
+		tenant, ok := s.tenants[name]
+		if !ok {
+			return fmt.Errorf("%w: %q is not an enabled tenant of the group", ErrNoIntentTenant, name)
 		}
-		err = oauth2Service.Save(credentials)
-		if err != nil {
-			return fmt.Errorf("save credentials for %s: %v", tenant.Tenant().Name, err)
+		s.intentTenant = tenant
+		s.warnUnknownLocations()
+		return nil
+	}
+
+	var candidates []models.IntegrationClient
+	for _, tenant := range s.tenants {
+		if tenant.Tenant().Vendor == intent.Vendor {
+			candidates = append(candidates, tenant)
 		}
 	}
+	if len(candidates) == 0 {
+		return fmt.Errorf("%w: no %s tenant", ErrNoIntentTenant, intent.Vendor)
+	}
+	if len(candidates) > 1 {
+		return fmt.Errorf("%w: multiple %s tenants, set intent_tenant in the group config", ErrNoIntentTenant, intent.Vendor)
+	}
+	s.intentTenant = candidates[0]
+	s.warnUnknownLocations()
 	return nil
 }
 
+// startDaemon starts the background job of the tenant, if any. Must be called
+// with mu held.
+func (s *Syncer) startDaemon(tenant models.IntegrationClient) {
+	job := tenant.Daemon()
+	if job == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.daemons[tenant.Tenant().Name] = cancel
+
+	go func() {
+		s.Logger.WithFields(log.Fields{
+			"tenant": tenant.Tenant().Name,
+		}).Infoln("Background job has started")
+		if err := job.Start(ctx); err != nil {
+			s.Logger.WithFields(log.Fields{
+				"tenant": tenant.Tenant().Name,
+			}).Errorf("Background job has unexpectedly halted: %v", err)
+			return
+		}
+		s.Logger.WithFields(log.Fields{
+			"tenant": tenant.Tenant().Name,
+		}).Infoln("Background job has finished")
+	}()
+}
+
 func (s *Syncer) nonIntentTenants() []models.IntegrationClient {
+	intentTenant := s.IntentTenant()
 	var tenants []models.IntegrationClient
-	for _, client := range s.Tenants {
-		if client.Tenant().Vendor != intent.Vendor {
+	for _, client := range s.Tenants() {
+		if client != intentTenant {
 			tenants = append(tenants, client)
 		}
 	}
 	return tenants
 }
 
-func (s *Syncer) tenantInventory(tenantName, sellerSKU string) (*models.Item, error) {
+func (s *Syncer) tenantInventory(tenant models.IntegrationClient, sellerSKU string) (*models.Item, error) {
 	inventory, err := s.Dao.FindRecordsByExpr("tenant_inventory", dbx.HashExp{
-		"tenant":     s.Tenants[tenantName].Tenant().ID,
+		"tenant":     tenant.Tenant().ID,
 		"seller_sku": sellerSKU,
 	})
 	if err != nil {
@@ -167,13 +336,14 @@ func (s *Syncer) tenantInventory(tenantName, sellerSKU string) (*models.Item, er
 	return models.ItemFrom(inventory[0]), nil
 }
 
-func (s *Syncer) saveTenantInventory(tenantName string, item *models.Item) error {
-	tenant := s.Tenants[tenantName]
+func (s *Syncer) saveTenantInventory(ctx context.Context, tenant models.IntegrationClient, item *models.Item) error {
 	item.TenantID = tenant.Tenant().ID
 
-	if tenantName == s.IntentTenant.Tenant().Name {
-		return s.IntentTenant.SaveItem(item)
+	// The DEFAULT vendor is backed by the tenant inventory itself.
+	if tenant.Tenant().Vendor == intent.Vendor {
+		return tenant.SaveItem(ctx, item)
 	}
+	item.OnHand = item.OnHandStocks()
 
 	collection, err := s.Dao.FindCollectionByNameOrId("tenant_inventory")
 	if err != nil {
@@ -188,8 +358,8 @@ func (s *Syncer) saveTenantInventory(tenantName string, item *models.Item) error
 		return fmt.Errorf("check if already exists: %v", err)
 	}
 	if len(records) > 0 {
-		log.WithFields(log.Fields{
-			"tenant":     tenantName,
+		s.logger(ctx).WithFields(log.Fields{
+			"tenant":     tenant.Tenant().Name,
 			"seller_sku": item.SellerSKU,
 		}).Debugln("Item already exists! Updating instead...")
 		item.ID = records[0].GetId()
@@ -1,21 +1,109 @@
 package syncer
 
 import (
+	"context"
+	"errors"
 	"fmt"
 
+	"github.com/nmcapule/oclz-go/integrations/intent"
 	"github.com/nmcapule/oclz-go/integrations/models"
 
 	log "github.com/sirupsen/logrus"
 )
 
-// SyncItem tries to sync a single seller sku across all tenants.
-func (s *Syncer) SyncItem(sellerSKU string) error {
+// SyncAllItems syncs all items of the intent tenant, and stops at the first
+// item that fails to sync.
+func (s *Syncer) SyncAllItems(ctx context.Context) error {
+	intentTenant := s.IntentTenant()
+	if intentTenant == nil {
+		return ErrNoIntentTenant
+	}
+	items, err := intentTenant.CollectAllItems(ctx)
+	if err != nil {
+		return fmt.Errorf("collect all intent items: %v", err)
+	}
+	thresholds, err := s.loadStockThresholds()
+	if err != nil {
+		return fmt.Errorf("loading stock thresholds: %v", err)
+	}
+	ctx = withStockThresholds(ctx, thresholds)
+	for i, item := range items {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if s.isDraining() {
+			return ErrDraining
+		}
+		s.logger(ctx).WithFields(log.Fields{
+			"seller_sku": item.SellerSKU,
+			"index":      i,
+			"total":      len(items),
+		}).Debugln("Syncing item")
+		if err := s.SyncItem(ctx, item.SellerSKU); err != nil {
+			if ctx.Err() != nil {
+				s.recordInterrupted(ctx, item.SellerSKU, nil, 0)
+			}
+			s.count(ctx, "items_failed", 1)
+			return fmt.Errorf("syncing %q: %v", item.SellerSKU, err)
+		}
+		s.count(ctx, "items_synced", 1)
+	}
+	return nil
+}
+
+// SyncItem tries to sync a single seller sku across all tenants. The on-hand
+// stocks are reconciled from the changes on each tenant, and pushed to the
+// intent tenant. The available stocks, i.e. on-hand stocks less the reserved
+// stocks of all tenants, are pushed to the other tenants. Syncs of the same
+// seller SKU wait for each other.
+func (s *Syncer) SyncItem(ctx context.Context, sellerSKU string) error {
+	unlock := s.itemLocks.lock(sellerSKU)
+	defer unlock()
+
+	// Use a consistent snapshot, in case tenants are reloaded mid-sync.
+	tenants := s.Tenants()
+	intentTenant := s.IntentTenant()
+	config := s.Config()
+	if intentTenant == nil {
+		return ErrNoIntentTenant
+	}
+	policies, err := s.syncPolicies(sellerSKU)
+	if err != nil {
+		return fmt.Errorf("loading sync policies of %q: %v", sellerSKU, err)
+	}
+	if policy, ok := policies[intentTenant.Tenant().ID]; ok && policy.Policy == PolicyExclude {
+		s.logger(ctx).WithFields(log.Fields{
+			"seller_sku": sellerSKU,
+			"tenant":     intentTenant.Tenant().Name,
+		}).Debugln("Skip item sync, excluded from intent tenant")
+		return nil
+	}
+
 	tenantLiveItemMap := make(map[string]*models.Item)
-	var totalDelta int
-	for _, tenant := range s.Tenants {
-		cached, err := s.tenantInventory(tenant.Tenant().Name, sellerSKU)
+	deltas := make(map[string]int)
+	reserved := make(map[string]int)
+	// Tenants that failed to load or save the item, and thus diverge from the
+	// intent.
+	diverged := make(map[string]bool)
+	var totalDelta, totalReserved int
+	isIntent := func(tenant models.IntegrationClient) bool {
+		return tenant.Tenant().ID == intentTenant.Tenant().ID
+	}
+
+	for _, tenant := range tenants {
+		if tenant.Tenant().SyncMode == models.SyncObserve && !isIntent(tenant) {
+			continue
+		}
+		if policy, ok := policies[tenant.Tenant().ID]; ok && policy.Policy == PolicyExclude {
+			s.logger(ctx).WithFields(log.Fields{
+				"seller_sku": sellerSKU,
+				"tenant":     tenant.Tenant().Name,
+			}).Debugln("Skip item sync, excluded by policy")
+			continue
+		}
+		cached, err := s.tenantInventory(tenant, sellerSKU)
 		if err == models.ErrNotFound {
-			log.WithFields(log.Fields{
+			s.logger(ctx).WithFields(log.Fields{
 				"seller_sku": sellerSKU,
 				"tenant":     tenant.Tenant().Name,
 			}).Debugln("Item not found")
@@ -25,84 +113,255 @@ func (s *Syncer) SyncItem(sellerSKU string) error {
 			return fmt.Errorf("loading cached item %q from %s: %v", sellerSKU, tenant.Tenant().Name, err)
 		}
 
-		live, err := tenant.LoadItem(sellerSKU)
+		live, err := tenant.LoadItem(ctx, sellerSKU)
 		if err != nil {
-			if s.Config.ContinueOnSyncItemError {
-				log.WithFields(log.Fields{
+			// A cancelled sync stops, instead of skipping the other tenants.
+			if config.ContinueOnSyncItemError && ctx.Err() == nil {
+				s.logger(ctx).WithFields(log.Fields{
 					"seller_sku": sellerSKU,
 					"tenant":     tenant.Tenant().Name,
 					"error":      err.Error(),
 				}).Errorln("Failed to load item info. Skipping.")
+				s.count(ctx, "tenant_errors", 1)
+				diverged[tenant.Tenant().Name] = true
 				continue
 			}
-			return fmt.Errorf("loading live item %q from %s: %v", sellerSKU, tenant.Tenant().Name, err)
+			return fmt.Errorf("loading live item %q from %s: %w", sellerSKU, tenant.Tenant().Name, err)
+		}
+
+		live.ID = cached.ID
+		live.Created = cached.Created
+		tenantLiveItemMap[tenant.Tenant().Name] = live
+
+		// Changes of sink-only tenants are overwritten by the next push.
+		if !tenant.Tenant().CanPull() && !isIntent(tenant) {
+			continue
 		}
 
-		totalDelta += live.Stocks - cached.Stocks
-		if live.Stocks != cached.Stocks {
-			log.WithFields(log.Fields{
+		previous, current := cachedOnHand(tenant, cached, live), onHand(tenant, live)
+		totalDelta += current - previous
+		if current != previous {
+			deltas[tenant.Tenant().Name] = current - previous
+			s.logger(ctx).WithFields(log.Fields{
 				"seller_sku": sellerSKU,
 				"tenant":     tenant.Tenant().Name,
-				"previous":   cached.Stocks,
+				"previous":   previous,
+				"on_hand":    current,
 				"stocks":     live.Stocks,
 			}).Infoln("Pull update from live item stocks")
 		}
+		if tenant.Tenant().Vendor != intent.Vendor {
+			reserved[tenant.Tenant().Name] = live.Reserved
+			totalReserved += live.Reserved
+		}
 
-		live.ID = cached.ID
-		live.Created = cached.Created
-		tenantLiveItemMap[tenant.Tenant().Name] = live
-
-		// Pre-save the live item to the database.
-		if err := s.saveTenantInventory(tenant.Tenant().Name, live); err != nil {
+		// Pre-save the live item to the database. Sink-only tenants keep the
+		// cached item of the last push instead.
+		if err := s.saveTenantInventory(ctx, tenant, live); err != nil {
 			return fmt.Errorf("saving cached item %q from %s: %v", sellerSKU, tenant.Tenant().Name, err)
 		}
 	}
 
-	targetStocks := tenantLiveItemMap[s.IntentTenant.Tenant().Name].Stocks
-	targetStocks += totalDelta
-	if targetStocks < 0 {
-		log.Warnf("warning: %s has negative stocks, setting to 0", sellerSKU)
-		targetStocks = 0
+	intentItem, ok := tenantLiveItemMap[intentTenant.Tenant().Name]
+	if !ok {
+		s.logger(ctx).WithFields(log.Fields{
+			"seller_sku": sellerSKU,
+			"tenant":     intentTenant.Tenant().Name,
+		}).Warnln("Skip item sync, does not exist in intent tenant")
+		return nil
+	}
+	var targets map[string]int
+	// The intent stocks before and after the changes of the tenants.
+	var previousStocks, intentStocks int
+	if ic, ok := intentTenant.(*intent.Client); ok && ic.HasLocations() {
+		previousStocks = intentItem.Stocks
+		targets = s.locationTargets(ctx, ic, intentItem, tenants, deltas, reserved)
+		intentItem.Reserved = totalReserved
+		intentStocks = intentItem.Stocks
+		// Save the stocks per location, even if the total is unchanged.
+		if err := s.saveTenantInventory(ctx, intentTenant, intentItem); err != nil {
+			return fmt.Errorf("saving intent item %q: %v", sellerSKU, err)
+		}
+	} else {
+		previousStocks = intentOnHand(intentTenant, intentItem, 0, deltas)
+		targetOnHand := intentOnHand(intentTenant, intentItem, totalDelta, deltas)
+		if targetOnHand < 0 {
+			s.logger(ctx).Warnf("warning: %s has negative stocks, setting to 0", sellerSKU)
+			targetOnHand = 0
+		}
+		intentStocks = targetOnHand
+		targets = stockTargets(intentTenant, tenants, targetOnHand, reserved)
+		if intentTenant.Tenant().Vendor == intent.Vendor {
+			intentItem.Reserved = totalReserved
+		}
 	}
 
-	for _, tenant := range s.Tenants {
+	for _, tenant := range tenants {
+		if !tenant.Tenant().CanPush() && !isIntent(tenant) {
+			continue
+		}
 		live, ok := tenantLiveItemMap[tenant.Tenant().Name]
 		if !ok {
-			log.WithFields(log.Fields{
+			s.logger(ctx).WithFields(log.Fields{
 				"seller_sku": sellerSKU,
 				"tenant":     tenant.Tenant().Name,
 			}).Debugln("Skip item sync, does not exist in tenant")
 			continue
 		}
+		targetStocks := targets[tenant.Tenant().Name]
+		if policy, ok := policies[tenant.Tenant().ID]; ok {
+			switch policy.Policy {
+			case PolicyOneWay:
+				continue
+			case PolicyPin:
+				targetStocks = policy.Stocks
+			}
+		}
 		// Skip update if has the same stock as the intent tenant.
 		if live.Stocks == targetStocks {
 			continue
 		}
 
-		log.WithFields(log.Fields{
+		s.logger(ctx).WithFields(log.Fields{
 			"seller_sku": sellerSKU,
 			"tenant":     tenant.Tenant().Name,
 			"previous":   live.Stocks,
 			"stocks":     targetStocks,
 		}).Infoln("Push update to live item stocks")
 
-		live.Stocks = targetStocks
+		pushStocks(tenant, live, targetStocks)
 
-		if err := tenant.SaveItem(live); err != nil {
-			if s.Config.ContinueOnSyncItemError {
-				log.WithFields(log.Fields{
+		if err := tenant.SaveItem(ctx, live); err != nil {
+			// The tenant needs more config for this item, which should not
+			// stop the other items. It is alerted on as diverged instead.
+			if errors.Is(err, models.ErrSkipItem) {
+				s.logger(ctx).WithFields(log.Fields{
+					"seller_sku": sellerSKU,
+					"tenant":     tenant.Tenant().Name,
+					"error":      err.Error(),
+				}).Warnln("Skip item push")
+				s.count(ctx, "items_skipped", 1)
+				diverged[tenant.Tenant().Name] = true
+				continue
+			}
+			if ctx.Err() != nil {
+				s.recordInterrupted(ctx, sellerSKU, tenant, targetStocks)
+			}
+			if config.ContinueOnSyncItemError && ctx.Err() == nil {
+				s.logger(ctx).WithFields(log.Fields{
 					"seller_sku": sellerSKU,
 					"tenant":     tenant.Tenant().Name,
 					"error":      err.Error(),
 				}).Errorln("Failed to save item info. Skipping.")
+				s.count(ctx, "tenant_errors", 1)
+				diverged[tenant.Tenant().Name] = true
 				continue
 			}
 			return fmt.Errorf("saving live item %q from %s: %v", sellerSKU, tenant.Tenant().Name, err)
 		}
-		if err := s.saveTenantInventory(tenant.Tenant().Name, live); err != nil {
+		if err := s.saveTenantInventory(ctx, tenant, live); err != nil {
 			return fmt.Errorf("saving cached item %q from %s: %v", sellerSKU, tenant.Tenant().Name, err)
 		}
+		s.count(ctx, "pushes", 1)
+
+		// Check that the tenant took the push, since some vendors accept
+		// updates that they don't apply.
+		confirmed, err := tenant.LoadItem(ctx, sellerSKU)
+		if err != nil || confirmed.Stocks != targetStocks {
+			fields := log.Fields{
+				"seller_sku": sellerSKU,
+				"tenant":     tenant.Tenant().Name,
+				"stocks":     targetStocks,
+			}
+			if err != nil {
+				fields["error"] = err.Error()
+			} else {
+				fields["live"] = confirmed.Stocks
+			}
+			s.logger(ctx).WithFields(fields).Warnln("Live item stocks differ from the push")
+			diverged[tenant.Tenant().Name] = true
+		}
+	}
+
+	s.checkStockAlerts(ctx, sellerSKU, previousStocks, intentStocks)
+	for _, tenant := range tenants {
+		if isIntent(tenant) || !tenant.Tenant().CanPush() {
+			continue
+		}
+		if _, ok := tenantLiveItemMap[tenant.Tenant().Name]; !ok && !diverged[tenant.Tenant().Name] {
+			continue
+		}
+		s.checkDivergence(ctx, tenant, sellerSKU, diverged[tenant.Tenant().Name])
 	}
 
 	return nil
 }
+
+// intentOnHand returns the on-hand stocks of the intent item after applying
+// the deltas of the tenants. The live intent item already has its own delta,
+// so it is not applied twice.
+func intentOnHand(intentTenant models.IntegrationClient, intentItem *models.Item, totalDelta int, deltas map[string]int) int {
+	return onHand(intentTenant, intentItem) + totalDelta - deltas[intentTenant.Tenant().Name]
+}
+
+// stockTargets returns the stocks to push to each tenant, given the reserved
+// stocks of each tenant. The intent tenant and the DEFAULT vendor get the
+// on-hand stocks, and the others get the available stocks, i.e. less the
+// reserved stocks of all tenants. An intent tenant of another vendor gets its
+// on-hand stocks less its own reserved stocks, since its reserved stocks are
+// added back to make up its on-hand stocks. Otherwise, the reserved stocks of
+// the other tenants would be deducted from the intent on every sync.
+func stockTargets(intentTenant models.IntegrationClient, tenants map[string]models.IntegrationClient, targetOnHand int, reserved map[string]int) map[string]int {
+	var totalReserved int
+	for _, n := range reserved {
+		totalReserved += n
+	}
+	available := targetOnHand - totalReserved
+	if available < 0 {
+		available = 0
+	}
+	targets := make(map[string]int)
+	for name, tenant := range tenants {
+		switch {
+		case tenant.Tenant().Vendor == intent.Vendor:
+			targets[name] = targetOnHand
+		case tenant.Tenant().ID == intentTenant.Tenant().ID:
+			targets[name] = targetOnHand - reserved[name]
+			if targets[name] < 0 {
+				targets[name] = 0
+			}
+		default:
+			targets[name] = available
+		}
+	}
+	return targets
+}
+
+// pushStocks sets the stocks of the live item to the target stocks before it
+// is saved to the tenant. Pushing available stocks changes the on-hand stocks
+// by as much.
+func pushStocks(tenant models.IntegrationClient, live *models.Item, targetStocks int) {
+	if tenant.Tenant().Vendor != intent.Vendor {
+		live.OnHand = live.OnHandStocks() + targetStocks - live.Stocks
+	}
+	live.Stocks = targetStocks
+}
+
+// onHand returns the on-hand stocks of the item. The stocks of the DEFAULT
+// vendor are always on-hand stocks.
+func onHand(tenant models.IntegrationClient, item *models.Item) int {
+	if tenant.Tenant().Vendor == intent.Vendor {
+		return item.Stocks
+	}
+	return item.OnHandStocks()
+}
+
+// cachedOnHand returns the on-hand stocks of the cached item. Items cached
+// before on-hand stocks were recorded assume that the reserved stocks did not
+// change, so that pending orders are not counted as restocks.
+func cachedOnHand(tenant models.IntegrationClient, cached, live *models.Item) int {
+	if tenant.Tenant().Vendor != intent.Vendor && cached.OnHand == 0 && cached.Reserved == 0 {
+		return cached.Stocks + live.Reserved
+	}
+	return onHand(tenant, cached)
+}
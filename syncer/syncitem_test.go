@@ -0,0 +1,229 @@
+package syncer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nmcapule/oclz-go/integrations/intent"
+	"github.com/nmcapule/oclz-go/integrations/models"
+	"github.com/nmcapule/oclz-go/oauth2"
+)
+
+// fakeClient is a tenant that only has its tenant info.
+type fakeClient struct {
+	tenant *models.BaseTenant
+}
+
+func newFakeClient(name, vendor string) *fakeClient {
+	return &fakeClient{tenant: &models.BaseTenant{ID: name, Name: name, Vendor: vendor}}
+}
+
+func (c *fakeClient) Tenant() *models.BaseTenant {
+	return c.tenant
+}
+
+func (c *fakeClient) CollectAllItems(ctx context.Context) ([]*models.Item, error) {
+	return nil, nil
+}
+
+func (c *fakeClient) LoadItem(ctx context.Context, sku string) (*models.Item, error) {
+	return nil, models.ErrNotFound
+}
+
+func (c *fakeClient) SaveItem(ctx context.Context, item *models.Item) error {
+	return nil
+}
+
+func (c *fakeClient) CredentialsManager() oauth2.CredentialsManager {
+	return nil
+}
+
+func (c *fakeClient) Daemon() models.Daemon {
+	return nil
+}
+
+func TestIntentOnHand(t *testing.T) {
+	tests := []struct {
+		name       string
+		vendor     string
+		item       *models.Item
+		totalDelta int
+		deltas     map[string]int
+		want       int
+	}{
+		{
+			name:       "intent only",
+			vendor:     intent.Vendor,
+			item:       &models.Item{Stocks: 8},
+			totalDelta: -2,
+			deltas:     map[string]int{"master": -2},
+			want:       8,
+		},
+		{
+			name:       "intent and other tenant",
+			vendor:     intent.Vendor,
+			item:       &models.Item{Stocks: 8},
+			totalDelta: -3,
+			deltas:     map[string]int{"master": -2, "other": -1},
+			want:       7,
+		},
+		{
+			name:       "marketplace master and other tenant",
+			vendor:     "LAZADA",
+			item:       &models.Item{Stocks: 6, Reserved: 2},
+			totalDelta: -3,
+			deltas:     map[string]int{"master": -2, "other": -1},
+			want:       7,
+		},
+		{
+			name:       "no changes",
+			vendor:     "LAZADA",
+			item:       &models.Item{Stocks: 8, Reserved: 2, OnHand: 10},
+			totalDelta: 0,
+			deltas:     map[string]int{},
+			want:       10,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			master := newFakeClient("master", tc.vendor)
+			if got := intentOnHand(master, tc.item, tc.totalDelta, tc.deltas); got != tc.want {
+				t.Errorf("intentOnHand() = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestSyncCycles runs the stock math of SyncItem over a few cycles, with the
+// tenants holding their live items in between.
+func TestSyncCycles(t *testing.T) {
+	type cycle struct {
+		// live are the live items of the tenants at the start of the cycle,
+		// or nil to keep the items of the last cycle.
+		live map[string]*models.Item
+		// wantOnHand are the intent on-hand stocks.
+		wantOnHand int
+		// wantStocks are the stocks of the tenants after the push.
+		wantStocks map[string]int
+	}
+
+	tests := []struct {
+		name   string
+		master string
+		cycles []cycle
+	}{
+		{
+			name:   "marketplace master with pending orders",
+			master: "LAZADA",
+			cycles: []cycle{
+				{
+					live: map[string]*models.Item{
+						"master": {Stocks: 8, Reserved: 2},
+						"other":  {Stocks: 10},
+					},
+					wantOnHand: 10,
+					wantStocks: map[string]int{"master": 8, "other": 8},
+				},
+				{
+					// A new order on the other tenant.
+					live: map[string]*models.Item{
+						"master": {Stocks: 8, Reserved: 2},
+						"other":  {Stocks: 7, Reserved: 1},
+					},
+					wantOnHand: 10,
+					wantStocks: map[string]int{"master": 8, "other": 7},
+				},
+				{
+					wantOnHand: 10,
+					wantStocks: map[string]int{"master": 8, "other": 7},
+				},
+				{
+					// The order of the master is shipped.
+					live: map[string]*models.Item{
+						"master": {Stocks: 8},
+						"other":  {Stocks: 7, Reserved: 1},
+					},
+					wantOnHand: 8,
+					wantStocks: map[string]int{"master": 8, "other": 7},
+				},
+			},
+		},
+		{
+			name:   "default master",
+			master: intent.Vendor,
+			cycles: []cycle{
+				{
+					live: map[string]*models.Item{
+						"master": {Stocks: 10},
+						"other":  {Stocks: 7, Reserved: 1},
+					},
+					wantOnHand: 10,
+					wantStocks: map[string]int{"master": 10, "other": 9},
+				},
+				{
+					wantOnHand: 10,
+					wantStocks: map[string]int{"master": 10, "other": 9},
+				},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			master := newFakeClient("master", tc.master)
+			tenants := map[string]models.IntegrationClient{
+				"master": master,
+				"other":  newFakeClient("other", "SHOPEE"),
+			}
+			// The cached items start from the live items of the first cycle.
+			cached := make(map[string]*models.Item)
+			for name, item := range tc.cycles[0].live {
+				copied := *item
+				copied.OnHand = copied.OnHandStocks()
+				cached[name] = &copied
+			}
+			live := make(map[string]*models.Item)
+
+			for i, c := range tc.cycles {
+				for name, item := range c.live {
+					copied := *item
+					live[name] = &copied
+				}
+				deltas := make(map[string]int)
+				reserved := make(map[string]int)
+				var totalDelta int
+				for name, tenant := range tenants {
+					delta := onHand(tenant, live[name]) - cachedOnHand(tenant, cached[name], live[name])
+					totalDelta += delta
+					if delta != 0 {
+						deltas[name] = delta
+					}
+					if tenant.Tenant().Vendor != intent.Vendor {
+						reserved[name] = live[name].Reserved
+					}
+				}
+
+				targetOnHand := intentOnHand(master, live["master"], totalDelta, deltas)
+				if targetOnHand != c.wantOnHand {
+					t.Errorf("cycle %d: intent on-hand = %d, want %d", i, targetOnHand, c.wantOnHand)
+				}
+				targets := stockTargets(master, tenants, targetOnHand, reserved)
+				for name, tenant := range tenants {
+					if live[name].Stocks != targets[name] {
+						pushStocks(tenant, live[name], targets[name])
+					}
+					if live[name].Stocks != c.wantStocks[name] {
+						t.Errorf("cycle %d: %s stocks = %d, want %d", i, name, live[name].Stocks, c.wantStocks[name])
+					}
+					// The tenant only reports its available and reserved
+					// stocks on the next load.
+					copied := *live[name]
+					copied.OnHand = copied.OnHandStocks()
+					cached[name] = &copied
+					live[name].OnHand = 0
+				}
+			}
+		})
+	}
+}
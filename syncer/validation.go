@@ -0,0 +1,202 @@
+package syncer
+
+import (
+	"encoding/json"
+	"fmt"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/nmcapule/oclz-go/integrations/intent"
+	imodels "github.com/nmcapule/oclz-go/integrations/models"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+)
+
+// HookConfigValidation validates the config of tenants and tenant groups
+// before they are saved through the API, e.g. from the admin UI.
+// It also keeps each tenant group with at most one intent tenant.
+func HookConfigValidation(app core.App) {
+	app.OnRecordBeforeCreateRequest("tenants").Add(func(e *core.RecordCreateEvent) error {
+		return validateTenant(app.Dao(), e.Record)
+	})
+	app.OnRecordBeforeUpdateRequest("tenants").Add(func(e *core.RecordUpdateEvent) error {
+		return validateTenant(app.Dao(), e.Record)
+	})
+	app.OnRecordBeforeCreateRequest("tenant_groups").Add(func(e *core.RecordCreateEvent) error {
+		return validateTenantGroup(app.Dao(), e.Record)
+	})
+	app.OnRecordBeforeUpdateRequest("tenant_groups").Add(func(e *core.RecordUpdateEvent) error {
+		return validateTenantGroup(app.Dao(), e.Record)
+	})
+	app.OnRecordBeforeCreateRequest("sync_policies").Add(func(e *core.RecordCreateEvent) error {
+		return ValidateSyncPolicy(app.Dao(), e.Record)
+	})
+	app.OnRecordBeforeUpdateRequest("sync_policies").Add(func(e *core.RecordUpdateEvent) error {
+		return ValidateSyncPolicy(app.Dao(), e.Record)
+	})
+}
+
+// ValidateSyncPolicy checks that the sync policy record is the only policy of
+// its seller SKU on its tenant.
+func ValidateSyncPolicy(dao *daos.Dao, record *models.Record) error {
+	tenant, err := dao.FindRecordById("tenants", record.GetString("tenant"))
+	if err != nil {
+		return fieldError("tenant", "tenant not found")
+	}
+	if tenant.GetString("vendor") == intent.Vendor {
+		return fieldError("tenant", fmt.Sprintf("policies can't be set on %s tenants", intent.Vendor))
+	}
+	switch Policy(record.GetString("policy")) {
+	case PolicyExclude, PolicyOneWay:
+	case PolicyPin:
+		if record.GetInt("stocks") < 0 {
+			return fieldError("stocks", "pinned stocks can't be negative")
+		}
+	default:
+		return fieldError("policy", fmt.Sprintf("unsupported policy %q", record.GetString("policy")))
+	}
+	if record.GetString("seller_sku") == "" {
+		return fieldError("seller_sku", "seller SKU is required")
+	}
+	others, err := dao.FindRecordsByExpr("sync_policies", dbx.HashExp{
+		"tenant":     record.GetString("tenant"),
+		"seller_sku": record.GetString("seller_sku"),
+	})
+	if err != nil {
+		return err
+	}
+	for _, other := range others {
+		if other.GetId() != record.GetId() {
+			return fieldError("seller_sku", "tenant already has a policy for this seller SKU")
+		}
+	}
+	return nil
+}
+
+func validateTenant(dao *daos.Dao, record *models.Record) error {
+	vendor, ok := imodels.LookupVendor(record.GetString("vendor"))
+	if !ok {
+		return fieldError("vendor", fmt.Sprintf("unsupported vendor %q", record.GetString("vendor")))
+	}
+	// Unchanged secrets are still encrypted.
+	config, err := decryptConfig(vendor, json.RawMessage(record.GetString("config")))
+	if err != nil {
+		return fieldError("config", err.Error())
+	}
+	if err := vendor.ValidateConfig(config); err != nil {
+		return fieldError("config", err.Error())
+	}
+	if raw := record.GetString("locations"); raw != "" {
+		var locations []string
+		if err := json.Unmarshal([]byte(raw), &locations); err != nil {
+			return fieldError("locations", "expected a list of location names")
+		}
+	}
+	return validateTenantIntent(dao, record)
+}
+
+// validateTenantIntent checks that the tenant change keeps its group with
+// exactly one intent tenant.
+func validateTenantIntent(dao *daos.Dao, record *models.Record) error {
+	if record.GetString("tenant_group") == "" {
+		return nil
+	}
+	group, err := dao.FindRecordById("tenant_groups", record.GetString("tenant_group"))
+	if err != nil {
+		return fieldError("tenant_group", err.Error())
+	}
+	config, err := ConfigFrom(group)
+	if err != nil {
+		return nil
+	}
+
+	if config.IntentTenant != "" {
+		if record.IsNew() || record.OriginalCopy().GetString("name") != config.IntentTenant {
+			return nil
+		}
+		if record.GetString("name") != config.IntentTenant {
+			return fieldError("name", fmt.Sprintf("tenant is the intent tenant of group %q, update the group config first", group.GetString("name")))
+		}
+		if !record.GetBool("enable") {
+			return fieldError("enable", fmt.Sprintf("tenant is the intent tenant of group %q, update the group config first", group.GetString("name")))
+		}
+		return nil
+	}
+
+	if record.GetString("vendor") != intent.Vendor || !record.GetBool("enable") {
+		return nil
+	}
+	others, err := intentCandidates(dao, group.GetId())
+	if err != nil {
+		return err
+	}
+	for _, other := range others {
+		if other.GetId() != record.GetId() {
+			return fieldError("vendor", fmt.Sprintf("group %q already has the %s tenant %q, set intent_tenant in the group config to choose one", group.GetString("name"), intent.Vendor, other.GetString("name")))
+		}
+	}
+	return nil
+}
+
+func validateTenantGroup(dao *daos.Dao, record *models.Record) error {
+	raw := record.GetString("config")
+	if raw == "" {
+		return validateTenantGroupIntent(dao, record, Config{})
+	}
+	if err := ConfigSchema.Validate([]byte(raw)); err != nil {
+		return fieldError("config", err.Error())
+	}
+	config, err := ConfigFrom(record)
+	if err != nil {
+		return fieldError("config", err.Error())
+	}
+	if _, err := config.Schedules.Plans(); err != nil {
+		return fieldError("config", fmt.Sprintf("schedules: %v", err))
+	}
+	return validateTenantGroupIntent(dao, record, config)
+}
+
+// validateTenantGroupIntent checks that the intent tenant of the group is an
+// enabled tenant of the group, or that it is not ambiguous if not set. New
+// groups have no tenants yet, so they can't have an intent tenant.
+func validateTenantGroupIntent(dao *daos.Dao, record *models.Record, config Config) error {
+	if config.IntentTenant != "" {
+		tenant, err := dao.FindFirstRecordByData("tenants", "name", config.IntentTenant)
+		if err != nil || tenant.GetString("tenant_group") != record.GetId() {
+			return fieldError("config", fmt.Sprintf("intent_tenant: %q is not a tenant of this group", config.IntentTenant))
+		}
+		if !tenant.GetBool("enable") {
+			return fieldError("config", fmt.Sprintf("intent_tenant: %q is not enabled", config.IntentTenant))
+		}
+		return nil
+	}
+	if record.IsNew() {
+		return nil
+	}
+	candidates, err := intentCandidates(dao, record.GetId())
+	if err != nil {
+		return err
+	}
+	if len(candidates) > 1 {
+		return fieldError("config", fmt.Sprintf("intent_tenant: group has multiple %s tenants, choose one", intent.Vendor))
+	}
+	return nil
+}
+
+// intentCandidates returns the enabled DEFAULT vendor tenants of the group.
+func intentCandidates(dao *daos.Dao, groupID string) ([]*models.Record, error) {
+	return dao.FindRecordsByExpr("tenants", dbx.HashExp{
+		"tenant_group": groupID,
+		"vendor":       intent.Vendor,
+		"enable":       true,
+	})
+}
+
+// fieldError returns an API error that the admin UI shows under the field.
+func fieldError(field, message string) error {
+	return apis.NewBadRequestError(fmt.Sprintf("Invalid %s.", field), validation.Errors{
+		field: validation.NewError("validation_invalid_"+field, message),
+	})
+}
@@ -0,0 +1,347 @@
+// Package jsonschema generates JSON schemas from Go config structs and
+// validates JSON documents against them. Only the subset of JSON schema
+// needed by tenant configs is supported.
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Schema is a JSON schema.
+type Schema struct {
+	Type                 string             `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties any                `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	MinLength            int                `json:"minLength,omitempty"`
+	Minimum              *int64             `json:"minimum,omitempty"`
+	// WriteOnly marks secrets, e.g. passwords and API keys.
+	WriteOnly bool `json:"writeOnly,omitempty"`
+}
+
+// MarshalJSON marshals the schema. AdditionalProperties is marshaled
+// explicitly, since omitempty would drop a `false` depending on the encoder.
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	type schema Schema
+	out := struct {
+		*schema
+		AdditionalProperties json.RawMessage `json:"additionalProperties,omitempty"`
+	}{schema: (*schema)(s)}
+	if s.AdditionalProperties != nil {
+		additional, err := json.Marshal(s.AdditionalProperties)
+		if err != nil {
+			return nil, err
+		}
+		out.AdditionalProperties = additional
+	}
+	return json.Marshal(out)
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+// Reflect generates the schema of the given value, which is usually a pointer
+// to a config struct. Struct fields follow their `json` tags. Fields tagged
+// with `jsonschema:"required"` are required, fields tagged with
+// `jsonschema:"secret"` are write-only, and unknown properties are not
+// allowed. Options can be combined, e.g. `jsonschema:"required,secret"`.
+// Required strings can't be empty, and required integers, e.g. IDs, must be
+// positive.
+func Reflect(v any) *Schema {
+	return reflectType(reflect.TypeOf(v))
+}
+
+func reflectType(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == rawMessageType {
+		return &Schema{}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: reflectType(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: reflectType(t.Elem())}
+	case reflect.Struct:
+		s := &Schema{
+			Type:                 "object",
+			Properties:           make(map[string]*Schema),
+			AdditionalProperties: false,
+		}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			prop := reflectType(field.Type)
+			for _, option := range strings.Split(field.Tag.Get("jsonschema"), ",") {
+				switch option {
+				case "required":
+					s.Required = append(s.Required, name)
+					switch prop.Type {
+					case "string":
+						prop.MinLength = 1
+					case "integer":
+						one := int64(1)
+						prop.Minimum = &one
+					}
+				case "secret":
+					prop.WriteOnly = true
+				}
+			}
+			s.Properties[name] = prop
+		}
+		return s
+	default:
+		return &Schema{}
+	}
+}
+
+// FieldError is a validation error of a single field.
+type FieldError struct {
+	Path    string
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// Errors is a list of field validation errors.
+type Errors []*FieldError
+
+func (e Errors) Error() string {
+	var messages []string
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Validate validates the JSON document against the schema. Returns Errors if
+// the document is invalid.
+func (s *Schema) Validate(data []byte) error {
+	var v any
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return Errors{{Message: fmt.Sprintf("invalid JSON: %v", err)}}
+	}
+	var errs Errors
+	if v == nil && len(s.Required) > 0 {
+		errs = append(errs, &FieldError{Message: "expected object, got null"})
+	}
+	s.validate("", v, &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validate validates the value against the schema. Null is allowed for
+// values of any type, same as encoding/json, which leaves them as is, unless
+// they are required properties, which are checked by their object.
+func (s *Schema) validate(path string, v any, errs *Errors) {
+	fail := func(format string, args ...any) {
+		*errs = append(*errs, &FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+	if v == nil {
+		return
+	}
+	switch s.Type {
+	case "":
+		return
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			fail("expected boolean, got %s", typeName(v))
+		}
+	case "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			fail("expected integer, got %s", typeName(v))
+		} else if i, err := n.Int64(); err != nil {
+			fail("expected integer, got %s", n)
+		} else if s.Minimum != nil && i < *s.Minimum {
+			fail("must be at least %d", *s.Minimum)
+		}
+	case "number":
+		if _, ok := v.(json.Number); !ok {
+			fail("expected number, got %s", typeName(v))
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			fail("expected string, got %s", typeName(v))
+		} else if len(str) < s.MinLength {
+			fail("can't be empty")
+		}
+	case "array":
+		items, ok := v.([]any)
+		if !ok {
+			fail("expected array, got %s", typeName(v))
+			return
+		}
+		for i, item := range items {
+			s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, errs)
+		}
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			fail("expected object, got %s", typeName(v))
+			return
+		}
+		for _, name := range s.Required {
+			if obj[name] == nil {
+				*errs = append(*errs, &FieldError{Path: join(path, name), Message: "is required"})
+			}
+		}
+		var keys []string
+		for key := range obj {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if prop, ok := s.Properties[key]; ok {
+				prop.validate(join(path, key), obj[key], errs)
+				continue
+			}
+			switch additional := s.AdditionalProperties.(type) {
+			case *Schema:
+				additional.validate(join(path, key), obj[key], errs)
+			case bool:
+				if !additional {
+					*errs = append(*errs, &FieldError{Path: join(path, key), Message: "unknown property"})
+				}
+			}
+		}
+	}
+}
+
+// HasWriteOnly returns true if the schema has write-only properties.
+func (s *Schema) HasWriteOnly() bool {
+	if s.WriteOnly {
+		return true
+	}
+	for _, prop := range s.Properties {
+		if prop.HasWriteOnly() {
+			return true
+		}
+	}
+	if s.Items != nil && s.Items.HasWriteOnly() {
+		return true
+	}
+	if additional, ok := s.AdditionalProperties.(*Schema); ok {
+		return additional.HasWriteOnly()
+	}
+	return false
+}
+
+// MapWriteOnly replaces the write-only string values of the JSON document by
+// the result of fn, e.g. to encrypt them. Values that don't match the schema
+// are left as is.
+func (s *Schema) MapWriteOnly(data []byte, fn func(string) (string, error)) ([]byte, error) {
+	if len(data) == 0 || !s.HasWriteOnly() {
+		return data, nil
+	}
+	var v any
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	v, err := s.mapWriteOnly("", v, fn)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+func (s *Schema) mapWriteOnly(path string, v any, fn func(string) (string, error)) (any, error) {
+	if str, ok := v.(string); ok && s.WriteOnly {
+		mapped, err := fn(str)
+		if err != nil {
+			return nil, &FieldError{Path: path, Message: err.Error()}
+		}
+		return mapped, nil
+	}
+	switch v := v.(type) {
+	case []any:
+		if s.Items == nil {
+			return v, nil
+		}
+		for i, item := range v {
+			mapped, err := s.Items.mapWriteOnly(fmt.Sprintf("%s[%d]", path, i), item, fn)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = mapped
+		}
+	case map[string]any:
+		for key, value := range v {
+			prop, ok := s.Properties[key]
+			if !ok {
+				prop, _ = s.AdditionalProperties.(*Schema)
+			}
+			if prop == nil {
+				continue
+			}
+			mapped, err := prop.mapWriteOnly(join(path, key), value, fn)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = mapped
+		}
+	}
+	return v, nil
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func typeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
@@ -0,0 +1,229 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+type testAuth struct {
+	Token string `json:"token" jsonschema:"required,secret"`
+}
+
+type testConfig struct {
+	Domain  string            `json:"domain" jsonschema:"required"`
+	ShopID  int64             `json:"shop_id" jsonschema:"required"`
+	Note    string            `json:"note"`
+	Enabled bool              `json:"enabled"`
+	Ratio   float64           `json:"ratio"`
+	Tags    []string          `json:"tags"`
+	Headers map[string]string `json:"headers"`
+	Auth    *testAuth         `json:"auth"`
+	Extra   json.RawMessage   `json:"extra"`
+	Ignored string            `json:"-"`
+}
+
+func TestMarshal(t *testing.T) {
+	data, err := json.Marshal(Reflect(&testConfig{}))
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	got := gjson.ParseBytes(data)
+	for path, want := range map[string]string{
+		"type":                        `"object"`,
+		"additionalProperties":        "false",
+		"required":                    `["domain","shop_id"]`,
+		"properties.domain.minLength": "1",
+		"properties.shop_id.minimum":  "1",
+		"properties.headers.additionalProperties":    `{"type":"string"}`,
+		"properties.auth.additionalProperties":       "false",
+		"properties.auth.properties.token.writeOnly": "true",
+	} {
+		if got := got.Get(path).Raw; got != want {
+			t.Errorf("%s = %s, want %s", path, got, want)
+		}
+	}
+	if got.Get("properties.Ignored").Exists() {
+		t.Errorf("properties.Ignored exists, want it skipped")
+	}
+	if got.Get("properties.note.minLength").Exists() {
+		t.Errorf("properties.note.minLength exists, want it only on required fields")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	schema := Reflect(&testConfig{})
+
+	tests := []struct {
+		name  string
+		input string
+		// wantPaths are the paths of the expected field errors.
+		wantPaths []string
+	}{
+		{
+			name:  "valid",
+			input: `{"domain": "a.com", "shop_id": 1, "note": "x", "enabled": true, "ratio": 0.5, "tags": ["a"], "headers": {"k": "v"}, "auth": {"token": "t"}, "extra": [1, {"a": null}]}`,
+		},
+		{
+			name:  "null optional fields",
+			input: `{"domain": "a.com", "shop_id": 1, "note": null, "tags": null, "headers": null}`,
+		},
+		{
+			name:  "null optional object with required fields",
+			input: `{"domain": "a.com", "shop_id": 1, "auth": null}`,
+		},
+		{
+			name:      "missing required fields",
+			input:     `{}`,
+			wantPaths: []string{"domain", "shop_id"},
+		},
+		{
+			name:      "empty required fields",
+			input:     `{"domain": "", "shop_id": 0}`,
+			wantPaths: []string{"domain", "shop_id"},
+		},
+		{
+			name:      "null required string",
+			input:     `{"domain": null, "shop_id": 1}`,
+			wantPaths: []string{"domain"},
+		},
+		{
+			name:      "wrong types",
+			input:     `{"domain": 1, "shop_id": "1", "enabled": "yes", "ratio": "1", "tags": "a"}`,
+			wantPaths: []string{"domain", "enabled", "ratio", "shop_id", "tags"},
+		},
+		{
+			name:      "fractional integer",
+			input:     `{"domain": "a.com", "shop_id": 1.5}`,
+			wantPaths: []string{"shop_id"},
+		},
+		{
+			name:      "nested errors",
+			input:     `{"domain": "a.com", "shop_id": 1, "tags": ["a", 1], "headers": {"k": 1}, "auth": {}}`,
+			wantPaths: []string{"auth.token", "headers.k", "tags[1]"},
+		},
+		{
+			name:      "unknown properties",
+			input:     `{"domain": "a.com", "shop_id": 1, "Ignored": "x", "auth": {"token": "t", "user": "u"}}`,
+			wantPaths: []string{"Ignored", "auth.user"},
+		},
+		{
+			name:      "null document",
+			input:     `null`,
+			wantPaths: []string{""},
+		},
+		{
+			name:      "not an object",
+			input:     `[]`,
+			wantPaths: []string{""},
+		},
+		{
+			name:      "invalid JSON",
+			input:     `{`,
+			wantPaths: []string{""},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := schema.Validate([]byte(tc.input))
+			var errs Errors
+			if err != nil && !errors.As(err, &errs) {
+				t.Fatalf("got error %T, want Errors", err)
+			}
+			var paths []string
+			for _, e := range errs {
+				paths = append(paths, e.Path)
+			}
+			if len(paths) != len(tc.wantPaths) {
+				t.Fatalf("got errors %v, want paths %q", err, tc.wantPaths)
+			}
+			for i := range paths {
+				if paths[i] != tc.wantPaths[i] {
+					t.Fatalf("got errors %v, want paths %q", err, tc.wantPaths)
+				}
+			}
+		})
+	}
+}
+
+type testTypes struct {
+	Bool    bool              `json:"bool"`
+	Int     int               `json:"int"`
+	Float   float64           `json:"float"`
+	String  string            `json:"string"`
+	Slice   []string          `json:"slice"`
+	Map     map[string]string `json:"map"`
+	Struct  *testAuth         `json:"struct"`
+	Any     json.RawMessage   `json:"any"`
+	RBool   bool              `json:"r_bool" jsonschema:"required"`
+	RInt    int               `json:"r_int" jsonschema:"required"`
+	RFloat  float64           `json:"r_float" jsonschema:"required"`
+	RString string            `json:"r_string" jsonschema:"required"`
+	RSlice  []string          `json:"r_slice" jsonschema:"required"`
+	RMap    map[string]string `json:"r_map" jsonschema:"required"`
+	RStruct *testAuth         `json:"r_struct" jsonschema:"required"`
+	RAny    json.RawMessage   `json:"r_any" jsonschema:"required"`
+}
+
+func TestValidateNull(t *testing.T) {
+	schema := Reflect(&testTypes{})
+	valid := map[string]string{
+		"r_bool":   `true`,
+		"r_int":    `1`,
+		"r_float":  `0.5`,
+		"r_string": `"a"`,
+		"r_slice":  `["a"]`,
+		"r_map":    `{"k": "v"}`,
+		"r_struct": `{"token": "t"}`,
+		"r_any":    `1`,
+	}
+
+	for _, name := range []string{"bool", "int", "float", "string", "slice", "map", "struct", "any"} {
+		// Null is allowed for optional properties, same as encoding/json.
+		t.Run(name, func(t *testing.T) {
+			input := map[string]json.RawMessage{name: json.RawMessage(`null`)}
+			for key, value := range valid {
+				input[key] = json.RawMessage(value)
+			}
+			data, _ := json.Marshal(input)
+			if err := schema.Validate(data); err != nil {
+				t.Errorf("Validate(%s) = %v, want no errors", data, err)
+			}
+		})
+
+		// Null is rejected for required properties.
+		t.Run("r_"+name, func(t *testing.T) {
+			input := map[string]json.RawMessage{"r_" + name: json.RawMessage(`null`)}
+			for key, value := range valid {
+				if key != "r_"+name {
+					input[key] = json.RawMessage(value)
+				}
+			}
+			data, _ := json.Marshal(input)
+			err := schema.Validate(data)
+			var errs Errors
+			if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Path != "r_"+name || errs[0].Message != "is required" {
+				t.Errorf("Validate(%s) = %v, want r_%s: is required", data, err, name)
+			}
+		})
+	}
+}
+
+func TestMapWriteOnly(t *testing.T) {
+	schema := Reflect(&testConfig{})
+	got, err := schema.MapWriteOnly([]byte(`{"domain": "a.com", "auth": {"token": "t"}}`), func(s string) (string, error) {
+		return "enc:" + s, nil
+	})
+	if err != nil {
+		t.Fatalf("MapWriteOnly: %v", err)
+	}
+	if token := gjson.GetBytes(got, "auth.token").String(); token != "enc:t" {
+		t.Errorf("auth.token = %q, want %q", token, "enc:t")
+	}
+	if domain := gjson.GetBytes(got, "domain").String(); domain != "a.com" {
+		t.Errorf("domain = %q, want it unchanged", domain)
+	}
+}
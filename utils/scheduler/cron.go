@@ -0,0 +1,246 @@
+package scheduler
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule returns the run times of a job.
+type Schedule interface {
+	// Next returns the first run time after t.
+	Next(t time.Time) time.Time
+}
+
+// every runs at a fixed interval.
+type every time.Duration
+
+func (e every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
+
+// cron runs at the minutes matching all of its fields.
+type cron struct {
+	minutes, hours, days, months, weekdays uint64
+	// Days of the month and of the week match if either one matches, unless
+	// one of them is `*`, same as the standard cron.
+	anyDay, anyWeekday bool
+}
+
+// maxCronYears bounds the search of the next run time, e.g. for Feb 30.
+const maxCronYears = 5
+
+func (c *cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(maxCronYears, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case c.months&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hours&(1<<uint(t.Hour())) == 0:
+			// Step by the wall clock, since Truncate rounds the absolute time,
+			// which is off in zones with half-hour offsets.
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minutes&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (c *cron) matchDay(t time.Time) bool {
+	day := c.days&(1<<uint(t.Day())) != 0
+	weekday := c.weekdays&(1<<uint(t.Weekday())) != 0
+	switch {
+	case c.anyDay && c.anyWeekday:
+		return true
+	case c.anyDay:
+		return weekday
+	case c.anyWeekday:
+		return day
+	default:
+		return day || weekday
+	}
+}
+
+var cronAliases = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseSchedule parses a standard 5-field cron expression, i.e. minute, hour,
+// day of month, month and day of week, e.g. "*/30 6-22 * * 1-5". Aliases like
+// "@daily" and fixed intervals like "@every 90m" are also supported.
+func ParseSchedule(expr string) (Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "@every ") {
+		interval, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(expr, "@every ")))
+		if err != nil {
+			return nil, fmt.Errorf("%q: %v", expr, err)
+		}
+		if interval < time.Minute {
+			return nil, fmt.Errorf("%q: interval must be at least 1m", expr)
+		}
+		return every(interval), nil
+	}
+	if alias, ok := cronAliases[expr]; ok {
+		expr = alias
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%q: expected 5 fields, got %d", expr, len(fields))
+	}
+	c := &cron{
+		anyDay:     fields[2] == "*",
+		anyWeekday: fields[4] == "*",
+	}
+	for i, f := range []struct {
+		bits     *uint64
+		min, max int
+	}{
+		{&c.minutes, 0, 59},
+		{&c.hours, 0, 23},
+		{&c.days, 1, 31},
+		{&c.months, 1, 12},
+		{&c.weekdays, 0, 7},
+	} {
+		bits, err := parseField(fields[i], f.min, f.max)
+		if err != nil {
+			return nil, fmt.Errorf("%q: field %d: %v", expr, i+1, err)
+		}
+		*f.bits = bits
+	}
+	// Sunday is both 0 and 7.
+	if c.weekdays&(1<<7) != 0 {
+		c.weekdays |= 1
+	}
+	return c, nil
+}
+
+// parseField parses a comma-separated list of `*`, values and ranges, with
+// optional steps, e.g. "*/15" or "1-5,10".
+func parseField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepText)
+			}
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			loText, hiText, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(loText); err != nil {
+				return 0, fmt.Errorf("invalid value %q", loText)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(hiText); err != nil {
+					return 0, fmt.Errorf("invalid value %q", hiText)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// QuietHours is a daily window when jobs don't run, e.g. "22:00" to "06:00".
+// A window that ends before it starts wraps around midnight.
+type QuietHours struct {
+	Start, End time.Duration
+}
+
+// ParseQuietHours parses the start and end of the window as "HH:MM".
+func ParseQuietHours(start, end string) (*QuietHours, error) {
+	s, err := parseClock(start)
+	if err != nil {
+		return nil, err
+	}
+	e, err := parseClock(end)
+	if err != nil {
+		return nil, err
+	}
+	return &QuietHours{Start: s, End: e}, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// After returns t if it is not within the quiet hours, or else the end of
+// the quiet hours.
+func (q *QuietHours) After(t time.Time) time.Time {
+	if q == nil || q.Start == q.End {
+		return t
+	}
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	clock := t.Sub(midnight)
+	switch {
+	case q.Start < q.End && clock >= q.Start && clock < q.End:
+		return midnight.Add(q.End)
+	case q.Start > q.End && clock >= q.Start:
+		return midnight.AddDate(0, 0, 1).Add(q.End)
+	case q.Start > q.End && clock < q.End:
+		return midnight.Add(q.End)
+	}
+	return t
+}
+
+// Plan is a schedule with the options of when its jobs run.
+type Plan struct {
+	Schedule Schedule
+	// Jitter is the maximum random delay of each run, so that jobs of many
+	// tenant groups don't hit the vendors all at once.
+	Jitter time.Duration
+	// Quiet are the quiet hours, if any. Runs within the quiet hours are
+	// delayed until their end.
+	Quiet *QuietHours
+	// Location is the time zone of the schedule and the quiet hours.
+	// Defaults to the local time zone.
+	Location *time.Location
+}
+
+// Next returns the first run time after t. Returns the zero time if the
+// schedule never runs.
+func (p *Plan) Next(t time.Time) time.Time {
+	if p.Location != nil {
+		t = t.In(p.Location)
+	}
+	next := p.Schedule.Next(t)
+	if next.IsZero() {
+		return next
+	}
+	next = p.Quiet.After(next)
+	if p.Jitter > 0 {
+		next = next.Add(time.Duration(rand.Int63n(int64(p.Jitter))))
+	}
+	return next
+}
@@ -0,0 +1,256 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+// monday is Monday, 2024-01-15 10:07.
+var monday = time.Date(2024, time.January, 15, 10, 7, 0, 0, time.UTC)
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"1- * * * *",
+		"@every 30s",
+		"@every soon",
+		"@often",
+	} {
+		if _, err := ParseSchedule(expr); err == nil {
+			t.Errorf("ParseSchedule(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{
+			name: "every minute",
+			expr: "* * * * *",
+			from: monday,
+			want: time.Date(2024, time.January, 15, 10, 8, 0, 0, time.UTC),
+		},
+		{
+			name: "strictly after",
+			expr: "0 12 * * *",
+			from: time.Date(2024, time.January, 15, 12, 0, 0, 0, time.UTC),
+			want: time.Date(2024, time.January, 16, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "step",
+			expr: "*/15 * * * *",
+			from: monday,
+			want: time.Date(2024, time.January, 15, 10, 15, 0, 0, time.UTC),
+		},
+		{
+			name: "range with step",
+			expr: "0 9-17/2 * * *",
+			from: monday,
+			want: time.Date(2024, time.January, 15, 11, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "value with step",
+			expr: "0 20/2 * * *",
+			from: monday,
+			want: time.Date(2024, time.January, 15, 20, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "list",
+			expr: "5,50 * * * *",
+			from: monday,
+			want: time.Date(2024, time.January, 15, 10, 50, 0, 0, time.UTC),
+		},
+		{
+			name: "weekdays",
+			expr: "30 6 * * 1-5",
+			from: monday,
+			want: time.Date(2024, time.January, 16, 6, 30, 0, 0, time.UTC),
+		},
+		{
+			name: "weekdays over the weekend",
+			expr: "30 6 * * 1-5",
+			from: time.Date(2024, time.January, 19, 12, 0, 0, 0, time.UTC),
+			want: time.Date(2024, time.January, 22, 6, 30, 0, 0, time.UTC),
+		},
+		{
+			name: "sunday as 7",
+			expr: "0 0 * * 7",
+			from: monday,
+			want: time.Date(2024, time.January, 21, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "day of month",
+			expr: "0 0 1 * *",
+			from: monday,
+			want: time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "day of month or day of week",
+			expr: "0 0 13 * 5",
+			from: monday,
+			want: time.Date(2024, time.January, 19, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "day of month or day of week, day of month first",
+			expr: "0 0 16 * 5",
+			from: monday,
+			want: time.Date(2024, time.January, 16, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "day of month with any day of week",
+			expr: "0 0 20 * *",
+			from: monday,
+			want: time.Date(2024, time.January, 20, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "month",
+			expr: "0 0 1 3 *",
+			from: monday,
+			want: time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "year wrap",
+			expr: "0 0 1 1 *",
+			from: monday,
+			want: time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "leap day",
+			expr: "0 0 29 2 *",
+			from: time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "impossible date",
+			expr: "0 0 30 2 *",
+			from: monday,
+		},
+		{
+			name: "impossible date in april",
+			expr: "0 0 31 4 *",
+			from: monday,
+		},
+		{
+			name: "alias",
+			expr: "@hourly",
+			from: monday,
+			want: time.Date(2024, time.January, 15, 11, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "interval",
+			expr: "@every 90m",
+			from: monday,
+			want: time.Date(2024, time.January, 15, 11, 37, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			schedule, err := ParseSchedule(tc.expr)
+			if err != nil {
+				t.Fatalf("ParseSchedule(%q): %v", tc.expr, err)
+			}
+			if got := schedule.Next(tc.from); !got.Equal(tc.want) {
+				t.Errorf("Next(%v) = %v, want %v", tc.from, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestScheduleNextHalfHourZone(t *testing.T) {
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skipf("loading time zone: %v", err)
+	}
+	schedule, err := ParseSchedule("0 11 * * *")
+	if err != nil {
+		t.Fatalf("ParseSchedule: %v", err)
+	}
+	from := time.Date(2024, time.January, 15, 9, 20, 0, 0, kolkata)
+	want := time.Date(2024, time.January, 15, 11, 0, 0, 0, kolkata)
+	if got := schedule.Next(from); !got.Equal(want) {
+		t.Errorf("Next(%v) = %v, want %v", from, got, want)
+	}
+}
+
+func TestQuietHoursAfter(t *testing.T) {
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, time.January, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name       string
+		start, end string
+		from       time.Time
+		want       time.Time
+	}{
+		{name: "before", start: "01:00", end: "03:00", from: at(15, 0, 59), want: at(15, 0, 59)},
+		{name: "at start", start: "01:00", end: "03:00", from: at(15, 1, 0), want: at(15, 3, 0)},
+		{name: "within", start: "01:00", end: "03:00", from: at(15, 2, 30), want: at(15, 3, 0)},
+		{name: "at end", start: "01:00", end: "03:00", from: at(15, 3, 0), want: at(15, 3, 0)},
+		{name: "wrap before midnight", start: "22:00", end: "06:00", from: at(15, 23, 0), want: at(16, 6, 0)},
+		{name: "wrap after midnight", start: "22:00", end: "06:00", from: at(15, 3, 0), want: at(15, 6, 0)},
+		{name: "wrap outside", start: "22:00", end: "06:00", from: at(15, 12, 0), want: at(15, 12, 0)},
+		{name: "wrap at end", start: "22:00", end: "06:00", from: at(15, 6, 0), want: at(15, 6, 0)},
+		{name: "empty", start: "06:00", end: "06:00", from: at(15, 6, 0), want: at(15, 6, 0)},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			quiet, err := ParseQuietHours(tc.start, tc.end)
+			if err != nil {
+				t.Fatalf("ParseQuietHours(%q, %q): %v", tc.start, tc.end, err)
+			}
+			if got := quiet.After(tc.from); !got.Equal(tc.want) {
+				t.Errorf("After(%v) = %v, want %v", tc.from, got, tc.want)
+			}
+		})
+	}
+
+	var none *QuietHours
+	if got := none.After(at(15, 3, 0)); !got.Equal(at(15, 3, 0)) {
+		t.Errorf("nil After = %v, want it as is", got)
+	}
+	for _, clock := range []string{"", "6", "24:00", "12:60", "noon"} {
+		if _, err := ParseQuietHours(clock, "06:00"); err == nil {
+			t.Errorf("ParseQuietHours(%q) succeeded, want an error", clock)
+		}
+	}
+}
+
+func TestPlanNext(t *testing.T) {
+	manila, err := time.LoadLocation("Asia/Manila")
+	if err != nil {
+		t.Skipf("loading time zone: %v", err)
+	}
+	schedule, err := ParseSchedule("0 * * * *")
+	if err != nil {
+		t.Fatalf("ParseSchedule: %v", err)
+	}
+	plan := &Plan{
+		Schedule: schedule,
+		Quiet:    &QuietHours{Start: 22 * time.Hour, End: 6 * time.Hour},
+		Location: manila,
+	}
+	// 13:30 UTC is 21:30 in Manila, so the 22:00 run is quiet until 06:00.
+	from := time.Date(2024, time.January, 15, 13, 30, 0, 0, time.UTC)
+	want := time.Date(2024, time.January, 16, 6, 0, 0, 0, manila)
+	if got := plan.Next(from); !got.Equal(want) {
+		t.Errorf("Next(%v) = %v, want %v", from, got, want)
+	}
+}
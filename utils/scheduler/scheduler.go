@@ -1,6 +1,7 @@
 package scheduler
 
 import (
+	"context"
 	"fmt"
 	"time"
 )
@@ -10,28 +11,101 @@ type LoopConfig struct {
 	RetryWait   time.Duration
 }
 
-func Loop(fn func(quit chan struct{}), config LoopConfig) error {
-	time.Sleep(config.InitialWait)
-	quit := make(chan struct{})
-	fn(quit)
+// Loop calls fn right after the initial wait, and then on every retry wait,
+// until the context is done. The context is passed to fn, so that a run in
+// progress can be cancelled too.
+func Loop(ctx context.Context, fn func(ctx context.Context), config LoopConfig) error {
+	select {
+	case <-time.After(config.InitialWait):
+	case <-ctx.Done():
+		return nil
+	}
+	fn(ctx)
 	ticker := time.NewTicker(config.RetryWait)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-ticker.C:
-			fn(quit)
-		case <-quit:
+			fn(ctx)
+		case <-ctx.Done():
 			return nil
 		}
 	}
 }
 
+// DefaultRecheck is how often Run reloads its plan by default.
+const DefaultRecheck = time.Minute
+
+type RunConfig struct {
+	// RunOnStart calls fn right away, before following the plan.
+	RunOnStart bool
+	// Recheck is how often the plan is reloaded while waiting for the next
+	// run. Defaults to DefaultRecheck.
+	Recheck time.Duration
+	// OnNext is called with the next run time whenever it is planned, or
+	// with the zero time if the plan never runs.
+	OnNext func(next time.Time)
+}
+
+// Run calls fn on the run times of the plan until the context is done. The
+// plan is reloaded on every recheck, so that it can be changed at runtime; a
+// changed plan is followed from the last run, but never runs in the past.
+func Run(ctx context.Context, plan func() *Plan, fn func(ctx context.Context), config RunConfig) error {
+	recheck := config.Recheck
+	if recheck <= 0 {
+		recheck = DefaultRecheck
+	}
+	onNext := config.OnNext
+	if onNext == nil {
+		onNext = func(time.Time) {}
+	}
+	if config.RunOnStart && ctx.Err() == nil {
+		fn(ctx)
+	}
+
+	last := time.Now()
+	current := plan()
+	next := current.Next(last)
+	onNext(next)
+	for {
+		wait := recheck
+		if until := time.Until(next); !next.IsZero() && until < wait {
+			wait = until
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil
+		}
+
+		if p := plan(); p != current {
+			current = p
+			now := time.Now()
+			if next = current.Next(last); !next.IsZero() && next.Before(now) {
+				next = current.Next(now)
+			}
+			onNext(next)
+			continue
+		}
+		if next.IsZero() || time.Now().Before(next) {
+			continue
+		}
+		fn(ctx)
+		last = time.Now()
+		next = current.Next(last)
+		onNext(next)
+	}
+}
+
 type RetryConfig struct {
 	RetryWait       time.Duration
 	RetryLimit      int
 	BackoffMultiply float64
 }
 
-func Retry(fn func() bool, config RetryConfig) error {
+// Retry calls fn until it returns true, waiting longer after each failure.
+// Stops early with the context error once the context is done.
+func Retry(ctx context.Context, fn func() bool, config RetryConfig) error {
 	limit := config.RetryLimit
 	wait := config.RetryWait
 	for limit > 0 {
@@ -39,7 +113,11 @@ func Retry(fn func() bool, config RetryConfig) error {
 			return nil
 		}
 		limit -= 1
-		time.Sleep(wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 		wait = time.Duration(float64(wait) * config.BackoffMultiply)
 	}
 	return fmt.Errorf("retry limit lapsed")
@@ -0,0 +1,149 @@
+// Package access protects the custom views with PocketBase authentication.
+// Admins can access every view. Users of the users collection can only
+// access the views allowed by their roles.
+package access
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tokens"
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+const (
+	// UsersCollection is the auth collection of non-admin users.
+	UsersCollection = "users"
+	// LoginPath is where unauthenticated requests are redirected to.
+	LoginPath = "/login"
+
+	// authCookie holds the auth token, since plain page requests can't set
+	// the Authorization header.
+	authCookie = "oclz_auth"
+)
+
+// Permission allows access to a group of routes.
+type Permission string
+
+const (
+	PermAuthorizeTenants Permission = "tenants:authorize"
+	PermViewPolicies     Permission = "policies:view"
+	PermEditPolicies     Permission = "policies:edit"
+	PermViewJobs         Permission = "jobs:view"
+	PermRunJobs          Permission = "jobs:run"
+)
+
+// Role is a value of the `roles` field of users.
+type Role string
+
+const (
+	RoleViewer   Role = "viewer"
+	RoleOperator Role = "operator"
+	RoleManager  Role = "manager"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleViewer:   {PermViewPolicies, PermViewJobs},
+	RoleOperator: {PermViewPolicies, PermEditPolicies, PermViewJobs, PermRunJobs},
+	RoleManager:  {PermViewPolicies, PermEditPolicies, PermViewJobs, PermRunJobs, PermAuthorizeTenants},
+}
+
+// Identity is the authenticated admin or user of a request.
+type Identity struct {
+	Admin *models.Admin
+	User  *models.Record
+}
+
+// ID returns a unique ID of the identity.
+func (i *Identity) ID() string {
+	if i.Admin != nil {
+		return "admin:" + i.Admin.Id
+	}
+	return UsersCollection + ":" + i.User.Id
+}
+
+// Can returns true if the identity has the permission.
+func (i *Identity) Can(permission Permission) bool {
+	if i.Admin != nil {
+		return true
+	}
+	for _, role := range i.User.GetStringSlice("roles") {
+		for _, p := range rolePermissions[Role(role)] {
+			if p == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IdentityFrom returns the identity of the request, from either the
+// Authorization header or the auth cookie. Returns nil if unauthenticated.
+func IdentityFrom(app core.App, c echo.Context) *Identity {
+	if admin, ok := c.Get(apis.ContextAdminKey).(*models.Admin); ok && admin != nil {
+		return &Identity{Admin: admin}
+	}
+	if record, ok := c.Get(apis.ContextAuthRecordKey).(*models.Record); ok && record != nil {
+		if record.Collection().Name == UsersCollection {
+			return &Identity{User: record}
+		}
+		return nil
+	}
+
+	cookie, err := c.Cookie(authCookie)
+	if err != nil || cookie.Value == "" {
+		return nil
+	}
+	claims, _ := security.ParseUnverifiedJWT(cookie.Value)
+	tokenType, _ := claims["type"].(string)
+	switch tokenType {
+	case tokens.TypeAdmin:
+		admin, err := app.Dao().FindAdminByToken(cookie.Value, app.Settings().AdminAuthToken.Secret)
+		if err == nil && admin != nil {
+			c.Set(apis.ContextAdminKey, admin)
+			return &Identity{Admin: admin}
+		}
+	case tokens.TypeAuthRecord:
+		record, err := app.Dao().FindAuthRecordByToken(cookie.Value, app.Settings().RecordAuthToken.Secret)
+		if err == nil && record != nil && record.Collection().Name == UsersCollection {
+			c.Set(apis.ContextAuthRecordKey, record)
+			return &Identity{User: record}
+		}
+	}
+	return nil
+}
+
+// Require only lets through requests of identities with the permission.
+// Unauthenticated page requests are redirected to the login page.
+func Require(app core.App, permission Permission) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			identity := IdentityFrom(app, c)
+			if identity == nil {
+				if c.Request().Method == http.MethodGet {
+					login := LoginPath + "?next=" + url.QueryEscape(c.Request().URL.RequestURI())
+					return c.Redirect(http.StatusFound, login)
+				}
+				return c.String(http.StatusUnauthorized, "login required")
+			}
+			if !identity.Can(permission) {
+				return c.String(http.StatusForbidden, fmt.Sprintf("missing permission %q", permission))
+			}
+			return next(c)
+		}
+	}
+}
+
+// safeNext returns the path to go to after logging in, which must be local.
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
@@ -0,0 +1,94 @@
+package access
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/tokens"
+)
+
+//go:embed *.html
+var fs embed.FS
+
+// View is the login and logout of the custom views.
+type View struct {
+	App *pocketbase.PocketBase
+}
+
+func (v *View) Hook(parent *echo.Group) error {
+	templates := template.Must(template.ParseFS(fs, "*.html"))
+
+	render := func(c echo.Context, status int, data any) error {
+		var buf bytes.Buffer
+		if err := templates.ExecuteTemplate(&buf, "login.html", data); err != nil {
+			return fmt.Errorf("executing template: %w", err)
+		}
+		return c.HTML(status, buf.String())
+	}
+
+	parent.GET(LoginPath, func(c echo.Context) error {
+		return render(c, http.StatusOK, map[string]any{
+			"Next":  safeNext(c.QueryParam("next")),
+			"Email": "",
+		})
+	})
+	parent.POST(LoginPath, func(c echo.Context) error {
+		next := safeNext(c.FormValue("next"))
+		token, duration, err := v.login(c.FormValue("email"), c.FormValue("password"))
+		if err != nil {
+			return render(c, http.StatusUnauthorized, map[string]any{
+				"Next":  next,
+				"Email": c.FormValue("email"),
+				"Error": "Invalid email or password.",
+			})
+		}
+		v.setCookie(c, token, duration)
+		return c.Redirect(http.StatusFound, next)
+	})
+	parent.POST("/logout", func(c echo.Context) error {
+		v.setCookie(c, "", -1)
+		return c.Redirect(http.StatusFound, LoginPath)
+	})
+
+	return nil
+}
+
+// login authenticates an admin, or else a user, and returns its auth token and
+// the token duration in seconds.
+func (v *View) login(email, password string) (string, int64, error) {
+	dao := v.App.Dao()
+	if admin, err := dao.FindAdminByEmail(email); err == nil && admin.ValidatePassword(password) {
+		token, err := tokens.NewAdminAuthToken(v.App, admin)
+		return token, v.App.Settings().AdminAuthToken.Duration, err
+	}
+	if record, err := dao.FindAuthRecordByEmail(UsersCollection, email); err == nil && record.ValidatePassword(password) {
+		token, err := tokens.NewRecordAuthToken(v.App, record)
+		return token, v.App.Settings().RecordAuthToken.Duration, err
+	}
+	return "", 0, fmt.Errorf("invalid credentials")
+}
+
+// setCookie sets the auth cookie. A negative duration deletes it.
+func (v *View) setCookie(c echo.Context, token string, duration int64) {
+	cookie := &http.Cookie{
+		Name:     authCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		// Lax, so that the cookie is sent on the oauth2 redirects back from
+		// the marketplaces.
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(duration),
+	}
+	if duration > 0 {
+		cookie.Expires = time.Now().Add(time.Duration(duration) * time.Second)
+	}
+	c.SetCookie(cookie)
+}
@@ -3,16 +3,23 @@ package authentication
 
 import (
 	"bytes"
+	"crypto/rand"
 	"embed"
+	"encoding/base64"
 	"fmt"
 	"html/template"
 	"net/http"
+	"sort"
 
 	"github.com/labstack/echo/v5"
+	"github.com/nmcapule/oclz-go/integrations/models"
 	"github.com/nmcapule/oclz-go/oauth2"
 	"github.com/nmcapule/oclz-go/syncer"
 	"github.com/nmcapule/oclz-go/utils"
+	"github.com/nmcapule/oclz-go/views/access"
 	"github.com/pocketbase/pocketbase"
+
+	log "github.com/sirupsen/logrus"
 )
 
 //go:embed *.html
@@ -20,55 +27,212 @@ var fs embed.FS
 
 // View is the main view for the authentication module.
 type View struct {
-	App         *pocketbase.PocketBase
-	Syncer      *syncer.Syncer
+	App *pocketbase.PocketBase
+	// Syncers are the running syncers, keyed by tenant group name.
+	Syncers     map[string]*syncer.Syncer
 	GroupPrefix string
+
+	states oauth2.States
 }
 
+// sessionCookie binds the oauth2 states to the browser that started the
+// authorization.
+const sessionCookie = "oclz_oauth2_session"
+
 func (v *View) Hook(parent *echo.Group) error {
 	templates := template.Must(template.ParseFS(fs, "*.html"))
 
-	base := parent.Group(v.GroupPrefix)
-	base.GET("", func(c echo.Context) error {
+	render := func(c echo.Context, name string, data any) error {
 		var buf bytes.Buffer
-		if err := templates.ExecuteTemplate(&buf, "index.html", map[string]any{
-			"Tenants": v.Syncer.Tenants,
-		}); err != nil {
+		if err := templates.ExecuteTemplate(&buf, name, data); err != nil {
 			return fmt.Errorf("executing template: %w", err)
 		}
 		return c.HTML(http.StatusOK, buf.String())
+	}
+
+	base := parent.Group(v.GroupPrefix, access.Require(v.App, access.PermAuthorizeTenants))
+	base.GET("", func(c echo.Context) error {
+		var groups []string
+		for name := range v.Syncers {
+			groups = append(groups, name)
+		}
+		sort.Strings(groups)
+		return render(c, "groups.html", map[string]any{
+			"Prefix": v.GroupPrefix,
+			"Groups": groups,
+		})
 	})
-	base.GET("/reauth/:name", func(c echo.Context) error {
-		tenant := v.Syncer.Tenants[c.PathParam("name")]
-		redirect := tenant.CredentialsManager().GenerateAuthorizationURL()
+	base.GET("/:group", func(c echo.Context) error {
+		s, ok := v.Syncers[c.PathParam("group")]
+		if !ok {
+			return c.String(http.StatusNotFound, fmt.Sprintf("no tenant group %q", c.PathParam("group")))
+		}
+		return render(c, "index.html", map[string]any{
+			"Prefix":  v.GroupPrefix,
+			"Group":   s.TenantGroupName,
+			"Tenants": s.Tenants(),
+		})
+	})
+	base.GET("/:group/reauth/:name", func(c echo.Context) error {
+		s, ok := v.Syncers[c.PathParam("group")]
+		if !ok {
+			return c.String(http.StatusNotFound, fmt.Sprintf("no tenant group %q", c.PathParam("group")))
+		}
+		tenant, ok := s.Tenant(c.PathParam("name"))
+		if !ok || tenant.CredentialsManager() == nil {
+			return c.String(http.StatusNotFound, fmt.Sprintf("no oauth2 tenant %q", c.PathParam("name")))
+		}
+		session, err := v.session(c)
+		if err != nil {
+			return c.String(http.StatusInternalServerError, fmt.Sprintf("creating session: %v", err))
+		}
+		state, err := v.states.Issue(tenant.Tenant().ID, v.bind(c, session))
+		if err != nil {
+			return c.String(http.StatusInternalServerError, fmt.Sprintf("issuing oauth2 state: %v", err))
+		}
+		redirect := tenant.CredentialsManager().GenerateAuthorizationURL(state)
 		return c.Redirect(http.StatusFound, redirect)
 	})
-	base.GET("/refresh/:name", func(c echo.Context) error {
-		tenant := v.Syncer.Tenants[c.PathParam("name")]
-
-		data := make(map[string]string)
-		queries := c.QueryParams()
-		for key := range queries {
-			data[key] = queries.Get(key)
+	base.GET("/:group/refresh/:name", func(c echo.Context) error {
+		s, ok := v.Syncers[c.PathParam("group")]
+		if !ok {
+			return c.String(http.StatusNotFound, fmt.Sprintf("no tenant group %q", c.PathParam("group")))
 		}
-		credentials, err := tenant.CredentialsManager().GenerateCredentials(*utils.GJSONFrom(data))
+		return v.refresh(c, s)
+	})
+	base.GET("/:group/history/:name", func(c echo.Context) error {
+		s, tenant, err := v.oauth2Tenant(c)
 		if err != nil {
-			return c.String(http.StatusInternalServerError, fmt.Sprintf("generating credentials: %v", err))
+			return c.String(http.StatusNotFound, err.Error())
 		}
-		if credentials.RefreshToken == "" || credentials.AccessToken == "" {
-			return c.String(
-				http.StatusInternalServerError,
-				fmt.Sprintf("got empty tokens! refresh_token=%q, access_token=%q",
-					credentials.RefreshToken,
-					credentials.AccessToken))
+		oauth2Service := oauth2.Service{Dao: s.Dao}
+		versions, err := oauth2Service.History(tenant.Tenant().ID)
+		if err != nil {
+			return c.String(http.StatusInternalServerError, fmt.Sprintf("loading credentials history: %v", err))
 		}
-		oauth2Service := oauth2.Service{Dao: v.Syncer.Dao}
-		if err := oauth2Service.Save(credentials); err != nil {
-			return c.String(http.StatusInternalServerError, fmt.Sprintf("saving credentials: %v", err))
+		// The current credentials are the latest promoted version.
+		var current string
+		for _, version := range versions {
+			if version.Promoted {
+				current = version.ID
+				break
+			}
 		}
-
-		return c.Redirect(http.StatusFound, "/authentication")
+		return render(c, "history.html", map[string]any{
+			"Prefix":   v.GroupPrefix,
+			"Group":    s.TenantGroupName,
+			"Tenant":   tenant.Tenant().Name,
+			"Versions": versions,
+			"Current":  current,
+		})
+	})
+	base.POST("/:group/history/:name/:version/rollback", func(c echo.Context) error {
+		s, tenant, err := v.oauth2Tenant(c)
+		if err != nil {
+			return c.String(http.StatusNotFound, err.Error())
+		}
+		oauth2Service := oauth2.Service{Dao: s.Dao}
+		if _, err := oauth2Service.Rollback(tenant.Tenant().ID, c.PathParam("version")); err != nil {
+			return c.String(http.StatusInternalServerError, fmt.Sprintf("rolling back credentials: %v", err))
+		}
+		s.Logger.WithFields(log.Fields{
+			"tenant":  tenant.Tenant().Name,
+			"version": c.PathParam("version"),
+		}).Infoln("Rolled back credentials")
+		return c.Redirect(http.StatusFound, fmt.Sprintf("%s/%s/history/%s", v.GroupPrefix, s.TenantGroupName, tenant.Tenant().Name))
+	})
+	// Tenant names are unique across groups, so the redirect URIs registered
+	// with the marketplaces can keep pointing here.
+	base.GET("/refresh/:name", func(c echo.Context) error {
+		for _, s := range v.Syncers {
+			if _, ok := s.Tenant(c.PathParam("name")); ok {
+				return v.refresh(c, s)
+			}
+		}
+		return c.String(http.StatusNotFound, fmt.Sprintf("no oauth2 tenant %q", c.PathParam("name")))
 	})
 
 	return nil
 }
+
+// oauth2Tenant returns the syncer and the oauth2 tenant of the path params.
+func (v *View) oauth2Tenant(c echo.Context) (*syncer.Syncer, models.IntegrationClient, error) {
+	s, ok := v.Syncers[c.PathParam("group")]
+	if !ok {
+		return nil, nil, fmt.Errorf("no tenant group %q", c.PathParam("group"))
+	}
+	tenant, ok := s.Tenant(c.PathParam("name"))
+	if !ok || tenant.CredentialsManager() == nil {
+		return nil, nil, fmt.Errorf("no oauth2 tenant %q", c.PathParam("name"))
+	}
+	return s, tenant, nil
+}
+
+// refresh generates and saves new credentials for the tenant from the query
+// params of the oauth2 callback.
+func (v *View) refresh(c echo.Context, s *syncer.Syncer) error {
+	tenant, ok := s.Tenant(c.PathParam("name"))
+	if !ok || tenant.CredentialsManager() == nil {
+		return c.String(http.StatusNotFound, fmt.Sprintf("no oauth2 tenant %q", c.PathParam("name")))
+	}
+
+	// Only accept callbacks of authorizations started from this browser, by
+	// the same admin or user.
+	var session string
+	if cookie, err := c.Cookie(sessionCookie); err == nil {
+		session = cookie.Value
+	}
+	if err := v.states.Verify(c.QueryParam("state"), tenant.Tenant().ID, v.bind(c, session)); err != nil {
+		return c.String(http.StatusForbidden, fmt.Sprintf("%v, authorize %s again", err, tenant.Tenant().Name))
+	}
+
+	data := make(map[string]string)
+	queries := c.QueryParams()
+	for key := range queries {
+		data[key] = queries.Get(key)
+	}
+	credentials, err := tenant.CredentialsManager().GenerateCredentials(c.Request().Context(), *utils.GJSONFrom(data))
+	if err != nil {
+		return c.String(http.StatusInternalServerError, fmt.Sprintf("generating credentials: %v", err))
+	}
+	if err := s.PromoteCredentials(c.Request().Context(), tenant, credentials); err != nil {
+		return c.String(http.StatusInternalServerError, fmt.Sprintf("saving credentials: %v", err))
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("%s/%s", v.GroupPrefix, s.TenantGroupName))
+}
+
+// session returns the oauth2 session of the browser, creating one if needed.
+func (v *View) session(c echo.Context) (string, error) {
+	if cookie, err := c.Cookie(sessionCookie); err == nil && cookie.Value != "" {
+		return cookie.Value, nil
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	session := base64.RawURLEncoding.EncodeToString(raw)
+	c.SetCookie(&http.Cookie{
+		Name:     sessionCookie,
+		Value:    session,
+		Path:     v.GroupPrefix,
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		// Lax, so that the cookie is sent on the redirect back from the
+		// marketplace.
+		SameSite: http.SameSiteLaxMode,
+	})
+	return session, nil
+}
+
+// bind binds the oauth2 session to the logged in admin or user.
+func (v *View) bind(c echo.Context, session string) string {
+	if session == "" {
+		return ""
+	}
+	identity := access.IdentityFrom(v.App, c)
+	if identity == nil {
+		return ""
+	}
+	return identity.ID() + ":" + session
+}
@@ -0,0 +1,133 @@
+// Package jobs contains the views for the background jobs of each syncer.
+package jobs
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo/v5"
+	"github.com/nmcapule/oclz-go/syncer"
+	"github.com/nmcapule/oclz-go/views/access"
+	"github.com/pocketbase/pocketbase"
+)
+
+//go:embed *.html
+var fs embed.FS
+
+// recentRuns is the number of recent runs listed for each tenant group.
+const recentRuns = 20
+
+// View lists the background jobs of each tenant group and their recent runs,
+// triggers runs on demand, and cancels runs in progress.
+type View struct {
+	App *pocketbase.PocketBase
+	// Syncers are the running syncers, keyed by tenant group name.
+	Syncers     map[string]*syncer.Syncer
+	GroupPrefix string
+}
+
+// jobRow is a job with its schedule, last run and run in progress, if any.
+type jobRow struct {
+	syncer.JobStatus
+	Running *syncer.JobRun
+}
+
+func (v *View) Hook(parent *echo.Group) error {
+	templates := template.Must(template.ParseFS(fs, "*.html"))
+
+	render := func(c echo.Context, name string, data any) error {
+		var buf bytes.Buffer
+		if err := templates.ExecuteTemplate(&buf, name, data); err != nil {
+			return fmt.Errorf("executing template: %w", err)
+		}
+		return c.HTML(http.StatusOK, buf.String())
+	}
+
+	base := parent.Group(v.GroupPrefix)
+	canView := access.Require(v.App, access.PermViewJobs)
+	canRun := access.Require(v.App, access.PermRunJobs)
+	base.GET("", func(c echo.Context) error {
+		var groups []string
+		for name := range v.Syncers {
+			groups = append(groups, name)
+		}
+		sort.Strings(groups)
+		return render(c, "groups.html", map[string]any{
+			"Prefix": v.GroupPrefix,
+			"Groups": groups,
+		})
+	}, canView)
+	base.GET("/:group", func(c echo.Context) error {
+		s, ok := v.Syncers[c.PathParam("group")]
+		if !ok {
+			return c.String(http.StatusNotFound, fmt.Sprintf("no tenant group %q", c.PathParam("group")))
+		}
+		running := make(map[syncer.Job]*syncer.JobRun)
+		for _, run := range s.Running() {
+			run := run
+			running[run.Job] = &run
+		}
+		var rows []*jobRow
+		for _, status := range s.Statuses() {
+			rows = append(rows, &jobRow{JobStatus: status, Running: running[status.Job]})
+		}
+		runs, err := s.RecentRuns(recentRuns)
+		if err != nil {
+			return fmt.Errorf("loading recent runs: %w", err)
+		}
+		return render(c, "index.html", map[string]any{
+			"Prefix":  v.GroupPrefix,
+			"Group":   s.TenantGroupName,
+			"Jobs":    rows,
+			"Runs":    runs,
+			"Message": c.QueryParam("message"),
+		})
+	}, canView)
+	base.POST("/:group/:job/cancel", func(c echo.Context) error {
+		s, ok := v.Syncers[c.PathParam("group")]
+		if !ok {
+			return c.String(http.StatusNotFound, fmt.Sprintf("no tenant group %q", c.PathParam("group")))
+		}
+		job := syncer.Job(c.PathParam("job"))
+		message := fmt.Sprintf("Cancelled %s.", job)
+		if !s.Cancel(job) {
+			message = fmt.Sprintf("%s is not running.", job)
+		}
+		u := fmt.Sprintf("%s/%s?message=%s", v.GroupPrefix, s.TenantGroupName, template.URLQueryEscaper(message))
+		return c.Redirect(http.StatusSeeOther, u)
+	}, canRun)
+	// Triggers a run of the job. Responds with the run ID as JSON if asked,
+	// e.g. `curl -H "Accept: application/json" -d seller_sku=SKU-1 ...`.
+	base.POST("/:group/:job/run", func(c echo.Context) error {
+		s, ok := v.Syncers[c.PathParam("group")]
+		if !ok {
+			return c.String(http.StatusNotFound, fmt.Sprintf("no tenant group %q", c.PathParam("group")))
+		}
+		job := syncer.Job(c.PathParam("job"))
+		sellerSKU := strings.TrimSpace(c.FormValue("seller_sku"))
+		runID, err := s.Trigger(job, sellerSKU)
+		if strings.Contains(c.Request().Header.Get(echo.HeaderAccept), echo.MIMEApplicationJSON) {
+			if errors.Is(err, syncer.ErrAlreadyRunning) {
+				return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+			}
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+			}
+			return c.JSON(http.StatusAccepted, map[string]string{"run_id": runID})
+		}
+		message := fmt.Sprintf("Started %s, run %s.", job, runID)
+		if err != nil {
+			message = fmt.Sprintf("Failed to start %s: %v", job, err)
+		}
+		u := fmt.Sprintf("%s/%s?message=%s", v.GroupPrefix, s.TenantGroupName, template.URLQueryEscaper(message))
+		return c.Redirect(http.StatusSeeOther, u)
+	}, canRun)
+
+	return nil
+}
@@ -0,0 +1,219 @@
+// Package policies contains the views for the per-SKU sync policies.
+package policies
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/labstack/echo/v5"
+	"github.com/nmcapule/oclz-go/syncer"
+	"github.com/nmcapule/oclz-go/views/access"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/models"
+)
+
+//go:embed *.html
+var fs embed.FS
+
+// View lists and edits the sync policies of each tenant group.
+type View struct {
+	App *pocketbase.PocketBase
+	// Syncers are the running syncers, keyed by tenant group name.
+	Syncers     map[string]*syncer.Syncer
+	GroupPrefix string
+}
+
+// policyRow is a sync policy with the name of its tenant.
+type policyRow struct {
+	*syncer.SyncPolicy
+	TenantName string
+}
+
+func (v *View) Hook(parent *echo.Group) error {
+	templates := template.Must(template.ParseFS(fs, "*.html"))
+
+	render := func(c echo.Context, name string, data any) error {
+		var buf bytes.Buffer
+		if err := templates.ExecuteTemplate(&buf, name, data); err != nil {
+			return fmt.Errorf("executing template: %w", err)
+		}
+		return c.HTML(http.StatusOK, buf.String())
+	}
+
+	base := parent.Group(v.GroupPrefix)
+	canView := access.Require(v.App, access.PermViewPolicies)
+	canEdit := access.Require(v.App, access.PermEditPolicies)
+	base.GET("", func(c echo.Context) error {
+		var groups []string
+		for name := range v.Syncers {
+			groups = append(groups, name)
+		}
+		sort.Strings(groups)
+		return render(c, "groups.html", map[string]any{
+			"Prefix": v.GroupPrefix,
+			"Groups": groups,
+		})
+	}, canView)
+	base.GET("/:group", func(c echo.Context) error {
+		tenants, err := v.tenants(c.PathParam("group"))
+		if err != nil {
+			return c.String(http.StatusNotFound, err.Error())
+		}
+		policies, err := v.policies(tenants)
+		if err != nil {
+			return c.String(http.StatusInternalServerError, fmt.Sprintf("loading policies: %v", err))
+		}
+		return render(c, "index.html", map[string]any{
+			"Prefix":   v.GroupPrefix,
+			"Group":    c.PathParam("group"),
+			"Tenants":  tenants,
+			"Policies": policies,
+			"Kinds":    []syncer.Policy{syncer.PolicyExclude, syncer.PolicyPin, syncer.PolicyOneWay},
+			"Error":    c.QueryParam("error"),
+		})
+	}, canView)
+	base.POST("/:group", func(c echo.Context) error {
+		collection, err := v.App.Dao().FindCollectionByNameOrId("sync_policies")
+		if err != nil {
+			return c.String(http.StatusInternalServerError, fmt.Sprintf("loading collection: %v", err))
+		}
+		return v.save(c, models.NewRecord(collection))
+	}, canEdit)
+	base.POST("/:group/:id", func(c echo.Context) error {
+		record, err := v.policy(c)
+		if err != nil {
+			return c.String(http.StatusNotFound, err.Error())
+		}
+		return v.save(c, record)
+	}, canEdit)
+	base.POST("/:group/:id/delete", func(c echo.Context) error {
+		record, err := v.policy(c)
+		if err != nil {
+			return c.String(http.StatusNotFound, err.Error())
+		}
+		if err := v.App.Dao().DeleteRecord(record); err != nil {
+			return c.String(http.StatusInternalServerError, fmt.Sprintf("deleting policy: %v", err))
+		}
+		return v.redirect(c, nil)
+	}, canEdit)
+
+	return nil
+}
+
+// tenants returns the tenants of the group, keyed by ID.
+func (v *View) tenants(group string) (map[string]*models.Record, error) {
+	if _, ok := v.Syncers[group]; !ok {
+		return nil, fmt.Errorf("no tenant group %q", group)
+	}
+	record, err := v.App.Dao().FindFirstRecordByData("tenant_groups", "name", group)
+	if err != nil {
+		return nil, fmt.Errorf("no tenant group %q", group)
+	}
+	records, err := v.App.Dao().FindRecordsByExpr("tenants", dbx.HashExp{
+		"tenant_group": record.GetId(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	tenants := make(map[string]*models.Record)
+	for _, tenant := range records {
+		tenants[tenant.GetId()] = tenant
+	}
+	return tenants, nil
+}
+
+// policies returns the policies of the tenants, sorted by seller SKU.
+func (v *View) policies(tenants map[string]*models.Record) ([]*policyRow, error) {
+	var ids []any
+	for id := range tenants {
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	records, err := v.App.Dao().FindRecordsByExpr("sync_policies", dbx.In("tenant", ids...))
+	if err != nil {
+		return nil, err
+	}
+	var rows []*policyRow
+	for _, record := range records {
+		rows = append(rows, &policyRow{
+			SyncPolicy: syncer.SyncPolicyFrom(record),
+			TenantName: tenants[record.GetString("tenant")].GetString("name"),
+		})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].SellerSKU != rows[j].SellerSKU {
+			return rows[i].SellerSKU < rows[j].SellerSKU
+		}
+		return rows[i].TenantName < rows[j].TenantName
+	})
+	return rows, nil
+}
+
+// policy returns the policy record of the path, if it belongs to the group.
+func (v *View) policy(c echo.Context) (*models.Record, error) {
+	tenants, err := v.tenants(c.PathParam("group"))
+	if err != nil {
+		return nil, err
+	}
+	record, err := v.App.Dao().FindRecordById("sync_policies", c.PathParam("id"))
+	if err != nil || tenants[record.GetString("tenant")] == nil {
+		return nil, fmt.Errorf("no policy %q", c.PathParam("id"))
+	}
+	return record, nil
+}
+
+// save saves the submitted form to the policy record.
+func (v *View) save(c echo.Context, record *models.Record) error {
+	tenants, err := v.tenants(c.PathParam("group"))
+	if err != nil {
+		return c.String(http.StatusNotFound, err.Error())
+	}
+	if tenants[c.FormValue("tenant")] == nil {
+		return v.redirect(c, fmt.Errorf("tenant is not in the group"))
+	}
+	// Stocks are only used by pinned policies.
+	stocks, err := strconv.Atoi(c.FormValue("stocks"))
+	if err != nil && syncer.Policy(c.FormValue("policy")) == syncer.PolicyPin {
+		return v.redirect(c, validation.Errors{
+			"stocks": validation.NewError("validation_invalid_stocks", "pinned stocks must be a whole number"),
+		})
+	}
+	record.Set("tenant", c.FormValue("tenant"))
+	record.Set("seller_sku", c.FormValue("seller_sku"))
+	record.Set("policy", c.FormValue("policy"))
+	record.Set("stocks", stocks)
+	record.Set("note", c.FormValue("note"))
+	if err := syncer.ValidateSyncPolicy(v.App.Dao(), record); err != nil {
+		return v.redirect(c, err)
+	}
+	if err := v.App.Dao().SaveRecord(record); err != nil {
+		return v.redirect(c, err)
+	}
+	return v.redirect(c, nil)
+}
+
+// redirect redirects back to the group policies, showing the error if any.
+func (v *View) redirect(c echo.Context, err error) error {
+	u := fmt.Sprintf("%s/%s", v.GroupPrefix, c.PathParam("group"))
+	if err != nil {
+		var apiErr *apis.ApiError
+		if errors.As(err, &apiErr) {
+			if raw, ok := apiErr.RawData().(error); ok {
+				err = raw
+			}
+		}
+		u += "?error=" + template.URLQueryEscaper(err.Error())
+	}
+	return c.Redirect(http.StatusSeeOther, u)
+}
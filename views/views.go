@@ -6,7 +6,10 @@ import (
 
 	"github.com/labstack/echo/v5"
 	"github.com/nmcapule/oclz-go/syncer"
+	"github.com/nmcapule/oclz-go/views/access"
 	"github.com/nmcapule/oclz-go/views/authentication"
+	"github.com/nmcapule/oclz-go/views/jobs"
+	"github.com/nmcapule/oclz-go/views/policies"
 	"github.com/pocketbase/pocketbase"
 )
 
@@ -16,20 +19,34 @@ type hooker interface {
 
 // RootView is the root view for the application.
 type RootView struct {
-	App    *pocketbase.PocketBase
-	Syncer *syncer.Syncer
+	App     *pocketbase.PocketBase
+	Syncers map[string]*syncer.Syncer
 }
 
 // Hook hooks up the echo HTTP router with the defined views.
 func (r *RootView) Hook(e *echo.Echo) error {
 	root := e.Group("")
 
+	// Every other module must protect its routes with access.Require.
 	modules := []hooker{
+		&access.View{
+			App: r.App,
+		},
 		&authentication.View{
 			App:         r.App,
-			Syncer:      r.Syncer,
+			Syncers:     r.Syncers,
 			GroupPrefix: "/authentication",
 		},
+		&policies.View{
+			App:         r.App,
+			Syncers:     r.Syncers,
+			GroupPrefix: "/policies",
+		},
+		&jobs.View{
+			App:         r.App,
+			Syncers:     r.Syncers,
+			GroupPrefix: "/jobs",
+		},
 	}
 	for _, m := range modules {
 		if err := m.Hook(root); err != nil {